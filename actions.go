@@ -0,0 +1,535 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os/exec"
+	"runtime"
+	"sort"
+	"strings"
+)
+
+// buttonAction is what a button does when it is pressed
+type buttonAction interface {
+	Run() error
+	String() string
+}
+
+// buttonActionFactory builds an action from the settings of its button_mapping entry
+type buttonActionFactory func(params *actionParams) (buttonAction, error)
+
+var buttonActionFactories = make(map[string]buttonActionFactory)
+
+// registerButtonAction makes an action usable as `action: <name>` in button_mapping
+func registerButtonAction(name string, factory buttonActionFactory) {
+	buttonActionFactories[name] = factory
+}
+
+func init() {
+	registerButtonAction("key", newKeyAction)
+	registerButtonAction("mute_toggle", newMuteToggleAction)
+	registerButtonAction("mute_current_window", newMuteCurrentWindowAction)
+	registerButtonAction("mute_slider", newMuteSliderAction)
+	registerButtonAction("run", newRunAction)
+	registerButtonAction("switch_output_device", newSwitchOutputDeviceAction)
+	registerButtonAction("switch_profile", newSwitchProfileAction)
+	registerButtonAction("cycle_profiles", newCycleProfilesAction)
+	registerButtonAction("media", newMediaAction)
+	registerButtonAction("set_volume", newSetVolumeAction)
+}
+
+// newButtonAction builds the action of a button_mapping entry. A key name or code on
+// its own is a key press; anything else is a mapping with the action's name under
+// `action` and its settings next to it.
+func newButtonAction(value interface{}) (buttonAction, error) {
+	switch value := value.(type) {
+	case int, string:
+		return newKeyAction(&actionParams{values: map[string]interface{}{"key": value}})
+	case map[string]interface{}:
+		params := &actionParams{values: value}
+		name, err := params.String("action")
+		if err != nil {
+			return nil, err
+		}
+		factory, ok := buttonActionFactories[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown action %q%s", name, suggest(name, buttonActionNames()))
+		}
+
+		action, err := factory(params)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		if unknown := params.unused(); len(unknown) > 0 {
+			return nil, fmt.Errorf("%s: unknown setting %q", name, unknown[0])
+		}
+		return action, nil
+	}
+	return nil, fmt.Errorf("expected a key like F13 or a mapping with an action, not %v", value)
+}
+
+// buttonActionNames returns the registered actions in order
+func buttonActionNames() []string {
+	names := make([]string, 0, len(buttonActionFactories))
+	for name := range buttonActionFactories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// runButtonAction runs a pressed button's action, logging when it fails
+func runButtonAction(buttonNum int, action buttonAction) {
+	if verbose {
+		fmt.Printf("[Button %d] %s\n", buttonNum, action)
+	}
+	if err := action.Run(); err != nil {
+		log.Printf("Error running action of button %d (%s): %v", buttonNum, action, err)
+	}
+}
+
+// actionParams are the settings of one button_mapping entry. Factories take what they
+// need, and whatever is left over is reported as unknown.
+type actionParams struct {
+	values map[string]interface{}
+	used   map[string]bool
+}
+
+func (p *actionParams) value(name string) (interface{}, bool) {
+	if p.used == nil {
+		p.used = make(map[string]bool)
+	}
+	p.used[name] = true
+
+	value, ok := p.values[name]
+	return value, ok && value != nil
+}
+
+// String returns a required, non-empty string setting
+func (p *actionParams) String(name string) (string, error) {
+	value, ok := p.value(name)
+	if !ok {
+		return "", fmt.Errorf("%s is missing", name)
+	}
+	s, isString := value.(string)
+	if !isString || strings.TrimSpace(s) == "" {
+		return "", fmt.Errorf("%s must be a name, not %v", name, value)
+	}
+	return strings.TrimSpace(s), nil
+}
+
+// Strings returns a required setting that is a single string or a list of them
+func (p *actionParams) Strings(name string) ([]string, error) {
+	value, ok := p.value(name)
+	if !ok {
+		return nil, fmt.Errorf("%s is missing", name)
+	}
+
+	items, isList := value.([]interface{})
+	if !isList {
+		items = []interface{}{value}
+	}
+	var strs []string
+	for _, item := range items {
+		s, isString := item.(string)
+		if !isString || strings.TrimSpace(s) == "" {
+			return nil, fmt.Errorf("%s: %v is not a name", name, item)
+		}
+		strs = append(strs, strings.TrimSpace(s))
+	}
+	if len(strs) == 0 {
+		return nil, fmt.Errorf("%s is empty", name)
+	}
+	return strs, nil
+}
+
+// OptionalStrings is Strings for a setting that may be left out
+func (p *actionParams) OptionalStrings(name string) ([]string, error) {
+	if _, ok := p.values[name]; !ok {
+		p.value(name)
+		return nil, nil
+	}
+	return p.Strings(name)
+}
+
+// Int returns a required whole number setting
+func (p *actionParams) Int(name string) (int, error) {
+	value, ok := p.value(name)
+	if !ok {
+		return 0, fmt.Errorf("%s is missing", name)
+	}
+	i, isInt := value.(int)
+	if !isInt {
+		return 0, fmt.Errorf("%s must be a whole number, not %v", name, value)
+	}
+	return i, nil
+}
+
+// Targets returns slider targets, checked the same way slider_mapping is
+func (p *actionParams) Targets(name string) ([]string, error) {
+	targets, err := p.Strings(name)
+	if err != nil {
+		return nil, err
+	}
+	for _, target := range targets {
+		if problem := checkTarget(target); problem != "" {
+			return nil, fmt.Errorf("%s: %s", name, problem)
+		}
+	}
+	return targets, nil
+}
+
+func (p *actionParams) unused() []string {
+	var names []string
+	for name := range p.values {
+		if !p.used[name] {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// A key press, with modifiers
+func newKeyAction(params *actionParams) (buttonAction, error) {
+	value, ok := params.value("key")
+	if !ok {
+		return nil, fmt.Errorf("key is missing")
+	}
+
+	switch value := value.(type) {
+	case int:
+		if !validKeyCode(value) {
+			return nil, fmt.Errorf("%d is not a valid key code on %s", value, runtime.GOOS)
+		}
+		return keyCombo{Code: value}, nil
+	case string:
+		combo, err := parseKeyCombo(value)
+		if err != nil {
+			return nil, err
+		}
+		return combo, nil
+	}
+	return nil, fmt.Errorf("%v is not a key", value)
+}
+
+func (k keyCombo) Run() error {
+	sendKeyPress(k)
+	return nil
+}
+
+// mediaKeys are the keys behind `action: media`
+var mediaKeys = map[string]string{
+	"play_pause":  "MEDIA_PLAY_PAUSE",
+	"next":        "MEDIA_NEXT_TRACK",
+	"previous":    "MEDIA_PREV_TRACK",
+	"stop":        "MEDIA_STOP",
+	"volume_up":   "VOLUME_UP",
+	"volume_down": "VOLUME_DOWN",
+	"mute":        "VOLUME_MUTE",
+}
+
+func newMediaAction(params *actionParams) (buttonAction, error) {
+	name, err := params.String("media")
+	if err != nil {
+		return nil, err
+	}
+
+	keyName, ok := mediaKeys[strings.ToLower(name)]
+	if !ok {
+		names := make([]string, 0, len(mediaKeys))
+		for mediaName := range mediaKeys {
+			names = append(names, mediaName)
+		}
+		sort.Strings(names)
+		return nil, fmt.Errorf("unknown media key %q (use %s)", name, strings.Join(names, ", "))
+	}
+	code, ok := keyCodes[keyName]
+	if !ok {
+		return nil, fmt.Errorf("media keys aren't available on %s", runtime.GOOS)
+	}
+	return keyCombo{Code: code, Name: keyName}, nil
+}
+
+// muteToggleAction mutes its targets, or unmutes them when all of them are muted already
+type muteToggleAction struct {
+	targets []string
+}
+
+func newMuteToggleAction(params *actionParams) (buttonAction, error) {
+	targets, err := params.Targets("target")
+	if err != nil {
+		return nil, err
+	}
+	return muteToggleAction{targets: targets}, nil
+}
+
+func (a muteToggleAction) String() string {
+	return "mute_toggle " + strings.Join(a.targets, ", ")
+}
+
+// muteCurrentWindowAction toggles mute of the foreground app
+type muteCurrentWindowAction struct {
+	muteToggleAction
+}
+
+func newMuteCurrentWindowAction(params *actionParams) (buttonAction, error) {
+	return muteCurrentWindowAction{muteToggleAction{targets: []string{"deej.current"}}}, nil
+}
+
+func (a muteCurrentWindowAction) String() string {
+	return "mute_current_window"
+}
+
+// muteSliderAction toggles mute of what a slider controls in the active layer and
+// profile
+type muteSliderAction struct {
+	slider int
+}
+
+func newMuteSliderAction(params *actionParams) (buttonAction, error) {
+	slider, err := params.Int("slider")
+	if err != nil {
+		return nil, err
+	}
+	if slider < 0 {
+		return nil, fmt.Errorf("slider must not be negative, not %d", slider)
+	}
+	return muteSliderAction{slider: slider}, nil
+}
+
+func (a muteSliderAction) String() string {
+	return fmt.Sprintf("mute_slider %d", a.slider)
+}
+
+func (a muteSliderAction) Run() error {
+	targets := getSliderTargets(a.slider)
+	if len(targets) == 0 {
+		return fmt.Errorf("slider %d isn't mapped", a.slider)
+	}
+	return muteToggleAction{targets: targets}.Run()
+}
+
+func (a muteToggleAction) Run() error {
+	allMuted, found := true, false
+	for _, target := range a.targets {
+		if muted, exists := getTargetMute(target); exists {
+			found = true
+			allMuted = allMuted && muted
+		}
+	}
+	if !found {
+		return fmt.Errorf("none of %s is playing audio", strings.Join(a.targets, ", "))
+	}
+
+	muted := !allMuted
+	for _, target := range a.targets {
+		if err := setTargetMute(target, muted); err != nil {
+			return err
+		}
+	}
+	if verbose {
+		fmt.Printf("[Mute] %s muted: %t\n", strings.Join(a.targets, ", "), muted)
+	}
+	return nil
+}
+
+// targetSessions matches the sessions an application target controls
+func targetSessions(target string) (SessionMatcher, error) {
+	switch target {
+	case "deej.current":
+		processName, err := getCurrentProcessName()
+		if err != nil {
+			return nil, err
+		}
+		return matchProcessName(processName), nil
+	case "deej.unmapped":
+		return unmappedSessions(), nil
+	}
+	if isProcessTarget(target) {
+		return sessionMatcher(target), nil
+	}
+	return nil, fmt.Errorf("%q is not an audio target", target)
+}
+
+// setVolumeAction sets its targets to a fixed volume
+type setVolumeAction struct {
+	targets []string
+	volume  int
+}
+
+func newSetVolumeAction(params *actionParams) (buttonAction, error) {
+	targets, err := params.Targets("target")
+	if err != nil {
+		return nil, err
+	}
+	volume, err := params.Int("volume")
+	if err != nil {
+		return nil, err
+	}
+	if volume < 0 || volume > 100 {
+		return nil, fmt.Errorf("volume must be between 0 and 100, not %d", volume)
+	}
+	return setVolumeAction{targets: targets, volume: volume}, nil
+}
+
+func (a setVolumeAction) String() string {
+	return fmt.Sprintf("set_volume %s to %d%%", strings.Join(a.targets, ", "), a.volume)
+}
+
+func (a setVolumeAction) Run() error {
+	for _, target := range a.targets {
+		setTargetVolume(target, a.volume)
+	}
+	return nil
+}
+
+// runAction starts a program without waiting for it
+type runAction struct {
+	command string
+	args    []string
+}
+
+func newRunAction(params *actionParams) (buttonAction, error) {
+	command, err := params.String("command")
+	if err != nil {
+		return nil, err
+	}
+	args, err := params.OptionalStrings("args")
+	if err != nil {
+		return nil, err
+	}
+	return runAction{command: command, args: args}, nil
+}
+
+func (a runAction) String() string {
+	return strings.Join(append([]string{"run", a.command}, a.args...), " ")
+}
+
+func (a runAction) Run() error {
+	cmd := exec.Command(a.command, a.args...)
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to start %s: %w", a.command, err)
+	}
+
+	// Reap it whenever it exits
+	go cmd.Wait()
+	return nil
+}
+
+// switchOutputDeviceAction makes the next of its devices the default output. Devices
+// are matched by ID or name, or by part of the name.
+type switchOutputDeviceAction struct {
+	devices []string
+}
+
+func newSwitchOutputDeviceAction(params *actionParams) (buttonAction, error) {
+	devices, err := params.Strings("devices")
+	if err != nil {
+		return nil, err
+	}
+	return switchOutputDeviceAction{devices: devices}, nil
+}
+
+func (a switchOutputDeviceAction) String() string {
+	return "switch_output_device " + strings.Join(a.devices, ", ")
+}
+
+func (a switchOutputDeviceAction) Run() error {
+	devices, err := audio.Devices()
+	if err != nil {
+		return fmt.Errorf("failed to list audio devices: %w", err)
+	}
+
+	// Devices that aren't connected are skipped
+	var candidates []AudioDevice
+	for _, name := range a.devices {
+		for _, device := range devices {
+			if !device.Capture && deviceMatchesName(device, name) {
+				candidates = append(candidates, device)
+				break
+			}
+		}
+	}
+	if len(candidates) == 0 {
+		return fmt.Errorf("none of %s is connected", strings.Join(a.devices, ", "))
+	}
+
+	next := candidates[0]
+	for i, device := range candidates {
+		if device.Default {
+			next = candidates[(i+1)%len(candidates)]
+			break
+		}
+	}
+
+	if err := audio.SetDefaultDevice(next.ID); err != nil {
+		return fmt.Errorf("failed to switch to %s: %w", next.Name, err)
+	}
+	if verbose {
+		fmt.Printf("[Output] Switched to %s\n", next.Name)
+	}
+	return nil
+}
+
+func deviceMatchesName(device AudioDevice, name string) bool {
+	name = strings.ToLower(name)
+	return strings.ToLower(device.ID) == name || strings.Contains(strings.ToLower(device.Name), name)
+}
+
+// switchProfileAction switches to a named profile
+type switchProfileAction struct {
+	profile string
+}
+
+func newSwitchProfileAction(params *actionParams) (buttonAction, error) {
+	profile, err := params.String("profile")
+	if err != nil {
+		return nil, err
+	}
+	return switchProfileAction{profile: profile}, nil
+}
+
+func (a switchProfileAction) String() string {
+	return "switch_profile " + a.profile
+}
+
+func (a switchProfileAction) Run() error {
+	return switchProfile(a.profile)
+}
+
+func (a switchProfileAction) profileNames() []string {
+	return []string{a.profile}
+}
+
+// cycleProfilesAction switches to the next of some profiles, or of all of them
+type cycleProfilesAction struct {
+	profiles []string // empty for all profiles
+}
+
+func newCycleProfilesAction(params *actionParams) (buttonAction, error) {
+	names, err := params.OptionalStrings("profiles")
+	if err != nil {
+		return nil, err
+	}
+	return cycleProfilesAction{profiles: names}, nil
+}
+
+func (a cycleProfilesAction) String() string {
+	if len(a.profiles) == 0 {
+		return "cycle_profiles"
+	}
+	return "cycle_profiles " + strings.Join(a.profiles, ", ")
+}
+
+func (a cycleProfilesAction) Run() error {
+	names := a.profiles
+	if len(names) == 0 {
+		names = currentConfig().profileNames()
+	}
+	return cycleProfiles(names)
+}
+
+func (a cycleProfilesAction) profileNames() []string {
+	return a.profiles
+}
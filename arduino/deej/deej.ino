@@ -46,6 +46,7 @@ uint16_t currentLine = 0;
 bool imageOnScreen = false;
 unsigned long lastImageRequest = 0;
 unsigned long lastAction = 0;
+String layerName = ""; // Active button layer, empty for the base mapping
 
 // Sliders
 const int NUM_SLIDERS = 1;
@@ -58,20 +59,52 @@ uint8_t lastSliderValues[NUM_SLIDERS];
 uint8_t secondLastSliderValues[NUM_SLIDERS];
 bool sliderActive[NUM_SLIDERS];
 int lastSliderActive;
+bool sliderMuted[NUM_SLIDERS]; // The slider's targets are muted on the PC
 
 const char* sliderNames[] = {"Master Volume", "Aktuelles Fenter", "Discord", "Musik", "Alles andere", "Mikrofon"};
 
 // Buttons
 const int NUM_BUTTONS = 6;
 const int buttonInputs[NUM_BUTTONS] = { 13, 12, 11, 10, 1, 0 };
-bool buttonValues[NUM_BUTTONS];
-bool lastButtonValues[NUM_BUTTONS];
+const unsigned long BUTTON_DEBOUNCE_MS = 20;
+bool buttonStates[NUM_BUTTONS];               // Debounced state
+bool lastButtonReadings[NUM_BUTTONS];
+unsigned long buttonChangedAt[NUM_BUTTONS];
+int8_t buttonEdges[NUM_BUTTONS];              // Not yet sent: -1 none, 1 pressed, 0 released
 
 // Serial communication
 String serialBuffer = "";
 unsigned long lastSerialSend = 0;
 const unsigned long SERIAL_SEND_INTERVAL = 25; // Minimum ms between sends
 
+// Framed protocol (must match protocol.go):
+// SOF | LEN (uint16 LE) | TYPE | SEQ | PAYLOAD | CRC16-CCITT (uint16 LE, over LEN..PAYLOAD)
+// The board speaks text until the host sends HELLO, so older hosts keep working.
+#define PROTOCOL_VERSION   1
+#define BOARD_FLAG_RELEASES 0x01 // Buttons report going up as well as down
+#define FRAME_SOF          0xA5
+#define FRAME_MAX_PAYLOAD  256
+
+#define FRAME_HELLO         0x01
+#define FRAME_HELLO_ACK     0x02
+#define FRAME_INPUT         0x10
+#define FRAME_SET           0x20
+#define FRAME_ACK           0x21
+#define FRAME_PING          0x30
+#define FRAME_PONG          0x31
+#define FRAME_IMAGE_REQUEST 0x40
+#define FRAME_IMAGE_BEGIN   0x41
+#define FRAME_IMAGE_DATA    0x42
+#define FRAME_TRACK_INFO    0x43
+#define FRAME_NO_IMAGE      0x44
+#define FRAME_LAYER         0x45
+#define FRAME_MUTE          0x46
+#define FRAME_ERROR         0x7F
+
+bool framed = false;
+uint8_t frameSeq = 0;
+uint8_t framePayload[FRAME_MAX_PAYLOAD];
+
 void setup() {
   // Slider Setup
   for (int i = 0; i < NUM_SLIDERS; i++) {
@@ -85,7 +118,9 @@ void setup() {
   // Setup Buttons
   for (int i = 0; i < NUM_BUTTONS; i++) {
     pinMode(buttonInputs[i], INPUT);
-    lastButtonValues[i] = false;
+    buttonStates[i] = false;
+    lastButtonReadings[i] = false;
+    buttonEdges[i] = -1;
   }
   
   // Initialize TFT display
@@ -140,6 +175,11 @@ void loop() {
 
 void handleIncomingSerial() {
   while (Serial.available() > 0) {
+    if (Serial.peek() == FRAME_SOF) {
+      handleFrame();
+      continue;
+    }
+
     serialBuffer = Serial.readStringUntil('\n');
 
     // Process complete line
@@ -161,6 +201,8 @@ void processSerialCommand(String command) {
   // Examples:
   //   SET:0:75    - Set slider 0 to 75%
   //   PING        - Respond with PONG
+  //   LAYER:1:fn  - Layer 1, named fn, is active (LAYER:0: for none)
+  //   MUTE:0:1    - Slider 0's targets are muted (MUTE:0:0 when they aren't)
   
   int firstColon = command.indexOf(':');
   String cmd = command.substring(0, firstColon);
@@ -182,6 +224,21 @@ void processSerialCommand(String command) {
     }
   } else if (cmd == "PING") {
     Serial.println("PONG");
+  } else if (cmd == "LAYER") {
+    int secondColon = command.indexOf(':', firstColon + 1);
+    if (secondColon < 0) {
+      Serial.println("ERROR:INVALID_PARAMS");
+    } else {
+      showLayer(command.substring(secondColon + 1));
+    }
+  } else if (cmd == "MUTE") {
+    int secondColon = command.indexOf(':', firstColon + 1);
+    int slider = command.substring(firstColon + 1, secondColon).toInt();
+    if (secondColon < 0 || slider < 0 || slider >= NUM_SLIDERS) {
+      Serial.println("ERROR:INVALID_PARAMS");
+    } else {
+      showMute(slider, command.substring(secondColon + 1).toInt() == 1);
+    }
   } else if (cmd == "IMG") {
     currentIMGState = READING_SIZE;
     handleIMGSend();
@@ -192,6 +249,11 @@ void processSerialCommand(String command) {
 }
 
 void sendValues() {
+  if (framed) {
+    sendValuesFrame();
+    return;
+  }
+
   String builtString = "";
   bool firstValue = true;
 
@@ -210,17 +272,18 @@ void sendValues() {
     } 
   }
   
-  // Buttons
+  // Buttons: 1 when pressed, 0 when released
   for (int i = 0; i < NUM_BUTTONS; i++) {
-    if (buttonValues[i]) {
+    if (buttonEdges[i] >= 0) {
       if (!firstValue) { builtString += "|"; }
 
       builtString += "b";
       builtString += String(i);
       builtString += "v";
-      builtString += String((int)buttonValues[i]);
+      builtString += String((int)buttonEdges[i]);
 
       firstValue = false;
+      buttonEdges[i] = -1; // Reset after sending
     }
   }
 
@@ -229,6 +292,180 @@ void sendValues() {
   }
 }
 
+void sendValuesFrame() {
+  uint8_t payload[(NUM_SLIDERS + NUM_BUTTONS) * 3];
+  uint16_t length = 0;
+
+  for (int i = 0; i < NUM_SLIDERS; i++) {
+    if (sliderActive[i]) {
+      payload[length++] = 's';
+      payload[length++] = i;
+      payload[length++] = percentSliderValues[i];
+      sliderActive[i] = false; // Reset after sending
+    }
+  }
+
+  for (int i = 0; i < NUM_BUTTONS; i++) {
+    if (buttonEdges[i] >= 0) {
+      payload[length++] = 'b';
+      payload[length++] = i;
+      payload[length++] = buttonEdges[i];
+      buttonEdges[i] = -1; // Reset after sending
+    }
+  }
+
+  if (length > 0) {
+    sendFrame(FRAME_INPUT, frameSeq++, payload, length);
+  }
+}
+
+// ===== FRAMED PROTOCOL =====
+
+uint16_t crc16Update(uint16_t crc, const uint8_t* data, uint16_t length) {
+  for (uint16_t i = 0; i < length; i++) {
+    crc ^= (uint16_t)data[i] << 8;
+    for (uint8_t bit = 0; bit < 8; bit++) {
+      if (crc & 0x8000) { crc = (crc << 1) ^ 0x1021; }
+      else { crc <<= 1; }
+    }
+  }
+  return crc;
+}
+
+void sendFrame(uint8_t type, uint8_t seq, const uint8_t* payload, uint16_t length) {
+  uint8_t header[4] = { (uint8_t)(length & 0xFF), (uint8_t)(length >> 8), type, seq };
+  uint16_t crc = crc16Update(0xFFFF, header, 4);
+  crc = crc16Update(crc, payload, length);
+
+  Serial.write(FRAME_SOF);
+  Serial.write(header, 4);
+  if (length > 0) {
+    Serial.write(payload, length);
+  }
+  Serial.write((uint8_t)(crc & 0xFF));
+  Serial.write((uint8_t)(crc >> 8));
+}
+
+// Reads one frame; frames that are cut off or fail the checksum are dropped
+void handleFrame() {
+  Serial.read(); // SOF
+
+  uint8_t header[4];
+  if (Serial.readBytes(header, 4) != 4) { return; }
+
+  uint16_t length = header[0] | ((uint16_t)header[1] << 8);
+  if (length > FRAME_MAX_PAYLOAD) { return; }
+  if (Serial.readBytes(framePayload, length) != length) { return; }
+
+  uint8_t crcBytes[2];
+  if (Serial.readBytes(crcBytes, 2) != 2) { return; }
+
+  uint16_t crc = crc16Update(0xFFFF, header, 4);
+  crc = crc16Update(crc, framePayload, length);
+  if (crc != (crcBytes[0] | ((uint16_t)crcBytes[1] << 8))) { return; }
+
+  processFrame(header[2], header[3], framePayload, length);
+}
+
+void processFrame(uint8_t type, uint8_t seq, uint8_t* payload, uint16_t length) {
+  switch (type) {
+    case FRAME_HELLO: {
+      framed = true;
+      uint8_t info[8] = {
+        PROTOCOL_VERSION, NUM_SLIDERS, NUM_BUTTONS,
+        IMAGE_WIDTH & 0xFF, IMAGE_WIDTH >> 8,
+        IMAGE_HEIGHT & 0xFF, IMAGE_HEIGHT >> 8,
+        BOARD_FLAG_RELEASES
+      };
+      sendFrame(FRAME_HELLO_ACK, seq, info, sizeof(info));
+      break;
+    }
+
+    case FRAME_SET: {
+      uint8_t ack[3] = { 1, 0, 0 };
+      if (length == 2) {
+        ack[1] = payload[0];
+        ack[2] = payload[1];
+        if (payload[0] < NUM_SLIDERS && payload[1] <= 100) {
+          sliderGoTo(payload[1], payload[0]);
+          ack[0] = 0;
+        }
+      }
+      sendFrame(FRAME_ACK, seq, ack, sizeof(ack));
+      break;
+    }
+
+    case FRAME_PING:
+      sendFrame(FRAME_PONG, seq, NULL, 0);
+      break;
+
+    case FRAME_IMAGE_BEGIN:
+      if (length < 8) { break; }
+      imageSize = (uint32_t)payload[4] | ((uint32_t)payload[5] << 8) |
+                  ((uint32_t)payload[6] << 16) | ((uint32_t)payload[7] << 24);
+      currentLine = 0;
+      pixelsReceived = 0;
+      clearImageArea();
+      currentIMGState = RECEIVING_IMAGE;
+      break;
+
+    case FRAME_IMAGE_DATA: {
+      // The host sends one line per chunk
+      if (currentIMGState != RECEIVING_IMAGE || length != 4 + IMAGE_WIDTH * 2) { break; }
+      uint32_t offset = (uint32_t)payload[0] | ((uint32_t)payload[1] << 8) |
+                        ((uint32_t)payload[2] << 16) | ((uint32_t)payload[3] << 24);
+      currentLine = offset / (IMAGE_WIDTH * 2);
+      pixelsReceived = currentLine * IMAGE_WIDTH;
+      memcpy(lineBuffer, payload + 4, IMAGE_WIDTH * 2);
+      drawLine();
+      break;
+    }
+
+    case FRAME_TRACK_INFO: {
+      String text = "";
+      for (uint16_t i = 0; i < length; i++) {
+        text += (char)payload[i];
+      }
+      int tab = text.indexOf('\t');
+      String title = tab >= 0 ? text.substring(0, tab) : text;
+      String artist = tab >= 0 ? text.substring(tab + 1) : "";
+      drawTrackInfo(title, artist);
+      break;
+    }
+
+    case FRAME_NO_IMAGE:
+      currentIMGState = IDLE;
+      break;
+
+    case FRAME_LAYER: {
+      // Layer number, then its name
+      String name = "";
+      for (uint16_t i = 1; i < length; i++) {
+        name += (char)payload[i];
+      }
+      showLayer(name);
+      break;
+    }
+
+    case FRAME_MUTE: {
+      // Slider, then 1 if its targets are muted
+      if (length < 2 || payload[0] >= NUM_SLIDERS) {
+        const char error[] = "INVALID_PARAMS";
+        sendFrame(FRAME_ERROR, seq, (const uint8_t*)error, sizeof(error) - 1);
+        break;
+      }
+      showMute(payload[0], payload[1] == 1);
+      break;
+    }
+
+    default: {
+      const char error[] = "UNKNOWN_FRAME";
+      sendFrame(FRAME_ERROR, seq, (const uint8_t*)error, sizeof(error) - 1);
+      break;
+    }
+  }
+}
+
 // ===== HARDWARE READING =====
 
 bool readValues() {
@@ -236,22 +473,29 @@ bool readValues() {
   
   // Read Buttons
   for (int i = 0; i < NUM_BUTTONS; i++) { 
-    bool currentState = digitalRead(buttonInputs[i]);
-    
-    // Only trigger on button press (transition from LOW to HIGH)
-    if (currentState && !lastButtonValues[i]) {
-      buttonValues[i] = true;
+    bool reading = digitalRead(buttonInputs[i]);
+    unsigned long now = millis();
+
+    if (reading != lastButtonReadings[i]) {
+      buttonChangedAt[i] = now;
+      lastButtonReadings[i] = reading;
+    }
+
+    // Report both edges once the contact has settled
+    if (reading != buttonStates[i] && now - buttonChangedAt[i] >= BUTTON_DEBOUNCE_MS) {
+      buttonStates[i] = reading;
+      buttonEdges[i] = reading ? 1 : 0;
+      if (reading) { lastAction = now; }
+    }
+
+    // Edges held back by the send interval are still pending
+    if (buttonEdges[i] >= 0) {
       changed = true;
-      lastAction = millis();
-    } else {
-      buttonValues[i] = false;
     }
-    
-    lastButtonValues[i] = currentState;
   }
 
   // Read Sliders
-  changed = changed || updateSliderValues();
+  changed = updateSliderValues() || changed;
 
   return changed;
 }
@@ -379,7 +623,7 @@ void haltSliders() {
 void delegateDisplay(uint8_t percentage, uint8_t slider) {
   if (currentScreenState == PERCENTAGE) {
     if (lastSliderActive == slider) { updatePercentageSameSlider(percentage); }
-    else { updatePercentage(percentage, slider); }
+    else { updatePercentage(percentage, slider); drawMuteState(slider); }
   } else { displayPercentage(percentage, slider); }
   imageOnScreen = false;
 }
@@ -399,6 +643,8 @@ void displayPercentage(uint8_t percentage, uint8_t slider) {
 
   updatePercentage(percentage, slider);
   currentScreenState = PERCENTAGE;
+  drawLayerName();
+  drawMuteState(slider);
 }
 
 void updatePercentage(uint8_t percentage, uint8_t slider) {
@@ -447,6 +693,11 @@ void handleIMGSend() {
     currentScreenState = IDLE_SCREEN;
   }
 
+  // In the framed protocol, image frames are handled by handleIncomingSerial
+  if (framed) {
+    return;
+  }
+
   // For other states, wait for serial data
   while (Serial.available() > 0) {
     switch (currentIMGState) {
@@ -474,8 +725,12 @@ void handleIMGSend() {
 
 void handleData() {
   String title = Serial.readStringUntil('\t');
-  title.trim();
   String artist = Serial.readStringUntil('\n');
+  drawTrackInfo(title, artist);
+}
+
+void drawTrackInfo(String title, String artist) {
+  title.trim();
   artist.trim();
 
   delay(100);
@@ -552,14 +807,74 @@ void handleHeader() {
 
   if (input == "IMG") {
     currentIMGState = READING_SIZE;
-    delay(100);
-    tft.fillRect(0, IMAGE_Y, IMAGE_WIDTH, IMAGE_HEIGHT, ST77XX_BLACK);
-    tft.fillRect(IMAGE_X + IMAGE_WIDTH, IMAGE_Y, IMAGE_WIDTH, IMAGE_HEIGHT, ST77XX_BLACK);
+    clearImageArea();
   } else {
     currentIMGState = IDLE;
   }
 }
 
+void clearImageArea() {
+  delay(100);
+  tft.fillRect(0, IMAGE_Y, IMAGE_WIDTH, IMAGE_HEIGHT, ST77XX_BLACK);
+  tft.fillRect(IMAGE_X + IMAGE_WIDTH, IMAGE_Y, IMAGE_WIDTH, IMAGE_HEIGHT, ST77XX_BLACK);
+  drawLayerName();
+  drawMuteState(lastSliderActive);
+}
+
+void showLayer(String name) {
+  name.trim();
+  layerName = name;
+  drawLayerName();
+}
+
+void showMute(uint8_t slider, bool muted) {
+  sliderMuted[slider] = muted;
+  drawMuteState(lastSliderActive);
+}
+
+// Under the layer name: on the percentage screen whether the slider shown is muted,
+// on the idle screen whether any slider is
+void drawMuteState(uint8_t shownSlider) {
+  int x = 0;
+  int y = 10;
+  bool muted = false;
+  if (currentScreenState == PERCENTAGE) {
+    x = 30;
+    y = 105;
+    muted = sliderMuted[shownSlider];
+  } else {
+    for (int i = 0; i < NUM_SLIDERS; i++) {
+      muted = muted || sliderMuted[i];
+    }
+  }
+
+  tft.fillRect(x, y, 4 * 6, 8, ST77XX_BLACK);
+  if (muted) {
+    tft.setTextSize(1);
+    tft.setTextColor(ST77XX_RED);
+    tft.setCursor(x, y);
+    tft.print("MUTE");
+  }
+}
+
+// Left of the artwork on the idle screen, under the number on the percentage screen
+void drawLayerName() {
+  int x = 0;
+  int y = 0;
+  uint8_t maxChars = IMAGE_X / 6;
+  if (currentScreenState == PERCENTAGE) {
+    x = 30;
+    y = 95;
+    maxChars = 18;
+  }
+
+  tft.fillRect(x, y, maxChars * 6, 8, ST77XX_BLACK);
+  tft.setTextSize(1);
+  tft.setTextColor(ST77XX_YELLOW);
+  tft.setCursor(x, y);
+  tft.print(layerName.substring(0, maxChars));
+}
+
 void handleSize() {
   unsigned long start = millis();
   
@@ -590,7 +905,10 @@ void handleSize() {
 }
 
 void requestImage() {
-  if (imageOnScreen) {
+  if (framed) {
+    uint8_t isNew = imageOnScreen ? 0 : 1;
+    sendFrame(FRAME_IMAGE_REQUEST, frameSeq++, &isNew, 1);
+  } else if (imageOnScreen) {
     Serial.print("REQ\n");
   } else {
     Serial.print("REQ:NEW\n");
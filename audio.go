@@ -0,0 +1,116 @@
+package main
+
+import (
+	"runtime"
+	"strings"
+)
+
+// Well-known device IDs accepted by every AudioBackend, resolving to the
+// current default output and input endpoints
+const (
+	defaultRenderDevice  = "default.render"
+	defaultCaptureDevice = "default.capture"
+)
+
+// AudioSession is a single application's audio session on an output device
+type AudioSession struct {
+	ProcessName string
+	Path        string // full path of the executable, if it can be read
+	DisplayName string // name the application gave its audio, if any
+	WindowTitle string // title of one of the process's windows, where the platform tells
+	PID         uint32
+	Volume      int // 0-100
+	Muted       bool
+	Device      string
+}
+
+// AudioDevice is an output (render) or input (capture) endpoint
+type AudioDevice struct {
+	ID      string
+	Name    string
+	Capture bool
+	Default bool
+	Volume  int // 0-100
+	Muted   bool
+}
+
+// SessionMatcher selects the sessions a set call applies to
+type SessionMatcher func(session AudioSession) bool
+
+// AudioBackend is everything deej needs from the operating system's audio stack.
+// Volumes are always percentages between 0 and 100.
+type AudioBackend interface {
+	// Sessions lists the application audio sessions
+	Sessions() ([]AudioSession, error)
+	// SetSessionVolume sets the volume of every matching session and returns how many matched
+	SetSessionVolume(match SessionMatcher, volume int) (int, error)
+	// SetSessionMute mutes or unmutes every matching session and returns how many matched
+	SetSessionMute(match SessionMatcher, muted bool) (int, error)
+
+	// Devices lists all active output and input endpoints
+	Devices() ([]AudioDevice, error)
+	// Device returns a single endpoint by ID, including defaultRenderDevice and defaultCaptureDevice
+	Device(id string) (AudioDevice, error)
+	SetDeviceVolume(id string, volume int) error
+	SetDeviceMute(id string, muted bool) error
+	// SetDefaultDevice makes an endpoint the default for its direction
+	SetDefaultDevice(id string) error
+
+	// Changes receives a value soon after a volume, mute, session or device changes,
+	// including changes made through the backend itself. Several changes in a row may
+	// arrive as one. It is nil where the backend can't tell, and has to be polled.
+	Changes() <-chan struct{}
+
+	Close() error
+}
+
+// signalChange wakes up whoever waits on a change channel, without blocking when a
+// wake-up is already pending
+func signalChange(changes chan struct{}) {
+	select {
+	case changes <- struct{}{}:
+	default:
+	}
+}
+
+// audio is the backend used by the slider pipeline, set up in main
+var audio AudioBackend
+
+// matchProcessName matches sessions by executable name, case-insensitively
+func matchProcessName(processName string) SessionMatcher {
+	return func(session AudioSession) bool {
+		return strings.EqualFold(session.ProcessName, processName)
+	}
+}
+
+// isProcessTarget reports whether a slider target names an executable, or a pattern
+// for their names. Windows targets need the .exe suffix; elsewhere binaries have no
+// extension, so anything that isn't a special target is treated as a process name.
+// device: targets name endpoints instead.
+func isProcessTarget(target string) bool {
+	switch strings.ToLower(target) {
+	case "", "master", "mic", "deej.current", "deej.unmapped":
+		return false
+	}
+	if strings.HasPrefix(strings.ToLower(target), deviceTargetPrefix) {
+		return false
+	}
+	if isPatternTarget(target) {
+		return true
+	}
+	if runtime.GOOS == "windows" {
+		return strings.HasSuffix(strings.ToLower(target), ".exe")
+	}
+	return true
+}
+
+// clampVolume keeps a percentage inside 0-100
+func clampVolume(volume int) int {
+	if volume < 0 {
+		return 0
+	}
+	if volume > 100 {
+		return 100
+	}
+	return volume
+}
@@ -0,0 +1,275 @@
+//go:build windows
+// +build windows
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"runtime"
+	"syscall"
+	"time"
+	"unsafe"
+
+	"github.com/go-ole/go-ole"
+	"github.com/moutend/go-wca/pkg/wca"
+)
+
+// Sessions and devices tend to come and go in bursts, so callbacks are registered
+// again once things have settled
+const audioRescanDelay = 200 * time.Millisecond
+
+const (
+	hresultOK          = 0
+	hresultNoInterface = 0x80004002
+)
+
+// comObject is a COM object implemented in Go: a pointer to its method table, whose
+// first three entries are IUnknown's. deej's callback objects are created once and
+// never freed, so they don't count references.
+type comObject struct {
+	vtable *uintptr
+}
+
+var (
+	// comObjectIIDs is the interface each callback object implements, by address
+	comObjectIIDs = make(map[uintptr]*ole.GUID)
+
+	comQueryInterface = syscall.NewCallback(func(this uintptr, iid *ole.GUID, object *uintptr) uintptr {
+		if ole.IsEqualGUID(iid, ole.IID_IUnknown) || ole.IsEqualGUID(iid, comObjectIIDs[this]) {
+			*object = this
+			return hresultOK
+		}
+		*object = 0
+		return hresultNoInterface
+	})
+	comAddRef  = syscall.NewCallback(func(this uintptr) uintptr { return 1 })
+	comRelease = syscall.NewCallback(func(this uintptr) uintptr { return 1 })
+)
+
+// newComObject builds a callback object implementing iid with the given methods, which
+// follow IUnknown's in the method table
+func newComObject(iid *ole.GUID, methods ...uintptr) *comObject {
+	vtable := append([]uintptr{comQueryInterface, comAddRef, comRelease}, methods...)
+	object := &comObject{vtable: &vtable[0]}
+	comObjectIIDs[uintptr(unsafe.Pointer(object))] = iid
+	return object
+}
+
+// wcaCallbacks are the objects Core Audio reports changes to. go-wca only declares
+// their interfaces, so they are built here.
+type wcaCallbacks struct {
+	endpointVolume *comObject // IAudioEndpointVolumeCallback
+	session        *comObject // IAudioSessionEvents
+	sessionCreated *comObject // IAudioSessionNotification
+	devices        *comObject // IMMNotificationClient
+}
+
+// newWCACallbacks returns callbacks that signal changes for volume and mute changes,
+// and rescan as well when sessions or devices come and go. The methods take as many
+// arguments as the interfaces declare, in pointer-sized slots, and ignore them.
+func newWCACallbacks(changes chan struct{}, rescan chan struct{}) *wcaCallbacks {
+	changed1 := syscall.NewCallback(func(this, _ uintptr) uintptr {
+		signalChange(changes)
+		return hresultOK
+	})
+	changed2 := syscall.NewCallback(func(this, _, _ uintptr) uintptr {
+		signalChange(changes)
+		return hresultOK
+	})
+	changed3 := syscall.NewCallback(func(this, _, _, _ uintptr) uintptr {
+		signalChange(changes)
+		return hresultOK
+	})
+	changed4 := syscall.NewCallback(func(this, _, _, _, _ uintptr) uintptr {
+		signalChange(changes)
+		return hresultOK
+	})
+	listChanged1 := syscall.NewCallback(func(this, _ uintptr) uintptr {
+		signalChange(changes)
+		signalChange(rescan)
+		return hresultOK
+	})
+	listChanged2 := syscall.NewCallback(func(this, _, _ uintptr) uintptr {
+		signalChange(changes)
+		signalChange(rescan)
+		return hresultOK
+	})
+	listChanged3 := syscall.NewCallback(func(this, _, _, _ uintptr) uintptr {
+		signalChange(changes)
+		signalChange(rescan)
+		return hresultOK
+	})
+
+	// OnPropertyValueChanged takes a PROPERTYKEY by value, which is passed by reference
+	// on 64-bit Windows and in five slots on 32-bit Windows
+	propertyChanged := syscall.NewCallback(func(this, _, _ uintptr) uintptr { return hresultOK })
+	if unsafe.Sizeof(uintptr(0)) == 4 {
+		propertyChanged = syscall.NewCallback(func(this, _, _, _, _, _, _ uintptr) uintptr { return hresultOK })
+	}
+
+	return &wcaCallbacks{
+		// OnNotify
+		endpointVolume: newComObject(wca.IID_IAudioEndpointVolumeCallback, changed1),
+		// OnDisplayNameChanged, OnIconPathChanged, OnSimpleVolumeChanged, OnChannelVolumeChanged,
+		// OnGroupingParamChanged, OnStateChanged, OnSessionDisconnected
+		session: newComObject(wca.IID_IAudioSessionEvents, changed2, changed2, changed3, changed4, changed2, changed1, listChanged1),
+		// OnSessionCreated
+		sessionCreated: newComObject(wca.IID_IAudioSessionNotification, listChanged1),
+		// OnDeviceStateChanged, OnDeviceAdded, OnDeviceRemoved, OnDefaultDeviceChanged, OnPropertyValueChanged
+		devices: newComObject(wca.IID_IMMNotificationClient, listChanged2, listChanged1, listChanged1, listChanged3, propertyChanged),
+	}
+}
+
+// callbackRegistrations are the objects callbacks are registered with. They are held
+// until the callbacks are unregistered again.
+type callbackRegistrations struct {
+	callbacks       *wcaCallbacks
+	mmde            *wca.IMMDeviceEnumerator
+	endpointVolumes []*wca.IAudioEndpointVolume
+	sessionManager  *wca.IAudioSessionManager2
+	sessionControls []*wca.IAudioSessionControl
+}
+
+// watchChanges keeps callbacks registered with every active endpoint, for device
+// targets, and with the sessions of the default output device, and registers them
+// again whenever sessions or devices come and go. The result of the first
+// registration is sent to ready.
+func (b *wcaBackend) watchChanges(rescan chan struct{}, ready chan<- error) {
+	// Callbacks arrive on threads of the multithreaded apartment, which this thread
+	// stays in for good
+	runtime.LockOSThread()
+	ole.CoInitializeEx(0, ole.COINIT_MULTITHREADED)
+
+	callbacks := newWCACallbacks(b.changes, rescan)
+	for {
+		registrations := &callbackRegistrations{callbacks: callbacks}
+		err := registrations.register()
+		if ready != nil {
+			ready <- err
+			ready = nil
+		} else if err != nil {
+			log.Printf("Error watching audio changes: %v", err)
+		}
+
+		<-rescan
+		time.Sleep(audioRescanDelay)
+		select {
+		case <-rescan:
+		default:
+		}
+		registrations.unregister()
+	}
+}
+
+func (r *callbackRegistrations) register() error {
+	if err := wca.CoCreateInstance(wca.CLSID_MMDeviceEnumerator, 0, wca.CLSCTX_ALL, wca.IID_IMMDeviceEnumerator, &r.mmde); err != nil {
+		return fmt.Errorf("failed to create device enumerator: %w", err)
+	}
+	if err := registerCallback(r.mmde.VTable().RegisterEndpointNotificationCallback, unsafe.Pointer(r.mmde), r.callbacks.devices); err != nil {
+		return fmt.Errorf("failed to watch audio devices: %w", err)
+	}
+
+	var collection *wca.IMMDeviceCollection
+	if err := r.mmde.EnumAudioEndpoints(wca.EAll, wca.DEVICE_STATE_ACTIVE, &collection); err != nil {
+		return fmt.Errorf("failed to list audio devices: %w", err)
+	}
+	defer collection.Release()
+
+	var count uint32
+	if err := collection.GetCount(&count); err != nil {
+		return fmt.Errorf("failed to count audio devices: %w", err)
+	}
+	for i := uint32(0); i < count; i++ {
+		var mmDevice *wca.IMMDevice
+		if err := collection.Item(i, &mmDevice); err != nil {
+			continue
+		}
+		r.watchEndpoint(mmDevice)
+		mmDevice.Release()
+	}
+
+	var mmDevice *wca.IMMDevice
+	if err := r.mmde.GetDefaultAudioEndpoint(wca.ERender, wca.EConsole, &mmDevice); err != nil {
+		return fmt.Errorf("failed to get default audio endpoint: %w", err)
+	}
+	defer mmDevice.Release()
+
+	return r.watchSessions(mmDevice)
+}
+
+func (r *callbackRegistrations) watchEndpoint(mmDevice *wca.IMMDevice) {
+	var endpointVolume *wca.IAudioEndpointVolume
+	if err := mmDevice.Activate(wca.IID_IAudioEndpointVolume, wca.CLSCTX_ALL, nil, &endpointVolume); err != nil {
+		return
+	}
+	if err := registerCallback(endpointVolume.VTable().RegisterControlChangeNotify, unsafe.Pointer(endpointVolume), r.callbacks.endpointVolume); err != nil {
+		endpointVolume.Release()
+		return
+	}
+	r.endpointVolumes = append(r.endpointVolumes, endpointVolume)
+}
+
+// watchSessions registers for new sessions before listing the current ones, which is
+// also what makes Windows start reporting new sessions at all
+func (r *callbackRegistrations) watchSessions(mmDevice *wca.IMMDevice) error {
+	if err := mmDevice.Activate(wca.IID_IAudioSessionManager2, wca.CLSCTX_ALL, nil, &r.sessionManager); err != nil {
+		return fmt.Errorf("failed to activate session manager: %w", err)
+	}
+	if err := registerCallback(r.sessionManager.VTable().RegisterSessionNotification, unsafe.Pointer(r.sessionManager), r.callbacks.sessionCreated); err != nil {
+		return fmt.Errorf("failed to watch audio sessions: %w", err)
+	}
+
+	var sessionEnumerator *wca.IAudioSessionEnumerator
+	if err := r.sessionManager.GetSessionEnumerator(&sessionEnumerator); err != nil {
+		return fmt.Errorf("failed to get session enumerator: %w", err)
+	}
+	defer sessionEnumerator.Release()
+
+	var sessionCount int
+	if err := sessionEnumerator.GetCount(&sessionCount); err != nil {
+		return fmt.Errorf("failed to get session count: %w", err)
+	}
+	for i := 0; i < sessionCount; i++ {
+		var sessionControl *wca.IAudioSessionControl
+		if err := sessionEnumerator.GetSession(i, &sessionControl); err != nil || sessionControl == nil {
+			continue
+		}
+		if err := registerCallback(sessionControl.VTable().RegisterAudioSessionNotification, unsafe.Pointer(sessionControl), r.callbacks.session); err != nil {
+			sessionControl.Release()
+			continue
+		}
+		r.sessionControls = append(r.sessionControls, sessionControl)
+	}
+	return nil
+}
+
+// unregister undoes register, as far as it got
+func (r *callbackRegistrations) unregister() {
+	for _, sessionControl := range r.sessionControls {
+		registerCallback(sessionControl.VTable().UnregisterAudioSessionNotification, unsafe.Pointer(sessionControl), r.callbacks.session)
+		sessionControl.Release()
+	}
+	if r.sessionManager != nil {
+		registerCallback(r.sessionManager.VTable().UnregisterSessionNotification, unsafe.Pointer(r.sessionManager), r.callbacks.sessionCreated)
+		r.sessionManager.Release()
+	}
+	for _, endpointVolume := range r.endpointVolumes {
+		registerCallback(endpointVolume.VTable().UnregisterControlChangeNotify, unsafe.Pointer(endpointVolume), r.callbacks.endpointVolume)
+		endpointVolume.Release()
+	}
+	if r.mmde != nil {
+		registerCallback(r.mmde.VTable().UnregisterEndpointNotificationCallback, unsafe.Pointer(r.mmde), r.callbacks.devices)
+		r.mmde.Release()
+	}
+}
+
+// registerCallback calls one of the (Un)Register methods of a Core Audio object
+// through its vtable, as go-wca doesn't implement all of them
+func registerCallback(method uintptr, object unsafe.Pointer, callback *comObject) error {
+	hr, _, _ := syscall.Syscall(method, 2, uintptr(object), uintptr(unsafe.Pointer(callback)), 0)
+	if hr != hresultOK {
+		return ole.NewError(hr)
+	}
+	return nil
+}
@@ -0,0 +1,345 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FakeCall is one recorded set call against a fakeBackend
+type FakeCall struct {
+	Time   time.Time
+	Method string
+	Target string // process name or device ID
+	PID    uint32
+	Volume int
+	Muted  bool
+}
+
+func (c FakeCall) String() string {
+	switch c.Method {
+	case "SetSessionMute", "SetDeviceMute":
+		return fmt.Sprintf("%s(%s, %t)", c.Method, c.Target, c.Muted)
+	case "SetDefaultDevice":
+		return fmt.Sprintf("%s(%s)", c.Method, c.Target)
+	default:
+		return fmt.Sprintf("%s(%s, %d)", c.Method, c.Target, c.Volume)
+	}
+}
+
+// fakeBackend is an in-memory AudioBackend. Sessions and devices can be added,
+// removed and changed at any time, and every set call is recorded, so the slider
+// pipeline can be exercised without a real audio stack. Every change is reported on
+// Changes, like real backends do.
+type fakeBackend struct {
+	mu       sync.Mutex
+	sessions []AudioSession
+	devices  []AudioDevice
+	calls    []FakeCall
+	reads    int // Sessions, Devices and Device calls
+	changes  chan struct{}
+
+	foreground string        // process deej.current controls, "" to ask the platform
+	latency    time.Duration // how long every set call takes, like a real audio stack would
+	gate       chan struct{} // set calls wait on it, see SetGate
+}
+
+// newFakeBackend returns a fake with one default output and one default input device
+func newFakeBackend() *fakeBackend {
+	return &fakeBackend{
+		devices: []AudioDevice{
+			{ID: "fake.speakers", Name: "Speakers (Fake Audio)", Default: true, Volume: 100},
+			{ID: "fake.microphone", Name: "Microphone (Fake Audio)", Capture: true, Default: true, Volume: 100},
+		},
+		changes: make(chan struct{}, 1),
+	}
+}
+
+// AddSession adds a session to the table
+func (b *fakeBackend) AddSession(session AudioSession) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	defer signalChange(b.changes)
+
+	b.sessions = append(b.sessions, session)
+}
+
+// RemoveSession drops every session belonging to pid and returns how many were removed
+func (b *fakeBackend) RemoveSession(pid uint32) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	defer signalChange(b.changes)
+
+	kept := b.sessions[:0]
+	for _, session := range b.sessions {
+		if session.PID != pid {
+			kept = append(kept, session)
+		}
+	}
+	removed := len(b.sessions) - len(kept)
+	b.sessions = kept
+	return removed
+}
+
+// UpdateSession changes every session belonging to pid without recording a call,
+// as if another program had changed it
+func (b *fakeBackend) UpdateSession(pid uint32, update func(session *AudioSession)) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	defer signalChange(b.changes)
+
+	for i := range b.sessions {
+		if b.sessions[i].PID == pid {
+			update(&b.sessions[i])
+		}
+	}
+}
+
+// AddDevice adds an endpoint; a new default device replaces the previous one of its kind
+func (b *fakeBackend) AddDevice(device AudioDevice) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	defer signalChange(b.changes)
+
+	if device.Default {
+		for i := range b.devices {
+			if b.devices[i].Capture == device.Capture {
+				b.devices[i].Default = false
+			}
+		}
+	}
+	b.devices = append(b.devices, device)
+}
+
+// UpdateDevice changes an endpoint without recording a call
+func (b *fakeBackend) UpdateDevice(id string, update func(device *AudioDevice)) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	defer signalChange(b.changes)
+
+	device, err := b.findDevice(id)
+	if err != nil {
+		return err
+	}
+	update(device)
+	return nil
+}
+
+// SetForeground makes a process the foreground one, as deej.current sees it; ""
+// leaves it to the platform again
+func (b *fakeBackend) SetForeground(processName string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	defer signalChange(b.changes)
+
+	b.foreground = processName
+}
+
+func (b *fakeBackend) ForegroundProcess() (string, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.foreground, b.foreground != ""
+}
+
+// SetLatency makes every set call take d, to see how callers cope with a slow backend
+func (b *fakeBackend) SetLatency(d time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.latency = d
+}
+
+// SetGate makes every set call wait for the test: a call sends on gate when it starts,
+// then waits to receive from it before it finishes. nil lets calls through again.
+func (b *fakeBackend) SetGate(gate chan struct{}) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.gate = gate
+}
+
+// delay waits out the latency and gate of a set call, without holding up other calls
+func (b *fakeBackend) delay() {
+	b.mu.Lock()
+	latency, gate := b.latency, b.gate
+	b.mu.Unlock()
+
+	if gate != nil {
+		gate <- struct{}{}
+		<-gate
+	}
+	time.Sleep(latency)
+}
+
+// Calls returns a copy of the recorded set calls, oldest first
+func (b *fakeBackend) Calls() []FakeCall {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return append([]FakeCall(nil), b.calls...)
+}
+
+// Reads returns how many times sessions or devices were read
+func (b *fakeBackend) Reads() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.reads
+}
+
+// ResetCalls clears the recorded set calls
+func (b *fakeBackend) ResetCalls() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.calls = nil
+}
+
+func (b *fakeBackend) Changes() <-chan struct{} {
+	return b.changes
+}
+
+func (b *fakeBackend) Close() error {
+	return nil
+}
+
+func (b *fakeBackend) Sessions() ([]AudioSession, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.reads++
+
+	return append([]AudioSession(nil), b.sessions...), nil
+}
+
+func (b *fakeBackend) SetSessionVolume(match SessionMatcher, volume int) (int, error) {
+	b.delay()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	defer signalChange(b.changes)
+
+	volume = clampVolume(volume)
+	matched := 0
+	for i := range b.sessions {
+		if !match(b.sessions[i]) {
+			continue
+		}
+		b.sessions[i].Volume = volume
+		b.record(FakeCall{Method: "SetSessionVolume", Target: b.sessions[i].ProcessName, PID: b.sessions[i].PID, Volume: volume})
+		matched++
+	}
+	return matched, nil
+}
+
+func (b *fakeBackend) SetSessionMute(match SessionMatcher, muted bool) (int, error) {
+	b.delay()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	defer signalChange(b.changes)
+
+	matched := 0
+	for i := range b.sessions {
+		if !match(b.sessions[i]) {
+			continue
+		}
+		b.sessions[i].Muted = muted
+		b.record(FakeCall{Method: "SetSessionMute", Target: b.sessions[i].ProcessName, PID: b.sessions[i].PID, Muted: muted})
+		matched++
+	}
+	return matched, nil
+}
+
+func (b *fakeBackend) Devices() ([]AudioDevice, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.reads++
+
+	return append([]AudioDevice(nil), b.devices...), nil
+}
+
+func (b *fakeBackend) Device(id string) (AudioDevice, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.reads++
+
+	device, err := b.findDevice(id)
+	if err != nil {
+		return AudioDevice{}, err
+	}
+	return *device, nil
+}
+
+func (b *fakeBackend) SetDeviceVolume(id string, volume int) error {
+	b.delay()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	defer signalChange(b.changes)
+
+	device, err := b.findDevice(id)
+	if err != nil {
+		return err
+	}
+	device.Volume = clampVolume(volume)
+	b.record(FakeCall{Method: "SetDeviceVolume", Target: device.ID, Volume: device.Volume})
+	return nil
+}
+
+func (b *fakeBackend) SetDeviceMute(id string, muted bool) error {
+	b.delay()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	defer signalChange(b.changes)
+
+	device, err := b.findDevice(id)
+	if err != nil {
+		return err
+	}
+	device.Muted = muted
+	b.record(FakeCall{Method: "SetDeviceMute", Target: device.ID, Muted: muted})
+	return nil
+}
+
+func (b *fakeBackend) SetDefaultDevice(id string) error {
+	b.delay()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	defer signalChange(b.changes)
+
+	device, err := b.findDevice(id)
+	if err != nil {
+		return err
+	}
+	for i := range b.devices {
+		if b.devices[i].Capture == device.Capture {
+			b.devices[i].Default = false
+		}
+	}
+	device.Default = true
+	b.record(FakeCall{Method: "SetDefaultDevice", Target: device.ID})
+	return nil
+}
+
+// findDevice must be called with b.mu held
+func (b *fakeBackend) findDevice(id string) (*AudioDevice, error) {
+	for i := range b.devices {
+		device := &b.devices[i]
+		switch {
+		case id == defaultRenderDevice && device.Default && !device.Capture,
+			id == defaultCaptureDevice && device.Default && device.Capture,
+			strings.EqualFold(id, device.ID):
+			return device, nil
+		}
+	}
+	return nil, fmt.Errorf("audio device %q not found", id)
+}
+
+// record must be called with b.mu held
+func (b *fakeBackend) record(call FakeCall) {
+	call.Time = time.Now()
+	b.calls = append(b.calls, call)
+
+	if verbose {
+		fmt.Printf("[Fake Audio] %s\n", call)
+	}
+}
@@ -0,0 +1,361 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"os"
+	"strconv"
+
+	"github.com/jfreymuth/pulse/proto"
+)
+
+// PulseAudio resolves these names to the current default sink and source
+const (
+	pulseDefaultSink   = "@DEFAULT_SINK@"
+	pulseDefaultSource = "@DEFAULT_SOURCE@"
+)
+
+// pulseEvents are the server events that can change a volume deej reads: sinks and
+// sources, streams coming and going, and the server's default sink and source
+const pulseEvents = proto.SubscriptionMaskSink | proto.SubscriptionMaskSource | proto.SubscriptionMaskSinkInput | proto.SubscriptionMaskServer
+
+// pulseBackend speaks the PulseAudio native protocol, which pipewire-pulse serves as well.
+// The server is found the same way libpulse finds it, so PULSE_SERVER can point deej
+// at a locally started test server.
+type pulseBackend struct {
+	client     *proto.Client
+	conn       net.Conn
+	changes    chan struct{}
+	subscribed bool
+}
+
+func newAudioBackend() (AudioBackend, error) {
+	client, conn, err := proto.Connect("")
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to pulseaudio: %w", err)
+	}
+
+	props := proto.PropList{
+		"application.name": proto.PropListString("deej"),
+	}
+	if err := client.Request(&proto.SetClientName{Props: props}, &proto.SetClientNameReply{}); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to register with pulseaudio: %w", err)
+	}
+
+	b := &pulseBackend{client: client, conn: conn, changes: make(chan struct{}, 1)}
+
+	// Events arrive on the client's read loop, which must not wait for anything
+	client.Callback = func(msg interface{}) {
+		if _, ok := msg.(*proto.SubscribeEvent); ok {
+			signalChange(b.changes)
+		}
+	}
+	if err := client.Request(&proto.Subscribe{Mask: pulseEvents}, nil); err != nil {
+		log.Printf("Error subscribing to pulseaudio events, volumes are polled instead: %v", err)
+	} else {
+		b.subscribed = true
+	}
+
+	return b, nil
+}
+
+func (b *pulseBackend) Changes() <-chan struct{} {
+	if !b.subscribed {
+		return nil
+	}
+	return b.changes
+}
+
+func (b *pulseBackend) Close() error {
+	return b.conn.Close()
+}
+
+func (b *pulseBackend) Sessions() ([]AudioSession, error) {
+	var sinkInputs proto.GetSinkInputInfoListReply
+	if err := b.client.Request(&proto.GetSinkInputInfoList{}, &sinkInputs); err != nil {
+		return nil, fmt.Errorf("failed to list sink inputs: %w", err)
+	}
+
+	var sinks proto.GetSinkInfoListReply
+	if err := b.client.Request(&proto.GetSinkInfoList{}, &sinks); err != nil {
+		return nil, fmt.Errorf("failed to list sinks: %w", err)
+	}
+	sinkNames := make(map[uint32]string, len(sinks))
+	for _, sink := range sinks {
+		sinkNames[sink.SinkIndex] = sink.Device
+	}
+
+	sessions := make([]AudioSession, 0, len(sinkInputs))
+	for _, sinkInput := range sinkInputs {
+		sessions = append(sessions, sinkInputSession(sinkInput, sinkNames[sinkInput.SinkIndex]))
+	}
+
+	return sessions, nil
+}
+
+func (b *pulseBackend) SetSessionVolume(match SessionMatcher, volume int) (int, error) {
+	return b.forMatchingSinkInputs(match, func(sinkInput *proto.GetSinkInputInfoReply) error {
+		return b.client.Request(&proto.SetSinkInputVolume{
+			SinkInputIndex: sinkInput.SinkInputIndex,
+			ChannelVolumes: percentToChannelVolumes(volume, len(sinkInput.ChannelVolumes)),
+		}, nil)
+	})
+}
+
+func (b *pulseBackend) SetSessionMute(match SessionMatcher, muted bool) (int, error) {
+	return b.forMatchingSinkInputs(match, func(sinkInput *proto.GetSinkInputInfoReply) error {
+		return b.client.Request(&proto.SetSinkInputMute{
+			SinkInputIndex: sinkInput.SinkInputIndex,
+			Mute:           muted,
+		}, nil)
+	})
+}
+
+func (b *pulseBackend) Devices() ([]AudioDevice, error) {
+	var serverInfo proto.GetServerInfoReply
+	if err := b.client.Request(&proto.GetServerInfo{}, &serverInfo); err != nil {
+		return nil, fmt.Errorf("failed to get server info: %w", err)
+	}
+
+	var sinks proto.GetSinkInfoListReply
+	if err := b.client.Request(&proto.GetSinkInfoList{}, &sinks); err != nil {
+		return nil, fmt.Errorf("failed to list sinks: %w", err)
+	}
+
+	var sources proto.GetSourceInfoListReply
+	if err := b.client.Request(&proto.GetSourceInfoList{}, &sources); err != nil {
+		return nil, fmt.Errorf("failed to list sources: %w", err)
+	}
+
+	devices := make([]AudioDevice, 0, len(sinks)+len(sources))
+	for _, sink := range sinks {
+		device := sinkDevice(sink)
+		device.Default = sink.SinkName == serverInfo.DefaultSinkName
+		devices = append(devices, device)
+	}
+	for _, source := range sources {
+		// Skip the monitor sources PulseAudio creates for every sink
+		if source.MonitorSourceIndex != proto.Undefined {
+			continue
+		}
+		device := sourceDevice(source)
+		device.Default = source.SourceName == serverInfo.DefaultSourceName
+		devices = append(devices, device)
+	}
+
+	return devices, nil
+}
+
+func (b *pulseBackend) Device(id string) (AudioDevice, error) {
+	switch id {
+	case defaultRenderDevice:
+		sink, err := b.sinkInfo(pulseDefaultSink)
+		if err != nil {
+			return AudioDevice{}, err
+		}
+		device := sinkDevice(sink)
+		device.Default = true
+		return device, nil
+	case defaultCaptureDevice:
+		source, err := b.sourceInfo(pulseDefaultSource)
+		if err != nil {
+			return AudioDevice{}, err
+		}
+		device := sourceDevice(source)
+		device.Default = true
+		return device, nil
+	}
+
+	if sink, err := b.sinkInfo(id); err == nil {
+		return sinkDevice(sink), nil
+	}
+	if source, err := b.sourceInfo(id); err == nil {
+		return sourceDevice(source), nil
+	}
+
+	return AudioDevice{}, fmt.Errorf("audio device %q not found", id)
+}
+
+func (b *pulseBackend) SetDeviceVolume(id string, volume int) error {
+	device, err := b.Device(id)
+	if err != nil {
+		return err
+	}
+
+	if device.Capture {
+		source, err := b.sourceInfo(device.ID)
+		if err != nil {
+			return err
+		}
+		return b.client.Request(&proto.SetSourceVolume{
+			SourceIndex:    source.SourceIndex,
+			ChannelVolumes: percentToChannelVolumes(volume, len(source.ChannelVolumes)),
+		}, nil)
+	}
+
+	sink, err := b.sinkInfo(device.ID)
+	if err != nil {
+		return err
+	}
+	return b.client.Request(&proto.SetSinkVolume{
+		SinkIndex:      sink.SinkIndex,
+		ChannelVolumes: percentToChannelVolumes(volume, len(sink.ChannelVolumes)),
+	}, nil)
+}
+
+func (b *pulseBackend) SetDeviceMute(id string, muted bool) error {
+	device, err := b.Device(id)
+	if err != nil {
+		return err
+	}
+
+	if device.Capture {
+		return b.client.Request(&proto.SetSourceMute{
+			SourceIndex: proto.Undefined,
+			SourceName:  device.ID,
+			Mute:        muted,
+		}, nil)
+	}
+
+	return b.client.Request(&proto.SetSinkMute{
+		SinkIndex: proto.Undefined,
+		SinkName:  device.ID,
+		Mute:      muted,
+	}, nil)
+}
+
+func (b *pulseBackend) SetDefaultDevice(id string) error {
+	device, err := b.Device(id)
+	if err != nil {
+		return err
+	}
+
+	if device.Capture {
+		return b.client.Request(&proto.SetDefaultSource{SourceName: device.ID}, nil)
+	}
+	return b.client.Request(&proto.SetDefaultSink{SinkName: device.ID}, nil)
+}
+
+// forMatchingSinkInputs applies f to every sink input accepted by match and returns the match count
+func (b *pulseBackend) forMatchingSinkInputs(match SessionMatcher, f func(sinkInput *proto.GetSinkInputInfoReply) error) (int, error) {
+	var sinkInputs proto.GetSinkInputInfoListReply
+	if err := b.client.Request(&proto.GetSinkInputInfoList{}, &sinkInputs); err != nil {
+		return 0, fmt.Errorf("failed to list sink inputs: %w", err)
+	}
+
+	matched := 0
+	var firstErr error
+	for _, sinkInput := range sinkInputs {
+		if !match(sinkInputSession(sinkInput, "")) {
+			continue
+		}
+		if err := f(sinkInput); err != nil {
+			if firstErr == nil {
+				firstErr = fmt.Errorf("failed to update sink input %d: %w", sinkInput.SinkInputIndex, err)
+			}
+			continue
+		}
+		matched++
+	}
+
+	return matched, firstErr
+}
+
+func (b *pulseBackend) sinkInfo(name string) (*proto.GetSinkInfoReply, error) {
+	var sink proto.GetSinkInfoReply
+	if err := b.client.Request(&proto.GetSinkInfo{SinkIndex: proto.Undefined, SinkName: name}, &sink); err != nil {
+		return nil, fmt.Errorf("failed to get sink %s: %w", name, err)
+	}
+	return &sink, nil
+}
+
+func (b *pulseBackend) sourceInfo(name string) (*proto.GetSourceInfoReply, error) {
+	var source proto.GetSourceInfoReply
+	if err := b.client.Request(&proto.GetSourceInfo{SourceIndex: proto.Undefined, SourceName: name}, &source); err != nil {
+		return nil, fmt.Errorf("failed to get source %s: %w", name, err)
+	}
+	return &source, nil
+}
+
+func sinkInputSession(sinkInput *proto.GetSinkInputInfoReply, deviceName string) AudioSession {
+	pid, _ := strconv.ParseUint(propString(sinkInput.Properties, "application.process.id"), 10, 32)
+
+	// Streams from other users or sandboxes don't let their executable be read
+	var path string
+	if pid > 0 {
+		path, _ = os.Readlink(fmt.Sprintf("/proc/%d/exe", pid))
+	}
+
+	return AudioSession{
+		ProcessName: propString(sinkInput.Properties, "application.process.binary"),
+		Path:        path,
+		DisplayName: propString(sinkInput.Properties, "application.name"),
+		PID:         uint32(pid),
+		Volume:      channelVolumesToPercent(sinkInput.ChannelVolumes),
+		Muted:       sinkInput.Muted,
+		Device:      deviceName,
+	}
+}
+
+func sinkDevice(sink *proto.GetSinkInfoReply) AudioDevice {
+	return AudioDevice{
+		ID:     sink.SinkName,
+		Name:   sink.Device,
+		Volume: channelVolumesToPercent(sink.ChannelVolumes),
+		Muted:  sink.Mute,
+	}
+}
+
+func sourceDevice(source *proto.GetSourceInfoReply) AudioDevice {
+	return AudioDevice{
+		ID:      source.SourceName,
+		Name:    source.Device,
+		Capture: true,
+		Volume:  channelVolumesToPercent(source.ChannelVolumes),
+		Muted:   source.Mute,
+	}
+}
+
+// propString reads a string property, returning "" when it's missing
+func propString(props proto.PropList, key string) string {
+	entry, ok := props[key]
+	if !ok || len(entry) == 0 || entry[len(entry)-1] != 0 {
+		return ""
+	}
+	return entry.String()
+}
+
+// channelVolumesToPercent averages all channels, 100% being PulseAudio's normal volume.
+// PulseAudio can boost past that, which reads as 100 since sliders stop there.
+func channelVolumesToPercent(volumes proto.ChannelVolumes) int {
+	if len(volumes) == 0 {
+		return 0
+	}
+
+	var sum float64
+	for _, v := range volumes {
+		sum += float64(v)
+	}
+	average := sum / float64(len(volumes))
+
+	return clampVolume(int(math.Round(average * 100 / float64(proto.VolumeNorm))))
+}
+
+func percentToChannelVolumes(volume int, channels int) proto.ChannelVolumes {
+	if channels < 1 {
+		channels = 1
+	}
+
+	v := uint32(uint64(clampVolume(volume)) * uint64(proto.VolumeNorm) / 100)
+	volumes := make(proto.ChannelVolumes, channels)
+	for i := range volumes {
+		volumes[i] = v
+	}
+	return volumes
+}
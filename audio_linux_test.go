@@ -0,0 +1,124 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"testing"
+
+	"github.com/jfreymuth/pulse"
+	"github.com/jfreymuth/pulse/proto"
+)
+
+const testSinkName = "deej_test"
+
+// volumeNorm is PulseAudio's 100%
+const volumeNorm = uint32(proto.VolumeNorm)
+
+func TestChannelVolumesToPercent(t *testing.T) {
+	tests := []struct {
+		name    string
+		volumes proto.ChannelVolumes
+		want    int
+	}{
+		{"no channels", nil, 0},
+		{"muted", proto.ChannelVolumes{0, 0}, 0},
+		{"normal", proto.ChannelVolumes{volumeNorm, volumeNorm}, 100},
+		{"channels averaged", proto.ChannelVolumes{volumeNorm / 2, volumeNorm}, 75},
+		{"boosted", proto.ChannelVolumes{volumeNorm * 3 / 2, volumeNorm * 3 / 2}, 100},
+	}
+
+	for _, test := range tests {
+		if got := channelVolumesToPercent(test.volumes); got != test.want {
+			t.Errorf("%s: channelVolumesToPercent(%v) = %d, want %d", test.name, test.volumes, got, test.want)
+		}
+	}
+}
+
+// TestPulseNullSink sets and reads back volumes on a null sink and a silent stream
+// playing to it. It needs a running pulse server, or pipewire-pulse.
+func TestPulseNullSink(t *testing.T) {
+	backend, err := newAudioBackend()
+	if err != nil {
+		t.Skipf("no pulse server reachable: %v", err)
+	}
+	defer backend.Close()
+	b := backend.(*pulseBackend)
+
+	var module proto.LoadModuleReply
+	if err := b.client.Request(&proto.LoadModule{Name: "module-null-sink", Args: "sink_name=" + testSinkName}, &module); err != nil {
+		t.Fatalf("failed to load module-null-sink: %v", err)
+	}
+	defer b.client.Request(&proto.UnloadModule{ModuleIndex: module.ModuleIndex}, nil)
+
+	client, err := pulse.NewClient(pulse.ClientApplicationName("deej test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	sink, err := client.SinkByID(testSinkName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	silence := pulse.Float32Reader(func(buf []float32) (int, error) {
+		for i := range buf {
+			buf[i] = 0
+		}
+		return len(buf), nil
+	})
+	stream, err := client.NewPlayback(silence, pulse.PlaybackSink(sink))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stream.Close()
+	stream.Start()
+
+	isTestStream := func(session AudioSession) bool { return session.DisplayName == "deej test" }
+	testStreamVolume := func() int {
+		t.Helper()
+		sessions, err := b.Sessions()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, session := range sessions {
+			if isTestStream(session) {
+				return session.Volume
+			}
+		}
+		t.Fatal("test stream isn't a session")
+		return 0
+	}
+
+	for _, volume := range []int{30, 75, 100} {
+		matched, err := b.SetSessionVolume(isTestStream, volume)
+		if err != nil || matched != 1 {
+			t.Fatalf("SetSessionVolume(%d) = %d, %v, want 1 session", volume, matched, err)
+		}
+		if got := testStreamVolume(); got != volume {
+			t.Errorf("session volume %d after setting %d", got, volume)
+		}
+
+		if err := b.SetDeviceVolume(testSinkName, volume); err != nil {
+			t.Fatal(err)
+		}
+		device, err := b.Device(testSinkName)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if device.Volume != volume {
+			t.Errorf("sink volume %d after setting %d", device.Volume, volume)
+		}
+	}
+
+	// Boosted past 100% by something else, the stream reads as a full slider
+	boosted := make(proto.ChannelVolumes, stream.Channels())
+	for i := range boosted {
+		boosted[i] = volumeNorm * 3 / 2
+	}
+	if err := b.client.Request(&proto.SetSinkInputVolume{SinkInputIndex: stream.StreamInputIndex(), ChannelVolumes: boosted}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if got := testStreamVolume(); got != 100 {
+		t.Errorf("boosted session volume %d, want 100", got)
+	}
+}
@@ -0,0 +1,13 @@
+//go:build !windows && !linux
+// +build !windows,!linux
+
+package main
+
+import (
+	"fmt"
+	"runtime"
+)
+
+func newAudioBackend() (AudioBackend, error) {
+	return nil, fmt.Errorf("no audio backend available on %s", runtime.GOOS)
+}
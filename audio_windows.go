@@ -0,0 +1,521 @@
+//go:build windows
+// +build windows
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"runtime"
+	"strings"
+	"syscall"
+	"unsafe"
+
+	"github.com/go-ole/go-ole"
+	"github.com/moutend/go-wca/pkg/wca"
+	"golang.org/x/sys/windows"
+)
+
+// IPolicyConfig isn't part of go-wca, so SetDefaultEndpoint is called through its vtable
+var (
+	clsidPolicyConfigClient = ole.NewGUID("{870AF99C-171D-4F9E-AF0D-E63DF40C2BC9}")
+	iidPolicyConfig         = ole.NewGUID("{F8679F50-850A-41CF-9C72-430F290290C8}")
+)
+
+const (
+	policyConfigSetDefaultEndpoint = 13 // after IUnknown and ten format/property methods
+	policyConfigMethods            = 15
+)
+
+// wcaBackend talks to the Windows Core Audio API. Every call runs on its own
+// locked OS thread with a fresh COM apartment, so it is safe to use from any goroutine.
+// Changes are reported by callbacks, which a separate thread keeps registered.
+type wcaBackend struct {
+	changes  chan struct{}
+	watching bool
+}
+
+func newAudioBackend() (AudioBackend, error) {
+	b := &wcaBackend{changes: make(chan struct{}, 1)}
+
+	ready := make(chan error)
+	go b.watchChanges(make(chan struct{}, 1), ready)
+	if err := <-ready; err != nil {
+		log.Printf("Error watching audio changes, volumes are polled instead: %v", err)
+	} else {
+		b.watching = true
+	}
+
+	return b, nil
+}
+
+func (b *wcaBackend) Changes() <-chan struct{} {
+	if !b.watching {
+		return nil
+	}
+	return b.changes
+}
+
+func (b *wcaBackend) Close() error {
+	return nil
+}
+
+func (b *wcaBackend) Sessions() ([]AudioSession, error) {
+	var sessions []AudioSession
+
+	err := b.withEnumerator(func(mmde *wca.IMMDeviceEnumerator) error {
+		return b.forEachSession(mmde, func(session AudioSession, _ *wca.ISimpleAudioVolume) error {
+			sessions = append(sessions, session)
+			return nil
+		})
+	})
+
+	return sessions, err
+}
+
+func (b *wcaBackend) SetSessionVolume(match SessionMatcher, volume int) (int, error) {
+	volumeScalar := float32(clampVolume(volume)) / 100.0
+
+	return b.forMatchingSessions(match, func(session AudioSession, simpleVolume *wca.ISimpleAudioVolume) error {
+		if err := simpleVolume.SetMasterVolume(volumeScalar, nil); err != nil {
+			return fmt.Errorf("failed to set volume for %s: %w", session.ProcessName, err)
+		}
+		return nil
+	})
+}
+
+func (b *wcaBackend) SetSessionMute(match SessionMatcher, muted bool) (int, error) {
+	return b.forMatchingSessions(match, func(session AudioSession, simpleVolume *wca.ISimpleAudioVolume) error {
+		if err := simpleVolume.SetMute(muted, nil); err != nil {
+			return fmt.Errorf("failed to set mute for %s: %w", session.ProcessName, err)
+		}
+		return nil
+	})
+}
+
+func (b *wcaBackend) Devices() ([]AudioDevice, error) {
+	var devices []AudioDevice
+
+	err := b.withEnumerator(func(mmde *wca.IMMDeviceEnumerator) error {
+		for _, flow := range []uint32{wca.ERender, wca.ECapture} {
+			defaultID := defaultEndpointID(mmde, flow)
+
+			var collection *wca.IMMDeviceCollection
+			if err := mmde.EnumAudioEndpoints(flow, wca.DEVICE_STATE_ACTIVE, &collection); err != nil {
+				return fmt.Errorf("failed to enumerate audio endpoints: %w", err)
+			}
+
+			var count uint32
+			if err := collection.GetCount(&count); err != nil {
+				collection.Release()
+				return fmt.Errorf("failed to get endpoint count: %w", err)
+			}
+
+			for i := uint32(0); i < count; i++ {
+				var mmDevice *wca.IMMDevice
+				if err := collection.Item(i, &mmDevice); err != nil {
+					continue
+				}
+
+				device, err := describeDevice(mmDevice, flow == wca.ECapture)
+				mmDevice.Release()
+				if err != nil {
+					continue
+				}
+
+				device.Default = device.ID == defaultID
+				devices = append(devices, device)
+			}
+
+			collection.Release()
+		}
+		return nil
+	})
+
+	return devices, err
+}
+
+func (b *wcaBackend) Device(id string) (AudioDevice, error) {
+	var device AudioDevice
+
+	err := b.withDevice(id, func(mmDevice *wca.IMMDevice, capture bool) error {
+		var err error
+		device, err = describeDevice(mmDevice, capture)
+		return err
+	})
+
+	return device, err
+}
+
+func (b *wcaBackend) SetDeviceVolume(id string, volume int) error {
+	volumeScalar := float32(clampVolume(volume)) / 100.0
+
+	return b.withEndpointVolume(id, func(endpointVolume *wca.IAudioEndpointVolume) error {
+		if err := endpointVolume.SetMasterVolumeLevelScalar(volumeScalar, nil); err != nil {
+			return fmt.Errorf("failed to set endpoint volume: %w", err)
+		}
+		return nil
+	})
+}
+
+func (b *wcaBackend) SetDeviceMute(id string, muted bool) error {
+	return b.withEndpointVolume(id, func(endpointVolume *wca.IAudioEndpointVolume) error {
+		if err := endpointVolume.SetMute(muted, nil); err != nil {
+			return fmt.Errorf("failed to set endpoint mute: %w", err)
+		}
+		return nil
+	})
+}
+
+// SetDefaultDevice goes through IPolicyConfig, which Windows' own sound settings use but
+// doesn't document, for all three roles
+func (b *wcaBackend) SetDefaultDevice(id string) error {
+	return b.withDevice(id, func(mmDevice *wca.IMMDevice, _ bool) error {
+		var deviceID string
+		if err := mmDevice.GetId(&deviceID); err != nil {
+			return fmt.Errorf("failed to get device id: %w", err)
+		}
+		deviceIDPtr, err := syscall.UTF16PtrFromString(deviceID)
+		if err != nil {
+			return err
+		}
+
+		var policyConfig *ole.IUnknown
+		if err := wca.CoCreateInstance(clsidPolicyConfigClient, 0, wca.CLSCTX_ALL, iidPolicyConfig, &policyConfig); err != nil {
+			return fmt.Errorf("failed to create policy config: %w", err)
+		}
+		defer policyConfig.Release()
+
+		vtable := (*[policyConfigMethods]uintptr)(unsafe.Pointer(policyConfig.RawVTable))
+		for _, role := range []uint32{wca.EConsole, wca.EMultimedia, wca.ECommunications} {
+			hr, _, _ := syscall.Syscall(vtable[policyConfigSetDefaultEndpoint], 3,
+				uintptr(unsafe.Pointer(policyConfig)), uintptr(unsafe.Pointer(deviceIDPtr)), uintptr(role))
+			if hr != ole.S_OK {
+				return fmt.Errorf("failed to set default endpoint: %w", ole.NewError(hr))
+			}
+		}
+		return nil
+	})
+}
+
+// withEnumerator initializes COM on a locked thread and hands f a device enumerator
+func (b *wcaBackend) withEnumerator(f func(mmde *wca.IMMDeviceEnumerator) error) error {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	ole.CoInitializeEx(0, ole.COINIT_APARTMENTTHREADED)
+	defer ole.CoUninitialize()
+
+	var mmde *wca.IMMDeviceEnumerator
+	if err := wca.CoCreateInstance(wca.CLSID_MMDeviceEnumerator, 0, wca.CLSCTX_ALL, wca.IID_IMMDeviceEnumerator, &mmde); err != nil {
+		return fmt.Errorf("failed to create device enumerator: %w", err)
+	}
+	defer mmde.Release()
+
+	return f(mmde)
+}
+
+// withDevice resolves a device ID (or one of the default IDs) and hands it to f
+func (b *wcaBackend) withDevice(id string, f func(mmDevice *wca.IMMDevice, capture bool) error) error {
+	return b.withEnumerator(func(mmde *wca.IMMDeviceEnumerator) error {
+		mmDevice, capture, err := findDevice(mmde, id)
+		if err != nil {
+			return err
+		}
+		defer mmDevice.Release()
+
+		return f(mmDevice, capture)
+	})
+}
+
+func (b *wcaBackend) withEndpointVolume(id string, f func(endpointVolume *wca.IAudioEndpointVolume) error) error {
+	return b.withDevice(id, func(mmDevice *wca.IMMDevice, _ bool) error {
+		var endpointVolume *wca.IAudioEndpointVolume
+		if err := mmDevice.Activate(wca.IID_IAudioEndpointVolume, wca.CLSCTX_ALL, nil, &endpointVolume); err != nil {
+			return fmt.Errorf("failed to activate endpoint volume: %w", err)
+		}
+		defer endpointVolume.Release()
+
+		return f(endpointVolume)
+	})
+}
+
+// forMatchingSessions applies f to every session accepted by match and returns the match count
+func (b *wcaBackend) forMatchingSessions(match SessionMatcher, f func(session AudioSession, simpleVolume *wca.ISimpleAudioVolume) error) (int, error) {
+	matched := 0
+	var firstErr error
+
+	err := b.withEnumerator(func(mmde *wca.IMMDeviceEnumerator) error {
+		return b.forEachSession(mmde, func(session AudioSession, simpleVolume *wca.ISimpleAudioVolume) error {
+			if !match(session) {
+				return nil
+			}
+			if err := f(session, simpleVolume); err != nil {
+				if firstErr == nil {
+					firstErr = err
+				}
+				return nil
+			}
+			matched++
+			return nil
+		})
+	})
+	if err != nil {
+		return matched, err
+	}
+
+	return matched, firstErr
+}
+
+// forEachSession walks every session on the default output device. Sessions that
+// can't be inspected are skipped; an error from f stops the walk.
+func (b *wcaBackend) forEachSession(mmde *wca.IMMDeviceEnumerator, f func(session AudioSession, simpleVolume *wca.ISimpleAudioVolume) error) error {
+	var mmDevice *wca.IMMDevice
+	if err := mmde.GetDefaultAudioEndpoint(wca.ERender, wca.EConsole, &mmDevice); err != nil {
+		return fmt.Errorf("failed to get default audio endpoint: %w", err)
+	}
+	defer mmDevice.Release()
+
+	deviceName := friendlyName(mmDevice)
+
+	// Going through every window is too slow for each slider move, and only title:
+	// targets look at the titles
+	var titles map[uint32]string
+	if config, loaded := activeConfig.Load().(*deejConfig); loaded && config.TitleTargets {
+		titles = windowTitles()
+	}
+
+	var sessionManager *wca.IAudioSessionManager2
+	if err := mmDevice.Activate(wca.IID_IAudioSessionManager2, wca.CLSCTX_ALL, nil, &sessionManager); err != nil {
+		return fmt.Errorf("failed to activate session manager: %w", err)
+	}
+	defer sessionManager.Release()
+
+	var sessionEnumerator *wca.IAudioSessionEnumerator
+	if err := sessionManager.GetSessionEnumerator(&sessionEnumerator); err != nil {
+		return fmt.Errorf("failed to get session enumerator: %w", err)
+	}
+	defer sessionEnumerator.Release()
+
+	var sessionCount int
+	if err := sessionEnumerator.GetCount(&sessionCount); err != nil {
+		return fmt.Errorf("failed to get session count: %w", err)
+	}
+
+	for i := 0; i < sessionCount; i++ {
+		if err := visitSession(sessionEnumerator, i, deviceName, titles, f); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func visitSession(sessionEnumerator *wca.IAudioSessionEnumerator, index int, deviceName string, titles map[uint32]string, f func(session AudioSession, simpleVolume *wca.ISimpleAudioVolume) error) error {
+	var sessionControl *wca.IAudioSessionControl
+	if err := sessionEnumerator.GetSession(index, &sessionControl); err != nil || sessionControl == nil {
+		return nil
+	}
+	defer sessionControl.Release()
+
+	sessionControl2Dispatch, err := sessionControl.QueryInterface(wca.IID_IAudioSessionControl2)
+	if err != nil {
+		return nil
+	}
+	defer sessionControl2Dispatch.Release()
+	sessionControl2 := (*wca.IAudioSessionControl2)(unsafe.Pointer(sessionControl2Dispatch))
+
+	var processId uint32
+	if err := sessionControl2.GetProcessId(&processId); err != nil {
+		return nil
+	}
+
+	simpleVolumeDispatch, err := sessionControl2.QueryInterface(wca.IID_ISimpleAudioVolume)
+	if err != nil {
+		return nil
+	}
+	defer simpleVolumeDispatch.Release()
+	simpleVolume := (*wca.ISimpleAudioVolume)(unsafe.Pointer(simpleVolumeDispatch))
+
+	var volumeScalar float32
+	if err := simpleVolume.GetMasterVolume(&volumeScalar); err != nil {
+		return nil
+	}
+	var muted bool
+	simpleVolume.GetMute(&muted)
+
+	path := getProcessPathWindows(processId)
+	session := AudioSession{
+		ProcessName: path[strings.LastIndex(path, `\`)+1:],
+		Path:        path,
+		DisplayName: sessionDisplayName(sessionControl),
+		WindowTitle: titles[processId],
+		PID:         processId,
+		Volume:      scalarToPercent(volumeScalar),
+		Muted:       muted,
+		Device:      deviceName,
+	}
+
+	return f(session, simpleVolume)
+}
+
+// findDevice resolves an endpoint ID, accepting defaultRenderDevice and defaultCaptureDevice
+func findDevice(mmde *wca.IMMDeviceEnumerator, id string) (*wca.IMMDevice, bool, error) {
+	switch id {
+	case defaultRenderDevice, defaultCaptureDevice:
+		flow := uint32(wca.ERender)
+		if id == defaultCaptureDevice {
+			flow = wca.ECapture
+		}
+
+		var mmDevice *wca.IMMDevice
+		if err := mmde.GetDefaultAudioEndpoint(flow, wca.EConsole, &mmDevice); err != nil {
+			return nil, false, fmt.Errorf("failed to get default audio endpoint: %w", err)
+		}
+		return mmDevice, flow == wca.ECapture, nil
+	}
+
+	for _, flow := range []uint32{wca.ERender, wca.ECapture} {
+		var collection *wca.IMMDeviceCollection
+		if err := mmde.EnumAudioEndpoints(flow, wca.DEVICE_STATE_ACTIVE, &collection); err != nil {
+			return nil, false, fmt.Errorf("failed to enumerate audio endpoints: %w", err)
+		}
+
+		var count uint32
+		collection.GetCount(&count)
+
+		for i := uint32(0); i < count; i++ {
+			var mmDevice *wca.IMMDevice
+			if err := collection.Item(i, &mmDevice); err != nil {
+				continue
+			}
+
+			var deviceID string
+			if err := mmDevice.GetId(&deviceID); err == nil && deviceID == id {
+				collection.Release()
+				return mmDevice, flow == wca.ECapture, nil
+			}
+			mmDevice.Release()
+		}
+
+		collection.Release()
+	}
+
+	return nil, false, fmt.Errorf("audio device %q not found", id)
+}
+
+func describeDevice(mmDevice *wca.IMMDevice, capture bool) (AudioDevice, error) {
+	device := AudioDevice{Capture: capture}
+
+	if err := mmDevice.GetId(&device.ID); err != nil {
+		return device, fmt.Errorf("failed to get device id: %w", err)
+	}
+	device.Name = friendlyName(mmDevice)
+
+	var endpointVolume *wca.IAudioEndpointVolume
+	if err := mmDevice.Activate(wca.IID_IAudioEndpointVolume, wca.CLSCTX_ALL, nil, &endpointVolume); err != nil {
+		return device, fmt.Errorf("failed to activate endpoint volume: %w", err)
+	}
+	defer endpointVolume.Release()
+
+	var volumeScalar float32
+	if err := endpointVolume.GetMasterVolumeLevelScalar(&volumeScalar); err != nil {
+		return device, fmt.Errorf("failed to get endpoint volume: %w", err)
+	}
+	device.Volume = scalarToPercent(volumeScalar)
+	endpointVolume.GetMute(&device.Muted)
+
+	return device, nil
+}
+
+func defaultEndpointID(mmde *wca.IMMDeviceEnumerator, flow uint32) string {
+	var mmDevice *wca.IMMDevice
+	if err := mmde.GetDefaultAudioEndpoint(flow, wca.EConsole, &mmDevice); err != nil {
+		return ""
+	}
+	defer mmDevice.Release()
+
+	var id string
+	mmDevice.GetId(&id)
+	return id
+}
+
+// friendlyName returns a device's display name, i.e. "Speakers (Realtek High Definition Audio)"
+func friendlyName(mmDevice *wca.IMMDevice) string {
+	var propertyStore *wca.IPropertyStore
+	if err := mmDevice.OpenPropertyStore(wca.STGM_READ, &propertyStore); err != nil {
+		return ""
+	}
+	defer propertyStore.Release()
+
+	var value wca.PROPVARIANT
+	if err := propertyStore.GetValue(&wca.PKEY_Device_FriendlyName, &value); err != nil {
+		return ""
+	}
+	return value.String()
+}
+
+// scalarToPercent converts a 0.0-1.0 volume scalar to a 0-100 percentage
+func scalarToPercent(volumeScalar float32) int {
+	return int(math.Round(float64(volumeScalar) * 100))
+}
+
+// sessionDisplayName reads the name an application gave its session. go-wca's
+// GetDisplayName reads the string even when the call failed, so the vtable is used
+// directly. Names that point into a resource DLL, like "@%SystemRoot%\...", are skipped.
+func sessionDisplayName(sessionControl *wca.IAudioSessionControl) string {
+	var namePtr *uint16
+	hr, _, _ := syscall.Syscall(
+		sessionControl.VTable().GetDisplayName,
+		2,
+		uintptr(unsafe.Pointer(sessionControl)),
+		uintptr(unsafe.Pointer(&namePtr)),
+		0)
+	if hr != 0 || namePtr == nil {
+		return ""
+	}
+	defer ole.CoTaskMemFree(uintptr(unsafe.Pointer(namePtr)))
+
+	name := windows.UTF16PtrToString(namePtr)
+	if strings.HasPrefix(name, "@") {
+		return ""
+	}
+	return name
+}
+
+// getProcessPathWindows returns the full path of a process's executable, or "" if it
+// can't be read
+func getProcessPathWindows(pid uint32) string {
+	kernel32 := syscall.NewLazyDLL("kernel32.dll")
+	openProcess := kernel32.NewProc("OpenProcess")
+	queryFullProcessImageName := kernel32.NewProc("QueryFullProcessImageNameW")
+	closeHandle := kernel32.NewProc("CloseHandle")
+
+	handle, _, _ := openProcess.Call(
+		0x1000, // PROCESS_QUERY_LIMITED_INFORMATION
+		0,
+		uintptr(pid),
+	)
+
+	if handle == 0 {
+		return ""
+	}
+	defer closeHandle.Call(handle)
+
+	var size uint32 = 260
+	buffer := make([]uint16, size)
+
+	ret, _, _ := queryFullProcessImageName.Call(
+		handle,
+		0,
+		uintptr(unsafe.Pointer(&buffer[0])),
+		uintptr(unsafe.Pointer(&size)),
+	)
+
+	if ret == 0 {
+		return ""
+	}
+
+	return syscall.UTF16ToString(buffer[:size])
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/spf13/viper"
+)
+
+// Editors often write a file in several steps, so changes are picked up once it settles
+const configReloadDelay = 250 * time.Millisecond
+
+// deejConfig is the part of config.yaml the running program uses. It is built and
+// checked as a whole, and replaced as a whole when the file changes.
+type deejConfig struct {
+	SliderMapping   map[string]int          // name -> number (for verbose/help)
+	SliderTargets   map[int][]string        // slider number -> list of targets
+	SliderResponses map[int]*sliderResponse // slider number -> how its position maps to volume
+	ButtonMapping   map[int]*buttonBinding  // button number -> what it does
+	Layers          []*configLayer          // alternate mappings, switched by buttons
+	Profiles        []*configProfile        // mappings used instead of the top-level ones
+	COMPort         string
+	BaudRate        uint
+	USBIDs          []string
+	UnmappedSync    string // how a deej.unmapped slider follows apps whose volumes differ
+	TitleTargets    bool   // some target matches window titles
+
+	// Where sliders, buttons and layer buttons are first mapped in the file, for later warnings
+	sliderLines map[int]int
+	buttonLines map[int]int
+	layerLines  map[string]int
+}
+
+var activeConfig atomic.Value // *deejConfig
+
+// currentConfig returns the config in effect; callers must not modify it
+func currentConfig() *deejConfig {
+	return activeConfig.Load().(*deejConfig)
+}
+
+// initializeConfig creates and configures a viper instance for the config file
+func initializeConfig() (*viper.Viper, error) {
+	config := viper.New()
+	config.SetConfigName(configName)
+	config.SetConfigType(configType)
+	config.AddConfigPath(configPath)
+
+	// Set defaults
+	config.SetDefault(configKeySliderMapping, map[int]string{})
+	config.SetDefault(configKeyCOMPort, defaultCOMPort)
+	config.SetDefault(configKeyBaudRate, defaultBaudRate)
+	config.SetDefault(configKeyUSBIDs, defaultUSBIDs)
+	config.SetDefault(configKeyUnmappedSync, defaultUnmappedSync)
+
+	// Read config file
+	if err := config.ReadInConfig(); err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	if verbose {
+		fmt.Printf("Loaded config from: %s\n", config.ConfigFileUsed())
+	}
+	return config, nil
+}
+
+// loadConfig builds a deejConfig from a viper instance. The file is checked by
+// checkConfigFile first, and any problem it finds fails the whole load; the slider
+// and button mappings, layers and profiles come straight from the check.
+func loadConfig(v *viper.Viper) (*deejConfig, error) {
+	check, err := checkConfigFile(v.ConfigFileUsed())
+	if err != nil {
+		return nil, err
+	}
+	if err := check.Err(); err != nil {
+		return nil, err
+	}
+
+	config := &deejConfig{
+		SliderMapping:   make(map[string]int),
+		SliderTargets:   check.Sliders,
+		SliderResponses: check.Responses,
+		ButtonMapping:   check.Buttons,
+		Layers:          check.Layers,
+		Profiles:        check.Profiles,
+		COMPort:         strings.TrimSpace(v.GetString(configKeyCOMPort)),
+		BaudRate:        v.GetUint(configKeyBaudRate),
+		UnmappedSync:    strings.ToLower(strings.TrimSpace(v.GetString(configKeyUnmappedSync))),
+		TitleTargets:    check.TitleTargets,
+		sliderLines:     check.SliderLines,
+		buttonLines:     check.ButtonLines,
+		layerLines:      check.LayerLines,
+	}
+
+	for sliderNum, targets := range config.SliderTargets {
+		for _, name := range targets {
+			config.SliderMapping[name] = sliderNum
+		}
+	}
+
+	// The check already turned down entries that aren't hex or aren't quoted
+	for _, id := range v.GetStringSlice(configKeyUSBIDs) {
+		if normalized, ok := normalizeUSBID(id); ok {
+			config.USBIDs = append(config.USBIDs, normalized)
+		}
+	}
+
+	return config, nil
+}
+
+// applyConfig makes config the one in effect
+func applyConfig(config *deejConfig) {
+	activeConfig.Store(config)
+	sliders.Resize(config.sliderCount())
+
+	if verbose {
+		for _, sliderNum := range config.sliderNumbers() {
+			fmt.Printf("Slider %d -> %s\n", sliderNum, strings.Join(config.SliderTargets[sliderNum], ", "))
+		}
+	}
+}
+
+// sliderNumbers returns the mapped sliders in order
+func (c *deejConfig) sliderNumbers() []int {
+	numbers := make([]int, 0, len(c.SliderTargets))
+	for sliderNum := range c.SliderTargets {
+		numbers = append(numbers, sliderNum)
+	}
+	sort.Ints(numbers)
+	return numbers
+}
+
+// sliderCount is one more than the highest slider number mapped in any layer
+func (c *deejConfig) sliderCount() int {
+	count := 0
+	for sliderNum := range c.sliderLines {
+		if sliderNum >= count {
+			count = sliderNum + 1
+		}
+	}
+	return count
+}
+
+// watchConfig reloads the config whenever the file changes. An edit that doesn't
+// load is rejected and the previous config stays in effect; changed port settings
+// make conn reconnect.
+func watchConfig(v *viper.Viper, conn *serialConnection) {
+	var mu sync.Mutex
+	var pending *time.Timer
+
+	v.OnConfigChange(func(event fsnotify.Event) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if pending != nil {
+			pending.Stop()
+		}
+		pending = time.AfterFunc(configReloadDelay, func() { reloadConfig(conn) })
+	})
+	v.WatchConfig()
+}
+
+func reloadConfig(conn *serialConnection) {
+	v, err := initializeConfig()
+	if err == nil {
+		var config *deejConfig
+		config, err = loadConfig(v)
+		if err == nil {
+			previous := currentConfig()
+			applyConfig(config)
+			layers.forgetMissing(config)
+			profiles.forgetMissing(config)
+			log.Printf("[Config] Reloaded %s", v.ConfigFileUsed())
+
+			if config.COMPort != previous.COMPort || config.BaudRate != previous.BaudRate || !equalStrings(config.USBIDs, previous.USBIDs) {
+				log.Printf("[Config] Port settings changed, reconnecting to %s at %d baud", config.COMPort, config.BaudRate)
+				conn.Reconnect(serialOptions(config.COMPort, config.BaudRate), config.USBIDs)
+			} else if conn.State() == StateConnected {
+				conn.warnBoardCounts()
+				go syncBoard(conn)
+			}
+			return
+		}
+	}
+
+	log.Printf("[Config] Rejected config change, keeping the previous config: %v", err)
+}
+
+func equalStrings(a []string, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// getSliderTargets returns all target apps for a slider number in the active layer
+func getSliderTargets(sliderNum int) []string {
+	if targets, exists := currentConfig().activeSliderTargets()[sliderNum]; exists {
+		return targets
+	}
+	return nil
+}
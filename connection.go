@@ -0,0 +1,469 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jacobsa/go-serial/serial"
+)
+
+const (
+	writeQueueSize      = 32
+	reconnectMinBackoff = 500 * time.Millisecond
+	reconnectMaxBackoff = 10 * time.Second
+	bannerTimeout       = 10 * time.Second
+	bannerPingInterval  = 2 * time.Second
+)
+
+// ConnectionState is where the serial connection to the board currently stands
+type ConnectionState int
+
+const (
+	StateDisconnected ConnectionState = iota
+	StateConnecting
+	StateConnected
+)
+
+func (s ConnectionState) String() string {
+	switch s {
+	case StateConnecting:
+		return "connecting"
+	case StateConnected:
+		return "connected"
+	default:
+		return "disconnected"
+	}
+}
+
+var (
+	errNotConnected = errors.New("not connected to Arduino")
+	errReconnecting = errors.New("port settings changed")
+)
+
+// serialConnection supervises the serial port: it opens com_port, waits for the board
+// to announce itself, and reopens the port with backoff whenever reading or writing fails.
+// A single writer goroutine owns the port; writes go to whichever port is currently open.
+type serialConnection struct {
+	options serial.OpenOptions
+	usbIDs  []string
+
+	control chan *writeJob
+	bulk    chan *writeJob
+	stop    chan struct{}
+
+	mu       sync.Mutex
+	port     io.ReadWriteCloser
+	portPath string
+	state    ConnectionState
+	closed   bool
+	abort    chan struct{} // closed to stop reading the current port
+
+	protocol protocolMode
+	board    boardInfo
+	seq      byte
+	pending  []*pendingSet
+}
+
+// writeJob is a series of writes handed to the writer goroutine
+type writeJob struct {
+	chunks [][]byte
+	atomic bool // control writes may not be slipped in between the chunks
+	done   chan error
+}
+
+func newSerialConnection(options serial.OpenOptions, usbIDs []string) *serialConnection {
+	c := &serialConnection{
+		options: options,
+		usbIDs:  usbIDs,
+		control: make(chan *writeJob, writeQueueSize),
+		bulk:    make(chan *writeJob, writeQueueSize),
+		stop:    make(chan struct{}),
+	}
+	go c.writeLoop()
+	return c
+}
+
+// State returns the current connection state
+func (c *serialConnection) State() ConnectionState {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.state
+}
+
+// Write queues data ahead of any bulk transfer and waits until it was written. It fails
+// with errNotConnected while the port is down.
+func (c *serialConnection) Write(data []byte) (int, error) {
+	if err := c.queueWrite(c.control, [][]byte{data}, true); err != nil {
+		return 0, err
+	}
+	return len(data), nil
+}
+
+// writeBulk queues a large transfer behind the control writes and waits until it was
+// written. Unless atomic, queued control writes go out between its chunks.
+func (c *serialConnection) writeBulk(chunks [][]byte, atomic bool) error {
+	return c.queueWrite(c.bulk, chunks, atomic)
+}
+
+func (c *serialConnection) queueWrite(queue chan<- *writeJob, chunks [][]byte, atomic bool) error {
+	job := &writeJob{chunks: chunks, atomic: atomic, done: make(chan error, 1)}
+
+	select {
+	case queue <- job:
+	case <-c.stop:
+		return errNotConnected
+	}
+
+	select {
+	case err := <-job.done:
+		return err
+	case <-c.stop:
+		return errNotConnected
+	}
+}
+
+// writeLoop is the only place writing to the port, so writes never interleave.
+// Control writes always go first.
+func (c *serialConnection) writeLoop() {
+	for {
+		select {
+		case job := <-c.control:
+			c.runJob(job)
+			continue
+		case <-c.stop:
+			return
+		default:
+		}
+
+		select {
+		case job := <-c.control:
+			c.runJob(job)
+		case job := <-c.bulk:
+			c.runJob(job)
+		case <-c.stop:
+			return
+		}
+	}
+}
+
+func (c *serialConnection) runJob(job *writeJob) {
+	for i, chunk := range job.chunks {
+		if i > 0 && !job.atomic {
+			c.flushControl()
+		}
+		if err := c.writePort(chunk); err != nil {
+			job.done <- err
+			return
+		}
+	}
+	job.done <- nil
+}
+
+// flushControl writes the control jobs queued so far
+func (c *serialConnection) flushControl() {
+	for {
+		select {
+		case job := <-c.control:
+			c.runJob(job)
+		default:
+			return
+		}
+	}
+}
+
+func (c *serialConnection) writePort(data []byte) error {
+	c.mu.Lock()
+	port := c.port
+	c.mu.Unlock()
+
+	if port == nil {
+		return errNotConnected
+	}
+
+	if _, err := port.Write(data); err != nil {
+		// The reader notices the same failure and triggers the reconnect
+		return fmt.Errorf("failed to write to %s: %w", c.PortPath(), err)
+	}
+	return nil
+}
+
+// Reconnect switches to new port settings. The current port is dropped, so run opens
+// the board again with the new settings.
+func (c *serialConnection) Reconnect(options serial.OpenOptions, usbIDs []string) {
+	c.mu.Lock()
+	c.options = options
+	c.usbIDs = usbIDs
+	c.portPath = ""
+	port := c.port
+	if c.abort != nil {
+		close(c.abort)
+		c.abort = nil
+	}
+	c.mu.Unlock()
+
+	if port != nil {
+		port.Close()
+	}
+}
+
+// untilAborted passes messages on until abort is closed. Closing the port doesn't
+// reliably interrupt a read in progress, so the reader may only notice later;
+// whatever it still reads is drained by run.
+func untilAborted(messages <-chan serialMessage, abort <-chan struct{}) <-chan serialMessage {
+	out := make(chan serialMessage)
+
+	go func() {
+		defer close(out)
+
+		for {
+			select {
+			case message, ok := <-messages:
+				if !ok {
+					return
+				}
+				select {
+				case out <- message:
+				case <-abort:
+					return
+				}
+			case <-abort:
+				return
+			}
+		}
+	}()
+
+	return out
+}
+
+// settings returns the port settings to connect with
+func (c *serialConnection) settings() (serial.OpenOptions, []string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.options, c.usbIDs
+}
+
+// Close stops the supervisor and the writer and closes the port
+func (c *serialConnection) Close() error {
+	c.mu.Lock()
+	if !c.closed {
+		close(c.stop)
+	}
+	c.closed = true
+	port := c.port
+	c.port = nil
+	c.mu.Unlock()
+
+	if port != nil {
+		return port.Close()
+	}
+	return nil
+}
+
+// run keeps the board connected until Close is called. onConnect runs after every
+// successful (re)connection, before messages are read.
+func (c *serialConnection) run(msgChan chan<- ArduinoMessage, onConnect func()) {
+	backoff := reconnectMinBackoff
+
+	for !c.isClosed() {
+		c.setState(StateConnecting)
+
+		port, lines, err := c.open()
+		if err == nil {
+			if err = c.handshake(port, lines); err != nil {
+				port.Close()
+				drainMessages(lines)
+			}
+		}
+		if err != nil {
+			// Stay in StateConnecting while retrying
+			log.Printf("[Connection] %v, retrying in %s", err, backoff)
+			time.Sleep(backoff)
+			backoff *= 2
+			if backoff > reconnectMaxBackoff {
+				backoff = reconnectMaxBackoff
+			}
+			continue
+		}
+		backoff = reconnectMinBackoff
+
+		c.mu.Lock()
+		if c.closed {
+			c.mu.Unlock()
+			port.Close()
+			return
+		}
+		c.port = port
+		abort := make(chan struct{})
+		c.abort = abort
+		c.mu.Unlock()
+
+		path := c.PortPath()
+		options, _ := c.settings()
+		fmt.Printf("Connected to Arduino on %s at %d baud\n", path, options.BaudRate)
+		c.setState(StateConnected)
+
+		if onConnect != nil {
+			onConnect()
+		}
+
+		err = readFromArduino(c, untilAborted(lines, abort), msgChan)
+
+		c.mu.Lock()
+		if c.port == port {
+			c.port = nil
+		}
+		if c.abort == abort {
+			close(abort)
+			c.abort = nil
+		} else {
+			// Reconnect got there first
+			err = errReconnecting
+		}
+		c.mu.Unlock()
+		port.Close()
+		drainMessages(lines)
+		c.failPending()
+
+		if c.isClosed() {
+			return
+		}
+		log.Printf("[Connection] Lost connection to %s: %v", path, err)
+		c.setState(StateDisconnected)
+	}
+}
+
+// open opens the port and waits until the board answers. With com_port: auto, every
+// serial device matching usb_ids is tried until one answers.
+func (c *serialConnection) open() (io.ReadWriteCloser, <-chan serialMessage, error) {
+	options, usbIDs := c.settings()
+	if !strings.EqualFold(options.PortName, autoCOMPort) {
+		port, lines, _, err := probePort(options, bannerTimeout)
+		if err != nil {
+			return nil, nil, err
+		}
+		c.setPortPath(options.PortName)
+		return port, lines, nil
+	}
+
+	candidates, port, lines, err := discoverPort(options, usbIDs, true)
+	if err != nil {
+		if verbose {
+			for _, candidate := range candidates {
+				log.Printf("[Discovery] %s (%s): %s", candidate.Path, candidate.usbID(), candidate.Reason)
+			}
+		}
+		return nil, nil, err
+	}
+
+	found := candidates[len(candidates)-1]
+	log.Printf("[Discovery] Found board on %s (%s), %s", found.Path, found.usbID(), found.Reason)
+	c.setPortPath(found.Path)
+	return port, lines, nil
+}
+
+// waitForBoard waits for the "Arduino ready" banner and returns what the board said.
+// A board that was already running won't print it again, so it is pinged meanwhile
+// and PONG is accepted as well. This always happens in the text protocol.
+func waitForBoard(port io.Writer, lines <-chan serialMessage, timeout time.Duration) (string, error) {
+	deadline := time.After(timeout)
+	ping := time.NewTicker(bannerPingInterval)
+	defer ping.Stop()
+
+	port.Write([]byte("PING\n"))
+
+	for {
+		select {
+		case line := <-lines:
+			if line.err != nil {
+				return "", line.err
+			}
+			if line.text == "Arduino ready" || line.text == "PONG" {
+				if verbose {
+					fmt.Printf("[Arduino] %s\n", line.text)
+				}
+				return line.text, nil
+			}
+		case <-ping.C:
+			port.Write([]byte("PING\n"))
+		case <-deadline:
+			return "", fmt.Errorf("timed out after %s", timeout)
+		}
+	}
+}
+
+// PortPath returns the device the board was last found on, which differs from
+// com_port when it is set to auto
+func (c *serialConnection) PortPath() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.portPath == "" {
+		return c.options.PortName
+	}
+	return c.portPath
+}
+
+func (c *serialConnection) setPortPath(path string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.portPath = path
+}
+
+func (c *serialConnection) isClosed() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.closed
+}
+
+func (c *serialConnection) setState(state ConnectionState) {
+	c.mu.Lock()
+	if c.state == state {
+		c.mu.Unlock()
+		return
+	}
+	previous := c.state
+	c.state = state
+	c.mu.Unlock()
+
+	log.Printf("[Connection] %s -> %s", previous, state)
+}
+
+// syncBoard brings the board in line with the host after it (re)connected or the
+// mapping changed: its display learns the active layer, and its faders the volumes
+func syncBoard(conn *serialConnection) {
+	sendActiveLayer(conn)
+	resyncSliders(conn)
+}
+
+// resyncSliders pushes the current volume of every mapped slider to the board, so
+// motor faders catch up with changes made while it was disconnected, and whether each
+// slider is muted, which unmapped ones aren't. It waits for the board's answers, so it
+// must not run on the goroutine reading from the board.
+func resyncSliders(conn *serialConnection) {
+	for sliderNum, count := 0, sliders.Count(); sliderNum < count; sliderNum++ {
+		syncSliderMute(conn, sliderNum, true)
+	}
+
+	for sliderNum := range currentConfig().activeSliderTargets() {
+		volume, ok := readSliderVolume(sliderNum)
+		if _, tracked := sliders.Position(sliderNum); !ok || !tracked {
+			continue
+		}
+
+		position := currentConfig().sliderResponse(sliderNum).position(volume)
+		if err := conn.SetSlider(sliderNum, position); err != nil {
+			log.Printf("Error moving slider %d: %v", sliderNum, err)
+			continue
+		}
+		sliders.SetPosition(sliderNum, position)
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"math"
+)
+
+// Curves a slider's travel can follow
+const (
+	curveLinear      = "linear"
+	curveLogarithmic = "logarithmic"
+)
+
+var sliderCurves = []string{curveLinear, curveLogarithmic}
+
+// curvePoint is a point of a custom curve: at Position percent of its travel, a
+// slider sets Volume
+type curvePoint struct {
+	Position float64
+	Volume   float64
+}
+
+// sliderResponse maps a slider's position to the volume it sets, and a volume back
+// to the position a motor fader moves to. The position is inverted first, then the
+// dead zones at both ends are taken off, the curve is applied, and the volume is
+// kept between Min and Max.
+type sliderResponse struct {
+	Curve    string       // curveLinear or curveLogarithmic, unless Points is set
+	Points   []curvePoint // a custom curve, by increasing position and volume
+	Invert   bool
+	Min      int
+	Max      int
+	DeadZone int // percent of the travel at either end that counts as the end
+
+	// Smoothing is the percent of the way to a new volume left after each step there;
+	// 0 sets it at once
+	Smoothing int
+}
+
+// Beyond this, smoothed volumes take seconds to follow a slider
+const maxVolumeSmoothing = 90
+
+var defaultSliderResponse = &sliderResponse{Curve: curveLinear, Max: 100}
+
+// sliderResponse returns how a slider's position maps to volume
+func (c *deejConfig) sliderResponse(sliderNum int) *sliderResponse {
+	if response, exists := c.SliderResponses[sliderNum]; exists {
+		return response
+	}
+	return defaultSliderResponse
+}
+
+// volume returns the volume a slider sets at a position
+func (r *sliderResponse) volume(position int) int {
+	travel := float64(clampVolume(position))
+	if r.Invert {
+		travel = 100 - travel
+	}
+
+	deadZone := float64(r.DeadZone)
+	switch {
+	case travel <= deadZone:
+		travel = 0
+	case travel >= 100-deadZone:
+		travel = 100
+	default:
+		travel = (travel - deadZone) * 100 / (100 - 2*deadZone)
+	}
+
+	return r.clamp(int(math.Round(r.curve(travel))))
+}
+
+// position returns where a motor fader has to be to set a volume. Volumes outside
+// Min and Max move it to the nearest one it can set.
+func (r *sliderResponse) position(volume int) int {
+	travel := r.inverseCurve(float64(r.clamp(volume)))
+
+	deadZone := float64(r.DeadZone)
+	if travel > 0 && travel < 100 {
+		travel = deadZone + travel*(100-2*deadZone)/100
+	}
+	if r.Invert {
+		travel = 100 - travel
+	}
+	return clampVolume(int(math.Round(travel)))
+}
+
+func (r *sliderResponse) clamp(volume int) int {
+	if volume < r.Min {
+		return r.Min
+	}
+	if volume > r.Max {
+		return r.Max
+	}
+	return volume
+}
+
+// curve maps the travel past the dead zone to a volume. The logarithmic curve is an
+// audio taper spanning 40 dB, which leaves the lower half of the travel for quiet
+// volumes.
+func (r *sliderResponse) curve(travel float64) float64 {
+	if len(r.Points) > 0 {
+		return interpolate(r.Points, travel, func(p curvePoint) float64 { return p.Position }, func(p curvePoint) float64 { return p.Volume })
+	}
+	if r.Curve == curveLogarithmic {
+		return 100 * (math.Pow(10, travel/50) - 1) / 99
+	}
+	return travel
+}
+
+func (r *sliderResponse) inverseCurve(volume float64) float64 {
+	if len(r.Points) > 0 {
+		return interpolate(r.Points, volume, func(p curvePoint) float64 { return p.Volume }, func(p curvePoint) float64 { return p.Position })
+	}
+	if r.Curve == curveLogarithmic {
+		return 50 * math.Log10(1+volume*99/100)
+	}
+	return volume
+}
+
+// interpolate follows a custom curve from one axis to the other, in straight lines
+// between its points and level beyond them. Where the curve is level, the first
+// point of the level stretch is used.
+func interpolate(points []curvePoint, x float64, from, to func(curvePoint) float64) float64 {
+	if x <= from(points[0]) {
+		return to(points[0])
+	}
+	for i := 1; i < len(points); i++ {
+		x0, x1 := from(points[i-1]), from(points[i])
+		if x > x1 {
+			continue
+		}
+		if x1 == x0 {
+			return to(points[i-1])
+		}
+		return to(points[i-1]) + (x-x0)*(to(points[i])-to(points[i-1]))/(x1-x0)
+	}
+	return to(points[len(points)-1])
+}
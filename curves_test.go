@@ -0,0 +1,104 @@
+package main
+
+import "testing"
+
+func TestSliderResponse(t *testing.T) {
+	deadZone := &sliderResponse{Curve: curveLinear, DeadZone: 10, Max: 100}
+	clamped := &sliderResponse{Curve: curveLinear, Min: 20, Max: 80}
+
+	tests := []struct {
+		name     string
+		response *sliderResponse
+		position int
+		volume   int
+	}{
+		{"linear", defaultSliderResponse, 30, 30},
+		{"linear below range", defaultSliderResponse, -5, 0},
+		{"linear above range", defaultSliderResponse, 120, 100},
+		{"inverted bottom", &sliderResponse{Curve: curveLinear, Invert: true, Max: 100}, 0, 100},
+		{"inverted top", &sliderResponse{Curve: curveLinear, Invert: true, Max: 100}, 100, 0},
+		{"inverted", &sliderResponse{Curve: curveLinear, Invert: true, Max: 100}, 30, 70},
+		{"dead zone bottom", deadZone, 5, 0},
+		{"dead zone bottom edge", deadZone, 10, 0},
+		{"dead zone top", deadZone, 95, 100},
+		{"dead zone top edge", deadZone, 90, 100},
+		{"dead zone rescales the rest", deadZone, 30, 25},
+		{"dead zone middle", deadZone, 50, 50},
+		{"inverted dead zone", &sliderResponse{Curve: curveLinear, Invert: true, DeadZone: 10, Max: 100}, 95, 0},
+		{"clamped to min", clamped, 0, 20},
+		{"clamped to max", clamped, 100, 80},
+		{"between min and max", clamped, 50, 50},
+		{"logarithmic bottom", &sliderResponse{Curve: curveLogarithmic, Max: 100}, 0, 0},
+		{"logarithmic middle", &sliderResponse{Curve: curveLogarithmic, Max: 100}, 50, 9},
+		{"logarithmic top", &sliderResponse{Curve: curveLogarithmic, Max: 100}, 100, 100},
+		{"points", &sliderResponse{Points: []curvePoint{{0, 0}, {50, 20}, {100, 100}}, Max: 100}, 25, 10},
+		{"points past the knee", &sliderResponse{Points: []curvePoint{{0, 0}, {50, 20}, {100, 100}}, Max: 100}, 75, 60},
+	}
+
+	for _, test := range tests {
+		if got := test.response.volume(test.position); got != test.volume {
+			t.Errorf("%s: volume(%d) = %d, want %d", test.name, test.position, got, test.volume)
+		}
+	}
+}
+
+func TestSliderResponsePosition(t *testing.T) {
+	deadZone := &sliderResponse{Curve: curveLinear, DeadZone: 10, Max: 100}
+
+	tests := []struct {
+		name     string
+		response *sliderResponse
+		volume   int
+		position int
+	}{
+		{"linear", defaultSliderResponse, 30, 30},
+		{"linear above range", defaultSliderResponse, 120, 100},
+		{"inverted", &sliderResponse{Curve: curveLinear, Invert: true, Max: 100}, 70, 30},
+		{"inverted silence", &sliderResponse{Curve: curveLinear, Invert: true, Max: 100}, 0, 100},
+		{"dead zone bottom", deadZone, 0, 0},
+		{"dead zone top", deadZone, 100, 100},
+		{"dead zone", deadZone, 25, 30},
+		{"logarithmic", &sliderResponse{Curve: curveLogarithmic, Max: 100}, 9, 50},
+	}
+
+	for _, test := range tests {
+		if got := test.response.position(test.volume); got != test.position {
+			t.Errorf("%s: position(%d) = %d, want %d", test.name, test.volume, got, test.position)
+		}
+	}
+}
+
+// TestSliderResponseRoundTrip checks that moving a motor fader to position(volume) reads back
+// the same volume, and lands near where the slider was wherever the curve tells positions apart
+func TestSliderResponseRoundTrip(t *testing.T) {
+	tests := []struct {
+		name      string
+		response  *sliderResponse
+		from, to  int // positions the curve tells apart
+		tolerance int
+	}{
+		{"linear", defaultSliderResponse, 0, 100, 0},
+		{"inverted", &sliderResponse{Curve: curveLinear, Invert: true, Max: 100}, 0, 100, 0},
+		{"dead zone", &sliderResponse{Curve: curveLinear, DeadZone: 10, Max: 100}, 11, 89, 1},
+		{"clamped", &sliderResponse{Curve: curveLinear, Min: 20, Max: 80}, 20, 80, 0},
+		{"logarithmic", &sliderResponse{Curve: curveLogarithmic, Max: 100}, 30, 100, 2},
+		{"points", &sliderResponse{Points: []curvePoint{{0, 0}, {50, 20}, {100, 100}}, Max: 100}, 0, 100, 2},
+		{"everything", &sliderResponse{Curve: curveLogarithmic, Invert: true, DeadZone: 5, Min: 10, Max: 90}, 101, 100, 0}, // only the volume round trips
+	}
+
+	for _, test := range tests {
+		for x := 0; x <= 100; x++ {
+			volume := test.response.volume(x)
+			position := test.response.position(volume)
+			if got := test.response.volume(position); got != volume {
+				t.Errorf("%s: volume(position(%d)) = %d, want %d", test.name, volume, got, volume)
+			}
+			if x < test.from || x > test.to {
+				continue
+			}
+			if position < x-test.tolerance || position > x+test.tolerance {
+				t.Errorf("%s: position(volume(%d)) = %d, want %d±%d", test.name, x, position, x, test.tolerance)
+			}
+		}
+	}
+}
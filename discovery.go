@@ -0,0 +1,219 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jacobsa/go-serial/serial"
+)
+
+const (
+	autoCOMPort  = "auto"
+	probeTimeout = 5 * time.Second
+)
+
+// defaultUSBIDs covers the usual Arduino boards and USB-serial adapters.
+// Entries are "VID:PID" in hex, or just "VID" to accept every product of a vendor.
+var defaultUSBIDs = []string{
+	"2341",      // Arduino
+	"2a03",      // Arduino (arduino.org)
+	"1b4f",      // SparkFun Pro Micro
+	"1a86:7523", // CH340
+	"0403:6001", // FTDI FT232
+	"10c4:ea60", // Silicon Labs CP210x
+}
+
+// serialPortInfo is a serial device found on the system
+type serialPortInfo struct {
+	Path        string
+	VID         string // lowercase hex, empty when not a USB device
+	PID         string
+	Description string
+}
+
+func (p serialPortInfo) usbID() string {
+	if p.VID == "" {
+		return "-"
+	}
+	return p.VID + ":" + p.PID
+}
+
+// serialCandidate is a discovered port together with the verdict on it
+type serialCandidate struct {
+	serialPortInfo
+	Accepted bool
+	Reason   string
+}
+
+// usbIDAllowed checks a port against the configured VID/PID list, as normalizeUSBID
+// leaves it; an empty list allows everything
+func usbIDAllowed(info serialPortInfo, usbIDs []string) (bool, string) {
+	if len(usbIDs) == 0 {
+		return true, ""
+	}
+	if info.VID == "" {
+		return false, "not a USB device"
+	}
+
+	for _, id := range usbIDs {
+		vid, pid := id, ""
+		if i := strings.Index(id, ":"); i >= 0 {
+			vid, pid = id[:i], id[i+1:]
+		}
+		if vid == info.VID && (pid == "" || pid == info.PID) {
+			return true, ""
+		}
+	}
+	return false, fmt.Sprintf("USB ID %s is not in %s", info.usbID(), configKeyUSBIDs)
+}
+
+// probePort opens a port and waits for the board to answer. On success the port is
+// left open and its lines are returned, together with what the board said.
+func probePort(options serial.OpenOptions, timeout time.Duration) (io.ReadWriteCloser, <-chan serialMessage, string, error) {
+	port, err := serial.Open(options)
+	if err != nil {
+		return nil, nil, "", fmt.Errorf("failed to open %s: %w", options.PortName, err)
+	}
+
+	lines := readMessages(port)
+	answer, err := waitForBoard(port, lines, timeout)
+	if err != nil {
+		port.Close()
+		drainMessages(lines)
+		return nil, nil, "", fmt.Errorf("no answer from board on %s: %w", options.PortName, err)
+	}
+
+	return port, lines, answer, nil
+}
+
+// discoverPort looks for the board among all serial devices. With keepOpen, it stops at
+// the first board that answers and returns it still open; otherwise every candidate is
+// probed and closed again, which is what `deej ports` shows.
+func discoverPort(options serial.OpenOptions, usbIDs []string, keepOpen bool) ([]serialCandidate, io.ReadWriteCloser, <-chan serialMessage, error) {
+	ports, err := listSerialPorts()
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to list serial ports: %w", err)
+	}
+
+	candidates := make([]serialCandidate, 0, len(ports))
+	for _, info := range ports {
+		candidate := serialCandidate{serialPortInfo: info}
+
+		if ok, reason := usbIDAllowed(info, usbIDs); !ok {
+			candidate.Reason = reason
+			candidates = append(candidates, candidate)
+			continue
+		}
+
+		probeOptions := options
+		probeOptions.PortName = info.Path
+		port, lines, answer, err := probePort(probeOptions, probeTimeout)
+		if err != nil {
+			candidate.Reason = err.Error()
+			candidates = append(candidates, candidate)
+			continue
+		}
+
+		candidate.Accepted = true
+		candidate.Reason = fmt.Sprintf("answered %q", answer)
+		candidates = append(candidates, candidate)
+
+		if keepOpen {
+			return candidates, port, lines, nil
+		}
+		port.Close()
+		drainMessages(lines)
+	}
+
+	if keepOpen {
+		return candidates, nil, nil, fmt.Errorf("no board found on %d serial ports", len(ports))
+	}
+	return candidates, nil, nil, nil
+}
+
+// runPortsCommand implements `deej ports`
+func runPortsCommand() error {
+	var err error
+	userConfig, err = initializeConfig()
+	if err != nil {
+		return err
+	}
+	config, err := loadConfig(userConfig)
+	if err != nil {
+		return err
+	}
+
+	options := serialOptions(autoCOMPort, config.BaudRate)
+	usbIDs := config.USBIDs
+
+	fmt.Println("Probing serial ports...")
+	candidates, _, _, err := discoverPort(options, usbIDs, false)
+	if err != nil {
+		return err
+	}
+	if len(candidates) == 0 {
+		fmt.Println("No serial ports found.")
+		return nil
+	}
+
+	for _, candidate := range candidates {
+		verdict := "rejected"
+		if candidate.Accepted {
+			verdict = "accepted"
+		}
+		description := candidate.Description
+		if description == "" {
+			description = "-"
+		}
+		fmt.Printf("  %-16s %-10s %-30s %s: %s\n", candidate.Path, candidate.usbID(), description, verdict, candidate.Reason)
+	}
+
+	if len(usbIDs) > 0 {
+		fmt.Printf("\nAccepted USB IDs (%s): %s\n", configKeyUSBIDs, strings.Join(usbIDs, ", "))
+	}
+	return nil
+}
+
+func serialOptions(portName string, baudRate uint) serial.OpenOptions {
+	return serial.OpenOptions{
+		PortName:        portName,
+		BaudRate:        baudRate,
+		DataBits:        8,
+		StopBits:        1,
+		MinimumReadSize: 1,
+	}
+}
+
+// normalizeUSBID brings a usb_ids entry to the form ports are described in:
+// "0x2341" becomes "2341", "403:6001" becomes "0403:6001" and "2341:*" becomes
+// "2341". ok is false for entries that aren't hex.
+func normalizeUSBID(id string) (normalized string, ok bool) {
+	vid, pid := strings.TrimSpace(id), ""
+	if i := strings.Index(vid, ":"); i >= 0 {
+		vid, pid = vid[:i], strings.TrimSpace(vid[i+1:])
+	}
+
+	vid = parseHexID(vid)
+	if vid == "" {
+		return "", false
+	}
+	if pid == "" || pid == "*" {
+		return vid, true
+	}
+	if pid = parseHexID(pid); pid == "" {
+		return "", false
+	}
+	return vid + ":" + pid, true
+}
+
+// parseHexID normalizes a hex VID/PID like "0x2341" or "2341" to "2341"
+func parseHexID(s string) string {
+	s = strings.TrimPrefix(strings.ToLower(strings.TrimSpace(s)), "0x")
+	if v, err := strconv.ParseUint(s, 16, 16); err == nil {
+		return fmt.Sprintf("%04x", v)
+	}
+	return ""
+}
@@ -0,0 +1,48 @@
+package main
+
+import "testing"
+
+func TestUSBIDs(t *testing.T) {
+	arduino := serialPortInfo{Path: "/dev/ttyACM0", VID: "2341", PID: "8036"}
+	ftdi := serialPortInfo{Path: "/dev/ttyUSB0", VID: "0403", PID: "6001"}
+
+	tests := []struct {
+		id      string
+		want    string // normalized, "" when not an ID
+		allowed []serialPortInfo
+	}{
+		{id: "2341", want: "2341", allowed: []serialPortInfo{arduino}},
+		{id: "0x2341", want: "2341", allowed: []serialPortInfo{arduino}},
+		{id: " 2341:* ", want: "2341", allowed: []serialPortInfo{arduino}},
+		{id: "2341:8036", want: "2341:8036", allowed: []serialPortInfo{arduino}},
+		{id: "2341:0x8037", want: "2341:8037"},
+		{id: "403:6001", want: "0403:6001", allowed: []serialPortInfo{ftdi}},
+		{id: "0403:6001", want: "0403:6001", allowed: []serialPortInfo{ftdi}},
+		{id: "1A86:7523", want: "1a86:7523"},
+		{id: "9025", want: "9025"}, // what an unquoted 0x2341 turns into, which the check turns down
+		{id: "arduino"},
+		{id: "2341:uno"},
+		{id: "12345"},
+	}
+
+	for _, test := range tests {
+		got, ok := normalizeUSBID(test.id)
+		if want := test.want; got != want || ok != (want != "") {
+			t.Errorf("normalizeUSBID(%q) = %q, %t, want %q", test.id, got, ok, want)
+			continue
+		}
+		if !ok {
+			continue
+		}
+
+		for _, port := range []serialPortInfo{arduino, ftdi} {
+			wantAllowed := false
+			for _, allowed := range test.allowed {
+				wantAllowed = wantAllowed || allowed == port
+			}
+			if allowed, _ := usbIDAllowed(port, []string{got}); allowed != wantAllowed {
+				t.Errorf("%q allows %s: %t, want %t", test.id, port.usbID(), allowed, wantAllowed)
+			}
+		}
+	}
+}
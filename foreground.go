@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	foregroundPollInterval = 500 * time.Millisecond
+	// deej.current faders wait for focus to stay put this long, so alt-tabbing through
+	// windows doesn't send the motors back and forth
+	currentWindowSettle = 300 * time.Millisecond
+)
+
+// foregroundSetter is implemented by audio backends that decide the foreground process
+// themselves, like the fake one does for tests
+type foregroundSetter interface {
+	// ForegroundProcess returns the process set as the foreground one, if there is one
+	ForegroundProcess() (string, bool)
+}
+
+// getCurrentProcessName returns the name of the foreground process: the one the audio
+// backend sets, if it does, or the one the platform reports
+func getCurrentProcessName() (string, error) {
+	if setter, ok := audio.(foregroundSetter); ok {
+		if processName, set := setter.ForegroundProcess(); set {
+			return processName, nil
+		}
+	}
+	return foregroundProcessName()
+}
+
+// watchForeground calls onChange with the name of the foreground process whenever
+// another one comes to the front. Changes are reported by the platform where it can,
+// and polled for every interval otherwise. Where the foreground window can't be read
+// at all, onChange is never called.
+func watchForeground(interval time.Duration, onChange func(processName string)) {
+	last := ""
+	report := func(processName string) {
+		if processName != "" && processName != last {
+			last = processName
+			onChange(processName)
+		}
+	}
+
+	if err := watchForegroundEvents(report); err != nil && verbose {
+		fmt.Printf("[Foreground] %v, polling instead\n", err)
+	}
+	for {
+		if processName, err := getCurrentProcessName(); err == nil {
+			report(processName)
+		}
+		time.Sleep(interval)
+	}
+}
+
+// currentWindowFollower moves the faders of deej.current sliders to the volume of the
+// app that came to the front, once focus has settled on it
+type currentWindowFollower struct {
+	conn  *serialConnection
+	mu    sync.Mutex
+	timer *time.Timer
+}
+
+func newCurrentWindowFollower(conn *serialConnection) *currentWindowFollower {
+	return &currentWindowFollower{conn: conn}
+}
+
+// focusChanged (re)starts the wait for focus to settle
+func (f *currentWindowFollower) focusChanged() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.timer != nil {
+		f.timer.Stop()
+	}
+	f.timer = time.AfterFunc(currentWindowSettle, f.follow)
+}
+
+func (f *currentWindowFollower) follow() {
+	if sliders.SinceActivity() < userActivityHold || f.conn.State() != StateConnected {
+		return
+	}
+	for sliderNum, targets := range currentConfig().activeSliderTargets() {
+		if containsString(targets, "deej.current") {
+			syncSliderVolume(f.conn, sliderNum)
+			syncSliderMute(f.conn, sliderNum, false)
+		}
+	}
+}
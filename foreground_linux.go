@@ -0,0 +1,36 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"errors"
+	"sync/atomic"
+)
+
+// foregroundSources report the active window, tried in order until one works. Each
+// blocks for as long as it reports changes.
+var foregroundSources = []func(onChange func(processName string)) error{
+	watchX11ActiveWindow,
+}
+
+// activeProcess is the foreground process as last reported by a source, which is the
+// only way deej learns it on Linux
+var activeProcess atomic.Value // string
+
+// watchForegroundEvents calls onChange whenever another window becomes the active
+// one, for as long as a foreground source works
+func watchForegroundEvents(onChange func(processName string)) error {
+	report := func(processName string) {
+		activeProcess.Store(processName)
+		onChange(processName)
+	}
+
+	err := errors.New("no foreground window source")
+	for _, source := range foregroundSources {
+		if err = source(report); err == nil {
+			return nil
+		}
+	}
+	return err
+}
@@ -0,0 +1,79 @@
+//go:build windows
+// +build windows
+
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	eventSystemForeground  = 0x0003
+	winEventOutOfContext   = 0x0000
+	winEventSkipOwnProcess = 0x0002
+)
+
+var (
+	procSetWinEventHook = user32.NewProc("SetWinEventHook")
+	procUnhookWinEvent  = user32.NewProc("UnhookWinEvent")
+	procGetMessageW     = user32.NewProc("GetMessageW")
+
+	// Callbacks can't be freed, so there is one, reporting to foregroundChanged
+	foregroundEventCallback = syscall.NewCallback(foregroundEvent)
+	foregroundChanged       func(processName string)
+)
+
+// winMsg is a MSG, which GetMessageW fills in
+type winMsg struct {
+	hwnd     uintptr
+	message  uint32
+	wParam   uintptr
+	lParam   uintptr
+	time     uint32
+	pt       struct{ x, y int32 }
+	lPrivate uint32
+}
+
+// watchForegroundEvents calls onChange whenever another window comes to the front,
+// as Windows reports it. The hook's events are delivered to the thread that set it,
+// while it waits for messages, so this doesn't return unless that fails.
+func watchForegroundEvents(onChange func(processName string)) error {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	foregroundChanged = onChange
+	hook, _, err := procSetWinEventHook.Call(
+		eventSystemForeground,
+		eventSystemForeground,
+		0,
+		foregroundEventCallback,
+		0, // all processes
+		0, // all threads
+		winEventOutOfContext|winEventSkipOwnProcess,
+	)
+	if hook == 0 {
+		return fmt.Errorf("failed to watch the foreground window: %w", err)
+	}
+	defer procUnhookWinEvent.Call(hook)
+
+	var msg winMsg
+	for {
+		ret, _, err := procGetMessageW.Call(uintptr(unsafe.Pointer(&msg)), 0, 0, 0)
+		switch int32(ret) {
+		case -1:
+			return fmt.Errorf("failed to wait for foreground window changes: %w", err)
+		case 0:
+			return nil // WM_QUIT
+		}
+	}
+}
+
+func foregroundEvent(hook, event, hwnd, idObject, idChild, eventThread, eventTime uintptr) uintptr {
+	if processName, err := windowProcessName(hwnd); err == nil && processName != "" {
+		foregroundChanged(processName)
+	}
+	return 0
+}
@@ -0,0 +1,314 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Default gesture timing, used for settings a button leaves out
+const (
+	defaultLongAfter    = 500 * time.Millisecond
+	defaultDoubleWithin = 300 * time.Millisecond
+	defaultHoldAfter    = 400 * time.Millisecond
+	defaultRepeatEvery  = 150 * time.Millisecond
+)
+
+// buttonGesture is what a button did, as far as its actions are concerned
+type buttonGesture int
+
+const (
+	gesturePress buttonGesture = iota
+	gestureLong
+	gestureDouble
+	gestureHold
+)
+
+func (g buttonGesture) String() string {
+	switch g {
+	case gesturePress:
+		return "press"
+	case gestureLong:
+		return "long press"
+	case gestureDouble:
+		return "double press"
+	case gestureHold:
+		return "hold"
+	}
+	return "unknown"
+}
+
+// gestureTiming holds the thresholds a button's gestures are told apart by
+type gestureTiming struct {
+	LongAfter    time.Duration // held at least this long is a long press
+	DoubleWithin time.Duration // pressed again within this after release is a double press
+	HoldAfter    time.Duration // held this long starts repeating the hold action...
+	RepeatEvery  time.Duration // ...this often, until released
+}
+
+// buttonBinding is everything a button does. Gestures without an action are not
+// waited for, so a button with only Press acts as soon as it goes down.
+type buttonBinding struct {
+	Press  buttonAction
+	Long   buttonAction
+	Double buttonAction
+	Hold   buttonAction
+	Timing gestureTiming
+}
+
+func (b *buttonBinding) action(gesture buttonGesture) buttonAction {
+	switch gesture {
+	case gesturePress:
+		return b.Press
+	case gestureLong:
+		return b.Long
+	case gestureDouble:
+		return b.Double
+	case gestureHold:
+		return b.Hold
+	}
+	return nil
+}
+
+// gestureDetector turns one button's presses and releases into gestures. It only
+// goes by the times it is given, so the same events always give the same gestures;
+// Expire has to be called once Deadline has passed.
+type gestureDetector struct {
+	binding *buttonBinding
+
+	down     bool
+	downAt   time.Time
+	handled  bool // this press already gave its gesture
+	holding  bool
+	nextHold time.Time
+
+	tapPending bool // a short press, waiting to see whether a second one follows
+	tapAt      time.Time
+}
+
+func newGestureDetector(binding *buttonBinding) *gestureDetector {
+	return &gestureDetector{binding: binding}
+}
+
+// Press handles the button going down at t
+func (d *gestureDetector) Press(t time.Time) []buttonGesture {
+	gestures := d.Expire(t)
+	b := d.binding
+
+	d.down = true
+	d.downAt = t
+	d.handled = false
+	d.holding = false
+
+	if d.tapPending {
+		d.tapPending = false
+		d.handled = true
+		return append(gestures, gestureDouble)
+	}
+
+	if b.Long == nil && b.Double == nil && b.Hold == nil {
+		d.handled = true
+		return append(gestures, gesturePress)
+	}
+	if b.Hold != nil {
+		d.nextHold = t.Add(b.Timing.HoldAfter)
+	}
+	return gestures
+}
+
+// Release handles the button coming back up at t
+func (d *gestureDetector) Release(t time.Time) []buttonGesture {
+	gestures := d.Expire(t)
+	b := d.binding
+
+	if !d.down {
+		return gestures
+	}
+	d.down = false
+	if d.handled {
+		return gestures
+	}
+	d.handled = true
+
+	switch {
+	case b.Long != nil && t.Sub(d.downAt) >= b.Timing.LongAfter:
+		return append(gestures, gestureLong)
+	case b.Double != nil:
+		d.tapPending = true
+		d.tapAt = t
+		return gestures
+	case b.Press != nil:
+		return append(gestures, gesturePress)
+	}
+	return gestures
+}
+
+// Input handles the button going down or up at t. Boards that don't report releases
+// only send presses, which count as released right away.
+func (d *gestureDetector) Input(pressed bool, releases bool, t time.Time) []buttonGesture {
+	if !pressed {
+		return d.Release(t)
+	}
+	gestures := d.Press(t)
+	if !releases {
+		gestures = append(gestures, d.Release(t)...)
+	}
+	return gestures
+}
+
+// Expire gives the gestures whose time has come by t: long presses and hold repeats of
+// a button that is still down, and short presses that turned out not to be doubles
+func (d *gestureDetector) Expire(t time.Time) []buttonGesture {
+	var gestures []buttonGesture
+	b := d.binding
+
+	if d.down && b.Hold != nil && (!d.handled || d.holding) && !d.nextHold.After(t) {
+		gestures = append(gestures, gestureHold)
+		d.handled = true
+		d.holding = true
+
+		// Repeats missed while the timer was late are skipped, not caught up on
+		d.nextHold = d.nextHold.Add(b.Timing.RepeatEvery)
+		if !d.nextHold.After(t) {
+			d.nextHold = t.Add(b.Timing.RepeatEvery)
+		}
+	}
+	if d.down && b.Long != nil && !d.handled && t.Sub(d.downAt) >= b.Timing.LongAfter {
+		gestures = append(gestures, gestureLong)
+		d.handled = true
+	}
+	if d.tapPending && t.Sub(d.tapAt) >= b.Timing.DoubleWithin {
+		d.tapPending = false
+		if b.Press != nil {
+			gestures = append(gestures, gesturePress)
+		}
+	}
+
+	return gestures
+}
+
+// Deadline returns when Expire has to be called next, if at all
+func (d *gestureDetector) Deadline() (time.Time, bool) {
+	var deadline time.Time
+	found := false
+	consider := func(t time.Time) {
+		if !found || t.Before(deadline) {
+			deadline, found = t, true
+		}
+	}
+
+	b := d.binding
+	if d.down && b.Hold != nil && (!d.handled || d.holding) {
+		consider(d.nextHold)
+	}
+	if d.down && b.Long != nil && !d.handled {
+		consider(d.downAt.Add(b.Timing.LongAfter))
+	}
+	if d.tapPending {
+		consider(d.tapAt.Add(b.Timing.DoubleWithin))
+	}
+
+	return deadline, found
+}
+
+// buttonInput runs the gesture detectors of all buttons and the actions they trigger
+type buttonInput struct {
+	mu        sync.Mutex
+	releases  bool // the board reports releases, not only presses
+	detectors map[int]*gestureDetector
+	timers    map[int]*time.Timer
+}
+
+var buttons = &buttonInput{
+	detectors: make(map[int]*gestureDetector),
+	timers:    make(map[int]*time.Timer),
+}
+
+// SetReportsReleases tells whether the board reports buttons going up. Boards that
+// don't are handled as if every press was released right away, so only press and
+// double press can be told apart.
+func (b *buttonInput) SetReportsReleases(releases bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.releases = releases
+}
+
+// Handle feeds a button going down or up at t into its detector
+func (b *buttonInput) Handle(buttonNum int, pressed bool, t time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	// Boards that send releases at all always send them
+	if !pressed {
+		b.releases = true
+	}
+
+	// Layer buttons only switch layers
+	if layer := currentConfig().layerForButton(buttonNum); layer != nil {
+		handleLayerButton(layer, pressed, b.releases)
+		return
+	}
+
+	d := b.detector(buttonNum)
+	if d == nil {
+		return
+	}
+
+	b.run(buttonNum, d, d.Input(pressed, b.releases, t))
+	b.schedule(buttonNum, d)
+}
+
+// detector returns the detector for a button's current binding; a binding changed
+// by a config reload or another layer starts over
+func (b *buttonInput) detector(buttonNum int) *gestureDetector {
+	binding, exists := currentConfig().activeButtonBinding(buttonNum)
+	if !exists {
+		delete(b.detectors, buttonNum)
+		return nil
+	}
+
+	d := b.detectors[buttonNum]
+	if d == nil || d.binding != binding {
+		d = newGestureDetector(binding)
+		b.detectors[buttonNum] = d
+	}
+	return d
+}
+
+func (b *buttonInput) expire(buttonNum int, d *gestureDetector) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.detectors[buttonNum] != d {
+		return
+	}
+	b.run(buttonNum, d, d.Expire(time.Now()))
+	b.schedule(buttonNum, d)
+}
+
+func (b *buttonInput) schedule(buttonNum int, d *gestureDetector) {
+	if timer, exists := b.timers[buttonNum]; exists {
+		timer.Stop()
+		delete(b.timers, buttonNum)
+	}
+
+	deadline, ok := d.Deadline()
+	if !ok {
+		return
+	}
+	b.timers[buttonNum] = time.AfterFunc(time.Until(deadline), func() { b.expire(buttonNum, d) })
+}
+
+func (b *buttonInput) run(buttonNum int, d *gestureDetector, gestures []buttonGesture) {
+	for _, gesture := range gestures {
+		action := d.binding.action(gesture)
+		if action == nil {
+			continue
+		}
+		if verbose {
+			fmt.Printf("[Button %d] %s\n", buttonNum, gesture)
+		}
+		go runButtonAction(buttonNum, action)
+	}
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// gestureStep is something happening to button 0, ms milliseconds into a test: it
+// going down or up, or its detector's timer firing
+type gestureStep struct {
+	ms    int
+	event string // "down", "up" or "timer"
+}
+
+func TestGestures(t *testing.T) {
+	tests := []struct {
+		name     string
+		binding  string // button 0's button_mapping entry
+		releases bool   // the board reports releases
+		steps    []gestureStep
+		want     []string // gestures, with the ms they happened at
+	}{
+		{
+			name:     "press without other gestures acts on the way down",
+			binding:  "F13",
+			releases: true,
+			steps:    []gestureStep{{0, "down"}, {50, "up"}},
+			want:     []string{"press@0"},
+		},
+		{
+			name:     "short press with a long gesture",
+			binding:  "{ press: F13, long: F14 }",
+			releases: true,
+			steps:    []gestureStep{{0, "down"}, {100, "up"}},
+			want:     []string{"press@100"},
+		},
+		{
+			name:     "long press while held",
+			binding:  "{ press: F13, long: F14 }",
+			releases: true,
+			steps:    []gestureStep{{0, "down"}, {500, "timer"}, {700, "up"}},
+			want:     []string{"long press@500"},
+		},
+		{
+			name:     "long press noticed on release when the timer is late",
+			binding:  "{ press: F13, long: F14 }",
+			releases: true,
+			steps:    []gestureStep{{0, "down"}, {650, "up"}},
+			want:     []string{"long press@650"},
+		},
+		{
+			name:     "double press",
+			binding:  "{ press: F13, double: F15 }",
+			releases: true,
+			steps:    []gestureStep{{0, "down"}, {50, "up"}, {200, "down"}, {250, "up"}},
+			want:     []string{"double press@200"},
+		},
+		{
+			name:     "single press waits for a second one",
+			binding:  "{ press: F13, double: F15 }",
+			releases: true,
+			steps:    []gestureStep{{0, "down"}, {50, "up"}, {350, "timer"}},
+			want:     []string{"press@350"},
+		},
+		{
+			name:     "second press too late is a press of its own",
+			binding:  "{ press: F13, double: F15 }",
+			releases: true,
+			steps:    []gestureStep{{0, "down"}, {50, "up"}, {400, "down"}, {450, "up"}, {750, "timer"}},
+			want:     []string{"press@400", "press@750"},
+		},
+		{
+			name:     "hold repeats until released",
+			binding:  "{ press: F13, hold: F16 }",
+			releases: true,
+			steps:    []gestureStep{{0, "down"}, {400, "timer"}, {550, "timer"}, {700, "timer"}, {720, "up"}},
+			want:     []string{"hold@400", "hold@550", "hold@700"},
+		},
+		{
+			name:     "hold skips repeats missed by a late timer",
+			binding:  "{ press: F13, hold: F16 }",
+			releases: true,
+			steps:    []gestureStep{{0, "down"}, {400, "timer"}, {1000, "timer"}, {1150, "timer"}, {1200, "up"}},
+			want:     []string{"hold@400", "hold@1000", "hold@1150"},
+		},
+		{
+			name:     "short press with a hold gesture",
+			binding:  "{ press: F13, hold: F16 }",
+			releases: true,
+			steps:    []gestureStep{{0, "down"}, {100, "up"}},
+			want:     []string{"press@100"},
+		},
+		{
+			name:     "timing overrides",
+			binding:  "{ press: F13, long: F14, long_after: 1s }",
+			releases: true,
+			steps:    []gestureStep{{0, "down"}, {700, "up"}, {2000, "down"}, {3000, "timer"}, {3100, "up"}},
+			want:     []string{"press@700", "long press@3000"},
+		},
+		{
+			name:     "double and hold overrides",
+			binding:  "{ double: F15, hold: F16, double_within: 100ms, hold_after: 1s, repeat_every: 50ms }",
+			releases: true,
+			steps: []gestureStep{
+				{0, "down"}, {50, "up"}, {200, "down"}, {250, "up"}, // too slow for a double
+				{1000, "down"}, {2000, "timer"}, {2050, "timer"}, {2060, "up"},
+			},
+			want: []string{"hold@2000", "hold@2050"},
+		},
+		{
+			name:     "board without releases: press",
+			binding:  "{ press: F13, long: F14 }",
+			releases: false,
+			steps:    []gestureStep{{0, "down"}, {2000, "timer"}},
+			want:     []string{"press@0"},
+		},
+		{
+			name:     "board without releases: double press",
+			binding:  "{ press: F13, double: F15 }",
+			releases: false,
+			steps:    []gestureStep{{0, "down"}, {200, "down"}, {1000, "timer"}},
+			want:     []string{"double press@200"},
+		},
+		{
+			name:     "board without releases: presses too far apart",
+			binding:  "{ press: F13, double: F15 }",
+			releases: false,
+			steps:    []gestureStep{{0, "down"}, {500, "down"}, {800, "timer"}},
+			want:     []string{"press@500", "press@800"},
+		},
+		{
+			name:     "board without releases: hold can't be told apart",
+			binding:  "{ press: F13, hold: F16 }",
+			releases: false,
+			steps:    []gestureStep{{0, "down"}, {400, "timer"}, {1000, "timer"}},
+			want:     []string{"press@0"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config := useConfig(t, "button_mapping:\n  0: "+test.binding+"\n")
+			binding, exists := config.ButtonMapping[0]
+			if !exists {
+				t.Fatal("button 0 isn't mapped")
+			}
+			d := newGestureDetector(binding)
+
+			start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+			var got []string
+			for _, step := range test.steps {
+				// The board's messages carry the time they were read at
+				msg := ArduinoMessage{Timestamp: start.Add(time.Duration(step.ms) * time.Millisecond)}
+
+				var gestures []buttonGesture
+				switch step.event {
+				case "down", "up":
+					msg.ButtonStates = map[int]bool{0: step.event == "down"}
+					gestures = d.Input(msg.ButtonStates[0], test.releases, msg.Timestamp)
+				case "timer":
+					// Timers only fire once the detector's deadline has come
+					if deadline, ok := d.Deadline(); !ok || deadline.After(msg.Timestamp) {
+						continue
+					}
+					gestures = d.Expire(msg.Timestamp)
+				}
+
+				for _, gesture := range gestures {
+					if binding.action(gesture) != nil {
+						got = append(got, fmt.Sprintf("%s@%d", gesture, step.ms))
+					}
+				}
+			}
+
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("gestures = [%s], want [%s]", strings.Join(got, ", "), strings.Join(test.want, ", "))
+			}
+		})
+	}
+}
+
+func TestGestureDeadline(t *testing.T) {
+	config := useConfig(t, "button_mapping:\n  0: { press: F13, long: F14, double: F15, long_after: 800ms, double_within: 200ms }\n")
+	d := newGestureDetector(config.ButtonMapping[0])
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	if _, ok := d.Deadline(); ok {
+		t.Error("idle button has a deadline")
+	}
+
+	d.Input(true, true, start)
+	if deadline, ok := d.Deadline(); !ok || !deadline.Equal(start.Add(800*time.Millisecond)) {
+		t.Errorf("deadline while down = %v, %t, want long_after", deadline.Sub(start), ok)
+	}
+
+	d.Input(false, true, start.Add(100*time.Millisecond))
+	if deadline, ok := d.Deadline(); !ok || !deadline.Equal(start.Add(300*time.Millisecond)) {
+		t.Errorf("deadline after a tap = %v, %t, want release + double_within", deadline.Sub(start), ok)
+	}
+}
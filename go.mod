@@ -3,12 +3,15 @@ module deej
 go 1.14
 
 require (
+	github.com/fsnotify/fsnotify v1.4.7
 	github.com/go-ole/go-ole v1.2.6
-	github.com/itchyny/volume-go v0.2.2
 	github.com/jacobsa/go-serial v0.0.0-20180131005756-15cf729a72d4
+	github.com/jfreymuth/pulse v0.1.1
 	github.com/micmonay/keybd_event v1.1.1
 	github.com/moutend/go-wca v0.3.0
 	github.com/nfnt/resize v0.0.0-20180221191011-83c6a9932646
 	github.com/spf13/viper v1.7.1
 	golang.org/x/sys v0.0.0-20220624220833-87e55d714810
+	golang.org/x/text v0.3.2
+	gopkg.in/yaml.v3 v3.0.1
 )
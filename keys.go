@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// keyCombo is a key press from button_mapping, with the modifiers held down for it
+type keyCombo struct {
+	Code  int
+	Ctrl  bool
+	Shift bool
+	Alt   bool
+	AltGr bool
+	Super bool
+	Name  string // as written in the config, for messages
+}
+
+// keyModifiers are the names accepted before the key in a combination like CTRL+SHIFT+M
+var keyModifiers = map[string]func(*keyCombo){
+	"CTRL":    func(k *keyCombo) { k.Ctrl = true },
+	"CONTROL": func(k *keyCombo) { k.Ctrl = true },
+	"SHIFT":   func(k *keyCombo) { k.Shift = true },
+	"ALT":     func(k *keyCombo) { k.Alt = true },
+	"ALTGR":   func(k *keyCombo) { k.AltGr = true },
+	"SUPER":   func(k *keyCombo) { k.Super = true },
+	"WIN":     func(k *keyCombo) { k.Super = true },
+	"META":    func(k *keyCombo) { k.Super = true },
+}
+
+func (k keyCombo) String() string {
+	if k.Name != "" {
+		return k.Name
+	}
+	return strconv.Itoa(k.Code)
+}
+
+// parseKeyCombo resolves a key name like F13 or VOLUME_MUTE, optionally preceded by
+// modifiers (CTRL+SHIFT+M), to this platform's key code
+func parseKeyCombo(s string) (keyCombo, error) {
+	combo := keyCombo{Name: strings.TrimSpace(s)}
+
+	parts := strings.Split(combo.Name, "+")
+	for i, part := range parts {
+		name := strings.TrimPrefix(strings.ToUpper(strings.TrimSpace(part)), "VK_")
+		if name == "" {
+			return keyCombo{}, fmt.Errorf("%q is missing a key", s)
+		}
+
+		if i < len(parts)-1 {
+			modifier, ok := keyModifiers[name]
+			if !ok {
+				return keyCombo{}, fmt.Errorf("%q is not a modifier (use CTRL, SHIFT, ALT, ALTGR or SUPER)", part)
+			}
+			modifier(&combo)
+			continue
+		}
+
+		if _, ok := keyModifiers[name]; ok {
+			return keyCombo{}, fmt.Errorf("%q has no key after the modifiers", s)
+		}
+		code, ok := keyCodes[name]
+		if !ok {
+			return keyCombo{}, fmt.Errorf("unknown key %q%s", part, suggest(name, keyNames()))
+		}
+		combo.Code = code
+	}
+
+	return combo, nil
+}
+
+// keyNames returns the key names known on this platform
+func keyNames() []string {
+	names := make([]string, 0, len(keyCodes))
+	for name := range keyCodes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
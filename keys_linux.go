@@ -0,0 +1,290 @@
+//go:build linux
+// +build linux
+
+package main
+
+import "github.com/micmonay/keybd_event"
+
+// keybd_event sends evdev key codes through uinput
+const evdevKeyMax = 0x2FF
+
+// keyCodes maps the key names usable in button_mapping to evdev codes. The Windows
+// names of media and browser keys are accepted too, so configs work on both.
+var keyCodes = map[string]int{
+	"0":                 keybd_event.VK_0,
+	"1":                 keybd_event.VK_1,
+	"102ND":             keybd_event.VK_102ND,
+	"2":                 keybd_event.VK_2,
+	"3":                 keybd_event.VK_3,
+	"4":                 keybd_event.VK_4,
+	"5":                 keybd_event.VK_5,
+	"6":                 keybd_event.VK_6,
+	"7":                 keybd_event.VK_7,
+	"8":                 keybd_event.VK_8,
+	"9":                 keybd_event.VK_9,
+	"A":                 keybd_event.VK_A,
+	"AGAIN":             keybd_event.VK_AGAIN,
+	"ALTERASE":          keybd_event.VK_ALTERASE,
+	"APOSTROPHE":        keybd_event.VK_APOSTROPHE,
+	"B":                 keybd_event.VK_B,
+	"BACK":              keybd_event.VK_BACK,
+	"BACKSLASH":         keybd_event.VK_BACKSLASH,
+	"BACKSPACE":         keybd_event.VK_BACKSPACE,
+	"BASSBOOST":         keybd_event.VK_BASSBOOST,
+	"BATTERY":           keybd_event.VK_BATTERY,
+	"BLUETOOTH":         keybd_event.VK_BLUETOOTH,
+	"BOOKMARKS":         keybd_event.VK_BOOKMARKS,
+	"BRIGHTNESSDOWN":    keybd_event.VK_BRIGHTNESSDOWN,
+	"BRIGHTNESSUP":      keybd_event.VK_BRIGHTNESSUP,
+	"BRIGHTNESS_AUTO":   keybd_event.VK_BRIGHTNESS_AUTO,
+	"BRIGHTNESS_CYCLE":  keybd_event.VK_BRIGHTNESS_CYCLE,
+	"BRIGHTNESS_ZERO":   keybd_event.VK_BRIGHTNESS_ZERO,
+	"BROWSER_BACK":      keybd_event.VK_BACK,
+	"BROWSER_FAVORITES": keybd_event.VK_BOOKMARKS,
+	"BROWSER_FORWARD":   keybd_event.VK_FORWARD,
+	"BROWSER_HOME":      keybd_event.VK_HOMEPAGE,
+	"BROWSER_REFRESH":   keybd_event.VK_REFRESH,
+	"BROWSER_SEARCH":    keybd_event.VK_SEARCH,
+	"BROWSER_STOP":      keybd_event.VK_STOP,
+	"C":                 keybd_event.VK_C,
+	"CALC":              keybd_event.VK_CALC,
+	"CAMERA":            keybd_event.VK_CAMERA,
+	"CANCEL":            keybd_event.VK_CANCEL,
+	"CAPITAL":           keybd_event.VK_CAPSLOCK,
+	"CAPSLOCK":          keybd_event.VK_CAPSLOCK,
+	"CHAT":              keybd_event.VK_CHAT,
+	"CLOSE":             keybd_event.VK_CLOSE,
+	"CLOSECD":           keybd_event.VK_CLOSECD,
+	"COFFEE":            keybd_event.VK_COFFEE,
+	"COMMA":             keybd_event.VK_COMMA,
+	"COMPOSE":           keybd_event.VK_COMPOSE,
+	"COMPUTER":          keybd_event.VK_COMPUTER,
+	"CONFIG":            keybd_event.VK_CONFIG,
+	"CONNECT":           keybd_event.VK_CONNECT,
+	"COPY":              keybd_event.VK_COPY,
+	"CUT":               keybd_event.VK_CUT,
+	"CYCLEWINDOWS":      keybd_event.VK_CYCLEWINDOWS,
+	"D":                 keybd_event.VK_D,
+	"DASHBOARD":         keybd_event.VK_DASHBOARD,
+	"DELETE":            keybd_event.VK_DELETE,
+	"DELETEFILE":        keybd_event.VK_DELETEFILE,
+	"DIRECTION":         keybd_event.VK_DIRECTION,
+	"DISPLAY_OFF":       keybd_event.VK_DISPLAY_OFF,
+	"DOCUMENTS":         keybd_event.VK_DOCUMENTS,
+	"DOT":               keybd_event.VK_DOT,
+	"DOWN":              keybd_event.VK_DOWN,
+	"E":                 keybd_event.VK_E,
+	"EDIT":              keybd_event.VK_EDIT,
+	"EJECTCD":           keybd_event.VK_EJECTCD,
+	"EJECTCLOSECD":      keybd_event.VK_EJECTCLOSECD,
+	"EMAIL":             keybd_event.VK_EMAIL,
+	"END":               keybd_event.VK_END,
+	"ENTER":             keybd_event.VK_ENTER,
+	"EQUAL":             keybd_event.VK_EQUAL,
+	"ESC":               keybd_event.VK_ESC,
+	"EXIT":              keybd_event.VK_EXIT,
+	"F":                 keybd_event.VK_F,
+	"F1":                keybd_event.VK_F1,
+	"F10":               keybd_event.VK_F10,
+	"F11":               keybd_event.VK_F11,
+	"F12":               keybd_event.VK_F12,
+	"F13":               keybd_event.VK_F13,
+	"F14":               keybd_event.VK_F14,
+	"F15":               keybd_event.VK_F15,
+	"F16":               keybd_event.VK_F16,
+	"F17":               keybd_event.VK_F17,
+	"F18":               keybd_event.VK_F18,
+	"F19":               keybd_event.VK_F19,
+	"F2":                keybd_event.VK_F2,
+	"F20":               keybd_event.VK_F20,
+	"F21":               keybd_event.VK_F21,
+	"F22":               keybd_event.VK_F22,
+	"F23":               keybd_event.VK_F23,
+	"F24":               keybd_event.VK_F24,
+	"F3":                keybd_event.VK_F3,
+	"F4":                keybd_event.VK_F4,
+	"F5":                keybd_event.VK_F5,
+	"F6":                keybd_event.VK_F6,
+	"F7":                keybd_event.VK_F7,
+	"F8":                keybd_event.VK_F8,
+	"F9":                keybd_event.VK_F9,
+	"FASTFORWARD":       keybd_event.VK_FASTFORWARD,
+	"FILE":              keybd_event.VK_FILE,
+	"FINANCE":           keybd_event.VK_FINANCE,
+	"FIND":              keybd_event.VK_FIND,
+	"FORWARD":           keybd_event.VK_FORWARD,
+	"FORWARDMAIL":       keybd_event.VK_FORWARDMAIL,
+	"FRONT":             keybd_event.VK_FRONT,
+	"G":                 keybd_event.VK_G,
+	"GRAVE":             keybd_event.VK_GRAVE,
+	"H":                 keybd_event.VK_H,
+	"HANGEUL":           keybd_event.VK_HANGEUL,
+	"HANGUEL":           keybd_event.VK_HANGUEL,
+	"HANJA":             keybd_event.VK_HANJA,
+	"HELP":              keybd_event.VK_HELP,
+	"HENKAN":            keybd_event.VK_HENKAN,
+	"HIRAGANA":          keybd_event.VK_HIRAGANA,
+	"HOME":              keybd_event.VK_HOME,
+	"HOMEPAGE":          keybd_event.VK_HOMEPAGE,
+	"HP":                keybd_event.VK_HP,
+	"I":                 keybd_event.VK_I,
+	"INSERT":            keybd_event.VK_INSERT,
+	"ISO":               keybd_event.VK_ISO,
+	"J":                 keybd_event.VK_J,
+	"K":                 keybd_event.VK_K,
+	"KATAKANA":          keybd_event.VK_KATAKANA,
+	"KATAKANAHIRAGANA":  keybd_event.VK_KATAKANAHIRAGANA,
+	"KBDILLUMDOWN":      keybd_event.VK_KBDILLUMDOWN,
+	"KBDILLUMTOGGLE":    keybd_event.VK_KBDILLUMTOGGLE,
+	"KBDILLUMUP":        keybd_event.VK_KBDILLUMUP,
+	"KP0":               keybd_event.VK_KP0,
+	"KP1":               keybd_event.VK_KP1,
+	"KP2":               keybd_event.VK_KP2,
+	"KP3":               keybd_event.VK_KP3,
+	"KP4":               keybd_event.VK_KP4,
+	"KP5":               keybd_event.VK_KP5,
+	"KP6":               keybd_event.VK_KP6,
+	"KP7":               keybd_event.VK_KP7,
+	"KP8":               keybd_event.VK_KP8,
+	"KP9":               keybd_event.VK_KP9,
+	"KPASTERISK":        keybd_event.VK_KPASTERISK,
+	"KPCOMMA":           keybd_event.VK_KPCOMMA,
+	"KPDOT":             keybd_event.VK_KPDOT,
+	"KPENTER":           keybd_event.VK_KPENTER,
+	"KPEQUAL":           keybd_event.VK_KPEQUAL,
+	"KPJPCOMMA":         keybd_event.VK_KPJPCOMMA,
+	"KPLEFTPAREN":       keybd_event.VK_KPLEFTPAREN,
+	"KPMINUS":           keybd_event.VK_KPMINUS,
+	"KPPLUS":            keybd_event.VK_KPPLUS,
+	"KPPLUSMINUS":       keybd_event.VK_KPPLUSMINUS,
+	"KPRIGHTPAREN":      keybd_event.VK_KPRIGHTPAREN,
+	"KPSLASH":           keybd_event.VK_KPSLASH,
+	"L":                 keybd_event.VK_L,
+	"LAUNCH_MAIL":       keybd_event.VK_MAIL,
+	"LEFT":              keybd_event.VK_LEFT,
+	"LEFTBRACE":         keybd_event.VK_LEFTBRACE,
+	"LEFTMETA":          keybd_event.VK_LEFTMETA,
+	"LINEFEED":          keybd_event.VK_LINEFEED,
+	"M":                 keybd_event.VK_M,
+	"MACRO":             keybd_event.VK_MACRO,
+	"MAIL":              keybd_event.VK_MAIL,
+	"MEDIA":             keybd_event.VK_MEDIA,
+	"MEDIA_NEXT_TRACK":  keybd_event.VK_NEXTSONG,
+	"MEDIA_PLAY_PAUSE":  keybd_event.VK_PLAYPAUSE,
+	"MEDIA_PREV_TRACK":  keybd_event.VK_PREVIOUSSONG,
+	"MEDIA_STOP":        keybd_event.VK_STOPCD,
+	"MENU":              keybd_event.VK_MENU,
+	"MICMUTE":           keybd_event.VK_MICMUTE,
+	"MINUS":             keybd_event.VK_MINUS,
+	"MOVE":              keybd_event.VK_MOVE,
+	"MSDOS":             keybd_event.VK_MSDOS,
+	"MUHENKAN":          keybd_event.VK_MUHENKAN,
+	"MUTE":              keybd_event.VK_MUTE,
+	"N":                 keybd_event.VK_N,
+	"NEW":               keybd_event.VK_NEW,
+	"NEXTSONG":          keybd_event.VK_NEXTSONG,
+	"NUMLOCK":           keybd_event.VK_NUMLOCK,
+	"O":                 keybd_event.VK_O,
+	"OPEN":              keybd_event.VK_OPEN,
+	"P":                 keybd_event.VK_P,
+	"PAGEDOWN":          keybd_event.VK_PAGEDOWN,
+	"PAGEUP":            keybd_event.VK_PAGEUP,
+	"PASTE":             keybd_event.VK_PASTE,
+	"PAUSE":             keybd_event.VK_PAUSE,
+	"PAUSECD":           keybd_event.VK_PAUSECD,
+	"PHONE":             keybd_event.VK_PHONE,
+	"PLAY":              keybd_event.VK_PLAY,
+	"PLAYCD":            keybd_event.VK_PLAYCD,
+	"PLAYPAUSE":         keybd_event.VK_PLAYPAUSE,
+	"POWER":             keybd_event.VK_POWER,
+	"PREVIOUSSONG":      keybd_event.VK_PREVIOUSSONG,
+	"PRINT":             keybd_event.VK_PRINT,
+	"PROG1":             keybd_event.VK_PROG1,
+	"PROG2":             keybd_event.VK_PROG2,
+	"PROG3":             keybd_event.VK_PROG3,
+	"PROG4":             keybd_event.VK_PROG4,
+	"PROPS":             keybd_event.VK_PROPS,
+	"Q":                 keybd_event.VK_Q,
+	"QUESTION":          keybd_event.VK_QUESTION,
+	"R":                 keybd_event.VK_R,
+	"RECORD":            keybd_event.VK_RECORD,
+	"REDO":              keybd_event.VK_REDO,
+	"REFRESH":           keybd_event.VK_REFRESH,
+	"REPLY":             keybd_event.VK_REPLY,
+	"RESERVED":          keybd_event.VK_RESERVED,
+	"REWIND":            keybd_event.VK_REWIND,
+	"RFKILL":            keybd_event.VK_RFKILL,
+	"RIGHT":             keybd_event.VK_RIGHT,
+	"RIGHTBRACE":        keybd_event.VK_RIGHTBRACE,
+	"RIGHTMETA":         keybd_event.VK_RIGHTMETA,
+	"RO":                keybd_event.VK_RO,
+	"ROTATE_DISPLAY":    keybd_event.VK_ROTATE_DISPLAY,
+	"S":                 keybd_event.VK_S,
+	"SAVE":              keybd_event.VK_SAVE,
+	"SCALE":             keybd_event.VK_SCALE,
+	"SCREENLOCK":        keybd_event.VK_SCREENLOCK,
+	"SCROLL":            keybd_event.VK_SCROLLLOCK,
+	"SCROLLDOWN":        keybd_event.VK_SCROLLDOWN,
+	"SCROLLLOCK":        keybd_event.VK_SCROLLLOCK,
+	"SCROLLUP":          keybd_event.VK_SCROLLUP,
+	"SEARCH":            keybd_event.VK_SEARCH,
+	"SEMICOLON":         keybd_event.VK_SEMICOLON,
+	"SEND":              keybd_event.VK_SEND,
+	"SENDFILE":          keybd_event.VK_SENDFILE,
+	"SETUP":             keybd_event.VK_SETUP,
+	"SHOP":              keybd_event.VK_SHOP,
+	"SLASH":             keybd_event.VK_SLASH,
+	"SLEEP":             keybd_event.VK_SLEEP,
+	"SNAPSHOT":          keybd_event.VK_SYSRQ,
+	"SOUND":             keybd_event.VK_SOUND,
+	"SP1":               keybd_event.VK_SP1,
+	"SP10":              keybd_event.VK_SP10,
+	"SP11":              keybd_event.VK_SP11,
+	"SP12":              keybd_event.VK_SP12,
+	"SP2":               keybd_event.VK_SP2,
+	"SP3":               keybd_event.VK_SP3,
+	"SP4":               keybd_event.VK_SP4,
+	"SP5":               keybd_event.VK_SP5,
+	"SP6":               keybd_event.VK_SP6,
+	"SP7":               keybd_event.VK_SP7,
+	"SP8":               keybd_event.VK_SP8,
+	"SP9":               keybd_event.VK_SP9,
+	"SPACE":             keybd_event.VK_SPACE,
+	"SPORT":             keybd_event.VK_SPORT,
+	"STOP":              keybd_event.VK_STOP,
+	"STOPCD":            keybd_event.VK_STOPCD,
+	"SUSPEND":           keybd_event.VK_SUSPEND,
+	"SWITCHVIDEOMODE":   keybd_event.VK_SWITCHVIDEOMODE,
+	"SYSRQ":             keybd_event.VK_SYSRQ,
+	"T":                 keybd_event.VK_T,
+	"TAB":               keybd_event.VK_TAB,
+	"U":                 keybd_event.VK_U,
+	"UNDO":              keybd_event.VK_UNDO,
+	"UNKNOWN":           keybd_event.VK_UNKNOWN,
+	"UP":                keybd_event.VK_UP,
+	"UWB":               keybd_event.VK_UWB,
+	"V":                 keybd_event.VK_V,
+	"VIDEO_NEXT":        keybd_event.VK_VIDEO_NEXT,
+	"VIDEO_PREV":        keybd_event.VK_VIDEO_PREV,
+	"VOLUMEDOWN":        keybd_event.VK_VOLUMEDOWN,
+	"VOLUMEUP":          keybd_event.VK_VOLUMEUP,
+	"VOLUME_DOWN":       keybd_event.VK_VOLUMEDOWN,
+	"VOLUME_MUTE":       keybd_event.VK_MUTE,
+	"VOLUME_UP":         keybd_event.VK_VOLUMEUP,
+	"W":                 keybd_event.VK_W,
+	"WAKEUP":            keybd_event.VK_WAKEUP,
+	"WIMAX":             keybd_event.VK_WIMAX,
+	"WLAN":              keybd_event.VK_WLAN,
+	"WWAN":              keybd_event.VK_WWAN,
+	"WWW":               keybd_event.VK_WWW,
+	"X":                 keybd_event.VK_X,
+	"XFER":              keybd_event.VK_XFER,
+	"Y":                 keybd_event.VK_Y,
+	"YEN":               keybd_event.VK_YEN,
+	"Z":                 keybd_event.VK_Z,
+	"ZENKAKUHANKAKU":    keybd_event.VK_ZENKAKUHANKAKU,
+}
+
+func validKeyCode(code int) bool {
+	return code > 0 && code <= evdevKeyMax
+}
@@ -0,0 +1,11 @@
+//go:build !windows && !linux
+// +build !windows,!linux
+
+package main
+
+// Key names aren't known on this platform, so button_mapping takes numeric codes only
+var keyCodes = map[string]int{}
+
+func validKeyCode(code int) bool {
+	return code >= 0
+}
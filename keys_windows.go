@@ -0,0 +1,204 @@
+//go:build windows
+// +build windows
+
+package main
+
+import "github.com/micmonay/keybd_event"
+
+// keybd_event takes scan codes below 0xFFF and virtual key codes offset by 0xFFF
+const virtualKeyOffset = 0xFFF
+
+// keyCodes maps the key names usable in button_mapping to keybd_event's codes
+var keyCodes = map[string]int{
+	"0":                   keybd_event.VK_0,
+	"1":                   keybd_event.VK_1,
+	"2":                   keybd_event.VK_2,
+	"3":                   keybd_event.VK_3,
+	"4":                   keybd_event.VK_4,
+	"5":                   keybd_event.VK_5,
+	"6":                   keybd_event.VK_6,
+	"7":                   keybd_event.VK_7,
+	"8":                   keybd_event.VK_8,
+	"9":                   keybd_event.VK_9,
+	"A":                   keybd_event.VK_A,
+	"ACCEPT":              keybd_event.VK_ACCEPT,
+	"APOSTROPHE":          keybd_event.VK_APOSTROPHE,
+	"ATTN":                keybd_event.VK_ATTN,
+	"B":                   keybd_event.VK_B,
+	"BACK":                keybd_event.VK_BACK,
+	"BACKSLASH":           keybd_event.VK_BACKSLASH,
+	"BACKSPACE":           keybd_event.VK_BACKSPACE,
+	"BROWSER_BACK":        keybd_event.VK_BROWSER_BACK,
+	"BROWSER_FAVORITES":   keybd_event.VK_BROWSER_FAVORITES,
+	"BROWSER_FORWARD":     keybd_event.VK_BROWSER_FORWARD,
+	"BROWSER_HOME":        keybd_event.VK_BROWSER_HOME,
+	"BROWSER_REFRESH":     keybd_event.VK_BROWSER_REFRESH,
+	"BROWSER_SEARCH":      keybd_event.VK_BROWSER_SEARCH,
+	"BROWSER_STOP":        keybd_event.VK_BROWSER_STOP,
+	"C":                   keybd_event.VK_C,
+	"CANCEL":              keybd_event.VK_CANCEL,
+	"CAPITAL":             keybd_event.VK_CAPITAL,
+	"CAPSLOCK":            keybd_event.VK_CAPSLOCK,
+	"CLEAR":               keybd_event.VK_CLEAR,
+	"COMMA":               keybd_event.VK_COMMA,
+	"CONVERT":             keybd_event.VK_CONVERT,
+	"CRSEL":               keybd_event.VK_CRSEL,
+	"D":                   keybd_event.VK_D,
+	"DELETE":              keybd_event.VK_DELETE,
+	"DOT":                 keybd_event.VK_DOT,
+	"DOWN":                keybd_event.VK_DOWN,
+	"E":                   keybd_event.VK_E,
+	"END":                 keybd_event.VK_END,
+	"ENTER":               keybd_event.VK_ENTER,
+	"EQUAL":               keybd_event.VK_EQUAL,
+	"EREOF":               keybd_event.VK_EREOF,
+	"ESC":                 keybd_event.VK_ESC,
+	"EXECUTE":             keybd_event.VK_EXECUTE,
+	"EXSEL":               keybd_event.VK_EXSEL,
+	"F":                   keybd_event.VK_F,
+	"F1":                  keybd_event.VK_F1,
+	"F10":                 keybd_event.VK_F10,
+	"F11":                 keybd_event.VK_F11,
+	"F12":                 keybd_event.VK_F12,
+	"F13":                 keybd_event.VK_F13,
+	"F14":                 keybd_event.VK_F14,
+	"F15":                 keybd_event.VK_F15,
+	"F16":                 keybd_event.VK_F16,
+	"F17":                 keybd_event.VK_F17,
+	"F18":                 keybd_event.VK_F18,
+	"F19":                 keybd_event.VK_F19,
+	"F2":                  keybd_event.VK_F2,
+	"F20":                 keybd_event.VK_F20,
+	"F21":                 keybd_event.VK_F21,
+	"F22":                 keybd_event.VK_F22,
+	"F23":                 keybd_event.VK_F23,
+	"F24":                 keybd_event.VK_F24,
+	"F3":                  keybd_event.VK_F3,
+	"F4":                  keybd_event.VK_F4,
+	"F5":                  keybd_event.VK_F5,
+	"F6":                  keybd_event.VK_F6,
+	"F7":                  keybd_event.VK_F7,
+	"F8":                  keybd_event.VK_F8,
+	"F9":                  keybd_event.VK_F9,
+	"FINAL":               keybd_event.VK_FINAL,
+	"G":                   keybd_event.VK_G,
+	"GRAVE":               keybd_event.VK_GRAVE,
+	"H":                   keybd_event.VK_H,
+	"HANGUEL":             keybd_event.VK_HANGUEL,
+	"HANGUL":              keybd_event.VK_HANGUL,
+	"HANJA":               keybd_event.VK_HANJA,
+	"HELP":                keybd_event.VK_HELP,
+	"HOME":                keybd_event.VK_HOME,
+	"I":                   keybd_event.VK_I,
+	"INSERT":              keybd_event.VK_INSERT,
+	"J":                   keybd_event.VK_J,
+	"JUNJA":               keybd_event.VK_JUNJA,
+	"K":                   keybd_event.VK_K,
+	"KANA":                keybd_event.VK_KANA,
+	"KANJI":               keybd_event.VK_KANJI,
+	"KP0":                 keybd_event.VK_KP0,
+	"KP1":                 keybd_event.VK_KP1,
+	"KP2":                 keybd_event.VK_KP2,
+	"KP3":                 keybd_event.VK_KP3,
+	"KP4":                 keybd_event.VK_KP4,
+	"KP5":                 keybd_event.VK_KP5,
+	"KP6":                 keybd_event.VK_KP6,
+	"KP7":                 keybd_event.VK_KP7,
+	"KP8":                 keybd_event.VK_KP8,
+	"KP9":                 keybd_event.VK_KP9,
+	"KPASTERISK":          keybd_event.VK_KPASTERISK,
+	"KPDOT":               keybd_event.VK_KPDOT,
+	"KPMINUS":             keybd_event.VK_KPMINUS,
+	"KPPLUS":              keybd_event.VK_KPPLUS,
+	"L":                   keybd_event.VK_L,
+	"LAUNCH_APP1":         keybd_event.VK_LAUNCH_APP1,
+	"LAUNCH_APP2":         keybd_event.VK_LAUNCH_APP2,
+	"LAUNCH_MAIL":         keybd_event.VK_LAUNCH_MAIL,
+	"LAUNCH_MEDIA_SELECT": keybd_event.VK_LAUNCH_MEDIA_SELECT,
+	"LBUTTON":             keybd_event.VK_LBUTTON,
+	"LEFT":                keybd_event.VK_LEFT,
+	"LEFTBRACE":           keybd_event.VK_LEFTBRACE,
+	"LMENU":               keybd_event.VK_LMENU,
+	"M":                   keybd_event.VK_M,
+	"MBUTTON":             keybd_event.VK_MBUTTON,
+	"MEDIA_NEXT_TRACK":    keybd_event.VK_MEDIA_NEXT_TRACK,
+	"MEDIA_PLAY_PAUSE":    keybd_event.VK_MEDIA_PLAY_PAUSE,
+	"MEDIA_PREV_TRACK":    keybd_event.VK_MEDIA_PREV_TRACK,
+	"MEDIA_STOP":          keybd_event.VK_MEDIA_STOP,
+	"MINUS":               keybd_event.VK_MINUS,
+	"MODECHANGE":          keybd_event.VK_MODECHANGE,
+	"N":                   keybd_event.VK_N,
+	"NONAME":              keybd_event.VK_NONAME,
+	"NONCONVERT":          keybd_event.VK_NONCONVERT,
+	"NUMLOCK":             keybd_event.VK_NUMLOCK,
+	"O":                   keybd_event.VK_O,
+	"OEM_1":               keybd_event.VK_OEM_1,
+	"OEM_102":             keybd_event.VK_OEM_102,
+	"OEM_2":               keybd_event.VK_OEM_2,
+	"OEM_3":               keybd_event.VK_OEM_3,
+	"OEM_4":               keybd_event.VK_OEM_4,
+	"OEM_5":               keybd_event.VK_OEM_5,
+	"OEM_6":               keybd_event.VK_OEM_6,
+	"OEM_7":               keybd_event.VK_OEM_7,
+	"OEM_8":               keybd_event.VK_OEM_8,
+	"OEM_CLEAR":           keybd_event.VK_OEM_CLEAR,
+	"OEM_COMMA":           keybd_event.VK_OEM_COMMA,
+	"OEM_MINUS":           keybd_event.VK_OEM_MINUS,
+	"OEM_PERIOD":          keybd_event.VK_OEM_PERIOD,
+	"OEM_PLUS":            keybd_event.VK_OEM_PLUS,
+	"P":                   keybd_event.VK_P,
+	"PA1":                 keybd_event.VK_PA1,
+	"PACKET":              keybd_event.VK_PACKET,
+	"PAGEDOWN":            keybd_event.VK_PAGEDOWN,
+	"PAGEUP":              keybd_event.VK_PAGEUP,
+	"PAUSE":               keybd_event.VK_PAUSE,
+	"PLAY":                keybd_event.VK_PLAY,
+	"PRINT":               keybd_event.VK_PRINT,
+	"PROCESSKEY":          keybd_event.VK_PROCESSKEY,
+	"Q":                   keybd_event.VK_Q,
+	"R":                   keybd_event.VK_R,
+	"RBUTTON":             keybd_event.VK_RBUTTON,
+	"RESERVED":            keybd_event.VK_RESERVED,
+	"RIGHT":               keybd_event.VK_RIGHT,
+	"RIGHTBRACE":          keybd_event.VK_RIGHTBRACE,
+	"RMENU":               keybd_event.VK_RMENU,
+	"S":                   keybd_event.VK_S,
+	"SCROLL":              keybd_event.VK_SCROLL,
+	"SCROLLLOCK":          keybd_event.VK_SCROLLLOCK,
+	"SELECT":              keybd_event.VK_SELECT,
+	"SEMICOLON":           keybd_event.VK_SEMICOLON,
+	"SLASH":               keybd_event.VK_SLASH,
+	"SNAPSHOT":            keybd_event.VK_SNAPSHOT,
+	"SP1":                 keybd_event.VK_SP1,
+	"SP10":                keybd_event.VK_SP10,
+	"SP11":                keybd_event.VK_SP11,
+	"SP12":                keybd_event.VK_SP12,
+	"SP2":                 keybd_event.VK_SP2,
+	"SP3":                 keybd_event.VK_SP3,
+	"SP4":                 keybd_event.VK_SP4,
+	"SP5":                 keybd_event.VK_SP5,
+	"SP6":                 keybd_event.VK_SP6,
+	"SP7":                 keybd_event.VK_SP7,
+	"SP8":                 keybd_event.VK_SP8,
+	"SP9":                 keybd_event.VK_SP9,
+	"SPACE":               keybd_event.VK_SPACE,
+	"T":                   keybd_event.VK_T,
+	"TAB":                 keybd_event.VK_TAB,
+	"U":                   keybd_event.VK_U,
+	"UP":                  keybd_event.VK_UP,
+	"V":                   keybd_event.VK_V,
+	"VOLUME_DOWN":         keybd_event.VK_VOLUME_DOWN,
+	"VOLUME_MUTE":         keybd_event.VK_VOLUME_MUTE,
+	"VOLUME_UP":           keybd_event.VK_VOLUME_UP,
+	"W":                   keybd_event.VK_W,
+	"X":                   keybd_event.VK_X,
+	"XBUTTON1":            keybd_event.VK_XBUTTON1,
+	"XBUTTON2":            keybd_event.VK_XBUTTON2,
+	"Y":                   keybd_event.VK_Y,
+	"Z":                   keybd_event.VK_Z,
+	"ZOOM":                keybd_event.VK_ZOOM,
+}
+
+func validKeyCode(code int) bool {
+	return code > 0 && code != virtualKeyOffset && code < virtualKeyOffset+0xFF
+}
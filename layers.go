@@ -0,0 +1,166 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sync"
+)
+
+// configLayer is an alternate mapping for sliders and buttons, in effect while its
+// button is held, or from one press of its button to the next. Sliders and buttons
+// the layer doesn't map keep doing what the base mapping says.
+type configLayer struct {
+	Name          string
+	Number        int // reported to the board; layers are numbered from 1 in config order
+	Button        int
+	Toggle        bool
+	SliderTargets map[int][]string
+	ButtonMapping map[int]*buttonBinding
+}
+
+// layerState is which layer is active. Layers are tracked by name, so the active one
+// survives config reloads that keep it.
+type layerState struct {
+	mu        sync.Mutex
+	active    string // "" while only the base mapping is in effect
+	listeners []func(*configLayer)
+}
+
+var layers = &layerState{}
+
+// Active returns the name of the active layer, or "" for the base mapping
+func (l *layerState) Active() string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.active
+}
+
+// Set activates the named layer, or the base mapping for "", and tells the listeners
+// when that changes anything
+func (l *layerState) Set(name string) {
+	l.mu.Lock()
+	if l.active == name {
+		l.mu.Unlock()
+		return
+	}
+	l.active = name
+	listeners := append([]func(*configLayer){}, l.listeners...)
+	l.mu.Unlock()
+
+	layer := currentConfig().layer(name)
+	if verbose {
+		fmt.Printf("[Layer] %s\n", layerName(layer))
+	}
+	for _, listener := range listeners {
+		listener(layer)
+	}
+}
+
+// forgetMissing goes back to the base mapping, without telling the listeners, when
+// a reloaded config no longer has the active layer
+func (l *layerState) forgetMissing(config *deejConfig) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.active != "" && config.layer(l.active) == nil {
+		log.Printf("[Layer] Layer %s was removed, back to the base mapping", l.active)
+		l.active = ""
+	}
+}
+
+// OnChange registers a callback for every layer change; it gets nil for the base mapping
+func (l *layerState) OnChange(listener func(*configLayer)) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.listeners = append(l.listeners, listener)
+}
+
+// handleLayerButton switches layers when a layer's button goes down or up. Hold layers
+// act like toggles on boards that only report presses.
+func handleLayerButton(layer *configLayer, pressed bool, releases bool) {
+	active := layers.Active()
+
+	switch {
+	case pressed && active == layer.Name && (layer.Toggle || !releases):
+		layers.Set("")
+	case pressed:
+		layers.Set(layer.Name)
+	case !layer.Toggle && active == layer.Name:
+		layers.Set("")
+	}
+}
+
+func layerName(layer *configLayer) string {
+	if layer == nil {
+		return "base"
+	}
+	return layer.Name
+}
+
+// sendActiveLayer tells the board which layer is active, so its display can show it
+func sendActiveLayer(conn *serialConnection) {
+	layer := currentConfig().layer(layers.Active())
+
+	number, name := 0, ""
+	if layer != nil {
+		number, name = layer.Number, layer.Name
+	}
+	if err := conn.SendLayer(number, name); err != nil && err != errNotConnected {
+		log.Printf("Error sending layer: %v", err)
+	}
+}
+
+// layer returns the named layer, or nil if there is none
+func (c *deejConfig) layer(name string) *configLayer {
+	if name == "" {
+		return nil
+	}
+	for _, layer := range c.Layers {
+		if layer.Name == name {
+			return layer
+		}
+	}
+	return nil
+}
+
+// layerForButton returns the layer switched by a button, or nil if it isn't a layer button
+func (c *deejConfig) layerForButton(buttonNum int) *configLayer {
+	for _, layer := range c.Layers {
+		if layer.Button == buttonNum {
+			return layer
+		}
+	}
+	return nil
+}
+
+// activeSliderTargets returns the slider mapping in effect: the active layer's on top
+// of the active profile's
+func (c *deejConfig) activeSliderTargets() map[int][]string {
+	base := c.profileSliderTargets()
+	layer := c.layer(layers.Active())
+	if layer == nil || len(layer.SliderTargets) == 0 {
+		return base
+	}
+
+	targets := make(map[int][]string, len(base)+len(layer.SliderTargets))
+	for sliderNum, t := range base {
+		targets[sliderNum] = t
+	}
+	for sliderNum, t := range layer.SliderTargets {
+		targets[sliderNum] = t
+	}
+	return targets
+}
+
+// activeButtonBinding returns what a button does with the active layer, if anything
+func (c *deejConfig) activeButtonBinding(buttonNum int) (*buttonBinding, bool) {
+	if layer := c.layer(layers.Active()); layer != nil {
+		if binding, exists := layer.ButtonMapping[buttonNum]; exists {
+			return binding, true
+		}
+	}
+	binding, exists := c.profileButtonMapping()[buttonNum]
+	return binding, exists
+}
@@ -11,24 +11,19 @@ import (
 	"io"
 	"log"
 	"os"
-	"runtime"
+	"sort"
 	"strconv"
 	"strings"
-	"syscall"
+	"sync"
 	"time"
 	"unicode"
 	"unicode/utf8"
-	"unsafe"
 
 	"github.com/go-ole/go-ole"
 	"github.com/go-ole/go-ole/oleutil"
-	"github.com/itchyny/volume-go"
-	"github.com/jacobsa/go-serial/serial"
 	"github.com/micmonay/keybd_event"
-	"github.com/moutend/go-wca/pkg/wca"
 	"github.com/nfnt/resize"
 	"github.com/spf13/viper"
-	"golang.org/x/sys/windows"
 	"golang.org/x/text/runes"
 	"golang.org/x/text/transform"
 	"golang.org/x/text/unicode/norm"
@@ -47,15 +42,21 @@ type TrackInfo struct {
 }
 
 const (
-	configName             = "config"
-	configType             = "yaml"
-	configPath             = "."
-	configKeySliderMapping = "slider_mapping"
-	configKeyButtonMapping = "button_mapping"
-	configKeyCOMPort       = "com_port"
-	configKeyBaudRate      = "baud_rate"
-	defaultCOMPort         = "COM9"
-	defaultBaudRate        = 115200
+	configName              = "config"
+	configType              = "yaml"
+	configPath              = "."
+	configKeySliderMapping  = "slider_mapping"
+	configKeyButtonMapping  = "button_mapping"
+	configKeyCOMPort        = "com_port"
+	configKeyBaudRate       = "baud_rate"
+	configKeyUSBIDs         = "usb_ids"
+	configKeyLayers         = "layers"
+	configKeyProfiles       = "profiles"
+	configKeyUnmappedSync   = "unmapped_sync"
+	configKeySliderSettings = "slider_settings"
+	defaultCOMPort          = "COM9"
+	defaultBaudRate         = 115200
+	defaultUnmappedSync     = unmappedSyncMax
 
 	TARGET_WIDTH  = 100
 	TARGET_HEIGHT = 100
@@ -64,35 +65,41 @@ const (
 )
 
 var (
-	user32 = windows.NewLazySystemDLL("user32.dll")
-	psapi  = windows.NewLazySystemDLL("psapi.dll")
+	kb            keybd_event.KeyBonding
+	keyboardMu    sync.Mutex
+	keyboardReady bool
+	userConfig    *viper.Viper
+	verbose       bool
 
-	procGetForegroundWindow      = user32.NewProc("GetForegroundWindow")
-	procGetWindowThreadProcessId = user32.NewProc("GetWindowThreadProcessId")
-	procGetModuleBaseNameW       = psapi.NewProc("GetModuleBaseNameW")
-
-	kb                   keybd_event.KeyBonding
-	userConfig           *viper.Viper
-	sliderMapping        map[string]int   // name -> number (for verbose/help)
-	sliderTargetsMapping map[int][]string // slider number -> list of targets
-	verbose              bool
-
-	lastForegroundWindowName string
-	lastSliderValues         []int
-	lastUserActivity         time.Time
-	lastTrackInfo            TrackInfo
-
-	sendingImage = false
+	lastTrackInfo TrackInfo
 )
 
 func main() {
 	verboseFlag := flag.Bool("verbose", false, "Enable verbose output (shows all messages)")
+	fakeAudioFlag := flag.Bool("fake-audio", false, "Use an in-memory audio backend instead of the system's")
 	flag.Parse()
 	verbose = *verboseFlag
 
-	// Initialize COM for Windows Audio
-	ole.CoInitializeEx(0, ole.COINIT_APARTMENTTHREADED)
-	defer ole.CoUninitialize()
+	switch flag.Arg(0) {
+	case "":
+	case "simulate":
+		if err := runSimulator(flag.Args()[1:]); err != nil {
+			log.Fatalf("Simulator failed: %v", err)
+		}
+		return
+	case "check-config":
+		if err := runCheckConfig(flag.Args()[1:]); err != nil {
+			log.Fatalf("Config check failed: %v", err)
+		}
+		return
+	case "ports":
+		if err := runPortsCommand(); err != nil {
+			log.Fatalf("Failed to list ports: %v", err)
+		}
+		return
+	default:
+		log.Fatalf("Unknown command: %s", flag.Arg(0))
+	}
 
 	// Initialize configuration
 	var err error
@@ -100,167 +107,80 @@ func main() {
 	if err != nil {
 		log.Fatalf("Failed to initialize config: %v", err)
 	}
+	config, err := loadConfig(userConfig)
+	if err != nil {
+		log.Fatalf("Failed to load config: %v", err)
+	}
+
+	// Initialize audio backend
+	if *fakeAudioFlag {
+		audio = newFakeBackend()
+	} else {
+		audio, err = newAudioBackend()
+		if err != nil {
+			log.Fatalf("Failed to initialize audio backend: %v", err)
+		}
+	}
+	defer audio.Close()
 
 	// Build slider mapping (name -> number) and targets mapping
-	sliderMapping = buildSliderMapping()
-	numSliders := len(sliderMapping)
-	initialize(numSliders)
+	applyConfig(config)
 
 	// Initialize keyboard
 	kb, err = keybd_event.NewKeyBonding()
 	if err != nil {
-		log.Fatalf("Failed to initialize keyboard: %v", err)
+		// Sliders still work without key presses, i.e. on Linux without access to uinput
+		log.Printf("Failed to initialize keyboard, button key presses are disabled: %v", err)
+	} else {
+		keyboardReady = true
 	}
 
-	// Get config values
-	comPort := userConfig.GetString(configKeyCOMPort)
-	baudRate := userConfig.GetUint(configKeyBaudRate)
-
 	// Configure serial port
-	options := serial.OpenOptions{
-		PortName:        comPort,
-		BaudRate:        baudRate,
-		DataBits:        8,
-		StopBits:        1,
-		MinimumReadSize: 1,
-	}
-
-	// Open serial port
-	port, err := serial.Open(options)
-	if err != nil {
-		log.Fatalf("Failed to open port: %v", err)
-	}
-	defer port.Close()
-
-	time.Sleep(2 * time.Second)
-	fmt.Printf("Connected to Arduino on %s at %d baud\n", comPort, baudRate)
+	options := serialOptions(config.COMPort, config.BaudRate)
 
 	// Channel for Arduino messages
 	msgChan := make(chan ArduinoMessage, 10)
 
-	// Start reading from Arduino in background
-	go readFromArduino(port, msgChan)
-
-	// Start processing received messages (always run to drain channel)
-	go processMessages(msgChan)
-
-	// currentWindowSlider := getSliderNumberForTarget("deej.current")
-	// Check for changes in the current window
-	/* if currentWindowSlider >= 0 {
-		go TrackCurrentProcessChanges(port, currentWindowSlider)
-	} */
-
-	go TrackVolumeChanges(port, time.Second)
-
-	// Main loop: handle user input
-	handleUserInput(port)
-}
-
-func initialize(numSliders int) {
-	// Allocate the slice inside the function
-	lastSliderValues = make([]int, numSliders)
-}
-
-// initializeConfig creates and configures a viper instance for the config file
-func initializeConfig() (*viper.Viper, error) {
-	config := viper.New()
-	config.SetConfigName(configName)
-	config.SetConfigType(configType)
-	config.AddConfigPath(configPath)
-
-	// Set defaults
-	config.SetDefault(configKeySliderMapping, map[int]string{})
-	config.SetDefault(configKeyButtonMapping, map[int]int{})
-	config.SetDefault(configKeyCOMPort, defaultCOMPort)
-	config.SetDefault(configKeyBaudRate, defaultBaudRate)
-
-	// Read config file
-	if err := config.ReadInConfig(); err != nil {
-		return nil, fmt.Errorf("failed to read config file: %w", err)
-	}
+	// Keep the board connected in background, reading from it while connected
+	conn := newSerialConnection(options, config.USBIDs)
+	defer conn.Close()
+	go conn.run(msgChan, func() { go syncBoard(conn) })
 
-	if verbose {
-		fmt.Printf("Loaded config from: %s\n", config.ConfigFileUsed())
-	}
-	return config, nil
-}
-
-// buildSliderMapping creates mappings for verbose/help and slider targets
-func buildSliderMapping() map[string]int {
-	mapping := make(map[string]int)
-	sliderTargetsMapping = make(map[int][]string)
+	// Layers and profiles map sliders to other targets, so the faders move to their volumes
+	layers.OnChange(func(*configLayer) { go syncBoard(conn) })
+	profiles.OnChange(func(*configProfile) { go syncBoard(conn) })
 
-	sliderMap := userConfig.GetStringMap(configKeySliderMapping)
+	// Profiles with auto_switch and deej.current faders follow the foreground window
+	follower := newCurrentWindowFollower(conn)
+	go watchForeground(foregroundPollInterval, func(processName string) {
+		profiles.followForeground(processName)
+		follower.focusChanged()
+	})
 
-	for key, value := range sliderMap {
-		sliderNum, err := strconv.Atoi(key)
-		if err != nil {
-			continue
-		}
+	// Pick up config changes without restarting
+	watchConfig(userConfig, conn)
 
-		var targets []string
-
-		switch v := value.(type) {
-		case string:
-			targets = []string{v}
-		case []interface{}:
-			for _, item := range v {
-				if s, ok := item.(string); ok {
-					s = strings.TrimSpace(s)
-					if s != "" {
-						targets = append(targets, s)
-					}
-				}
-			}
-		}
-
-		if len(targets) > 0 {
-			sliderTargetsMapping[sliderNum] = targets
-			for _, name := range targets {
-				mapping[name] = sliderNum
-				if verbose {
-					fmt.Printf("Slider %d -> %s\n", sliderNum, name)
-				}
-			}
-		}
-	}
+	// Start processing received messages (always run to drain channel)
+	go processMessages(msgChan)
 
-	return mapping
-}
+	go TrackVolumeChanges(conn, time.Second)
 
-// getSliderTargets returns all target apps for a slider number
-func getSliderTargets(sliderNum int) []string {
-	if targets, exists := sliderTargetsMapping[sliderNum]; exists {
-		return targets
-	}
-	return nil
+	// Main loop: handle user input
+	handleUserInput(conn)
 }
 
-func getSliderNumberForTarget(target string) int {
-	targetLower := strings.ToLower(target)
-	for sliderNum, targets := range sliderTargetsMapping {
-		for _, t := range targets {
-			if strings.ToLower(t) == targetLower {
-				return sliderNum
-			}
+// readFromArduino handles messages from the board until reading fails, returning the error.
+// Frames are turned into their text equivalent, so both protocols share this handler.
+func readFromArduino(conn *serialConnection, messages <-chan serialMessage, msgChan chan<- ArduinoMessage) error {
+	for message := range messages {
+		if message.err != nil {
+			return message.err
 		}
-	}
-	return -1
-}
 
-// Continuously read from Arduino
-func readFromArduino(port io.ReadWriteCloser, msgChan chan<- ArduinoMessage) {
-	reader := bufio.NewReader(port)
-
-	for {
-		line, err := reader.ReadString('\n')
-		if err != nil {
-			log.Printf("Error reading from Arduino: %v", err)
-			time.Sleep(100 * time.Millisecond)
-			continue
+		line := message.text
+		if message.frame != nil {
+			line = frameText(*message.frame)
 		}
-
-		line = strings.TrimSpace(line)
 		if line == "" {
 			continue
 		}
@@ -270,39 +190,27 @@ func readFromArduino(port io.ReadWriteCloser, msgChan chan<- ArduinoMessage) {
 			if verbose {
 				fmt.Printf("[Arduino Response] %s\n", line)
 			}
+			conn.resolveSet(message, line)
 		} else if line == "Arduino ready" {
+			// The board was reset while connected: it is back to the text protocol
+			// and its motor faders need to be brought back in line
 			if verbose {
 				fmt.Println("[Arduino] Ready!")
 			}
+			if err := conn.handshake(conn, messages); err != nil {
+				return err
+			}
+			go syncBoard(conn)
 		} else if line == "PONG" {
 			fmt.Println("[Arduino] PONG received")
 		} else if strings.HasPrefix(line, "REQ") {
-			sendingImage = true
 			if verbose {
 				fmt.Println("[Arduino] REQ received")
 			}
-
-			trackInfo, err := getCurrentTrackArtwork()
-			if err != nil {
-				fmt.Errorf("Error to read Track Info", err)
-			}
-			if verbose {
-				log.Println("Got Track Data")
-			}
-
-			if trackInfo.Name != lastTrackInfo.Name || line == "REQ:NEW" {
-				handleImageSend(port, trackInfo)
-				lastTrackInfo = trackInfo
-			} else {
-				if verbose {
-					log.Println("Track Data was the Same")
-				}
-				port.Write([]byte{'N', 'I', 'L', '\n'})
-			}
-
-			sendingImage = false
+			// Answer in the background, so SET answers are read during the transfer
+			go handleImageRequest(conn, line == "REQ:NEW")
 		} else {
-			lastUserActivity = time.Now()
+			sliders.MarkActivity()
 			// Parse sensor data: s0v75|b1v1
 			msg := parseArduinoData(line)
 			if len(msg.SliderValues) > 0 || len(msg.ButtonStates) > 0 {
@@ -310,9 +218,12 @@ func readFromArduino(port io.ReadWriteCloser, msgChan chan<- ArduinoMessage) {
 			}
 		}
 	}
+
+	return io.EOF
 }
 
-// Parse Arduino data format: s0v75|b1v1
+// Parse Arduino data format: s0v75|b1v1, where buttons report 1 when pressed and
+// 0 when released
 func parseArduinoData(data string) ArduinoMessage {
 	msg := ArduinoMessage{
 		Timestamp:    time.Now(),
@@ -334,31 +245,13 @@ func parseArduinoData(data string) ArduinoMessage {
 			if err == nil && n == 2 {
 				msg.SliderValues[sliderNum] = value
 
-				lastSliderValues[sliderNum] = value
-
-				// Get all targets for this slider
-				targets := getSliderTargets(sliderNum)
-				for _, target := range targets {
-					switch target {
-					case "master":
-						go setSystemVolume(value)
-					case "mic":
-						go setMicrophoneVolume(value)
-					case "deej.current":
-						processName, err := getCurrentProcessName()
-						if err != nil {
-							log.Println(err)
-							continue
-						}
-						go setApplicationVolume(processName, value)
-						lastForegroundWindowName = processName
-					case "deej.unmapped":
-						setUnmappedApplicationsVolume(value)
-					default:
-						if strings.HasSuffix(strings.ToLower(target), ".exe") {
-							go setApplicationVolume(target, value)
-						}
-					}
+				sliders.SetPosition(sliderNum, value)
+
+				// Get all targets for this slider, which get the volume its response maps to
+				response := currentConfig().sliderResponse(sliderNum)
+				volume := response.volume(value)
+				for _, target := range getSliderTargets(sliderNum) {
+					volumeWriter.Set(target, volume, float64(response.Smoothing)/100)
 				}
 			}
 		case 'b':
@@ -368,15 +261,8 @@ func parseArduinoData(data string) ArduinoMessage {
 			if err == nil && n == 2 {
 				msg.ButtonStates[buttonNum] = (value == 1)
 
-				// Send button press to Windows if button is pressed
-				if value == 1 {
-					buttonMapping := userConfig.GetStringMap(configKeyButtonMapping)
-					if keyCodeVal, exists := buttonMapping[strconv.Itoa(buttonNum)]; exists {
-						if keyCode, ok := keyCodeVal.(int); ok {
-							go sendKeyPress(keyCode)
-						}
-					}
-				}
+				// Presses and releases become gestures, which run the button's actions
+				buttons.Handle(buttonNum, value == 1, msg.Timestamp)
 			}
 		}
 	}
@@ -384,174 +270,224 @@ func parseArduinoData(data string) ArduinoMessage {
 	return msg
 }
 
-func getCurrentProcessName() (string, error) {
-	hwnd, _, err := procGetForegroundWindow.Call()
-	if hwnd == 0 {
-		return "", err
+const (
+	// Volumes are still polled now and then when the backend reports changes, in case a report is lost
+	volumeSyncFallbackInterval = 10 * time.Second
+	volumeChangeSettle         = 10 * time.Millisecond
+	// External changes don't move the faders while the user is moving them
+	userActivityHold = 2 * time.Second
+)
+
+// TrackVolumeChanges moves the motor faders when volumes change outside deej. It wakes
+// up as soon as the audio backend reports a change; backends that can't report them
+// are polled every interval.
+func TrackVolumeChanges(conn *serialConnection, interval time.Duration) {
+	changes := audio.Changes()
+	if changes != nil {
+		interval = volumeSyncFallbackInterval
 	}
 
-	var pid uint32
-	procGetWindowThreadProcessId.Call(hwnd, uintptr(unsafe.Pointer(&pid)))
+	wait := interval
+	mutes := ""
+	for {
+		select {
+		case <-changes:
+			// Changes are reported one session or endpoint at a time
+			time.Sleep(volumeChangeSettle)
+			select {
+			case <-changes:
+			default:
+			}
+		case <-time.After(wait):
+		}
 
-	handle, err := windows.OpenProcess(
-		windows.PROCESS_QUERY_INFORMATION|windows.PROCESS_VM_READ,
-		false,
-		pid,
-	)
-	if err != nil {
-		return "", err
+		wait = interval
+		// Mutes don't move the faders, so they are shown while the sliders move as well
+		if conn.State() == StateConnected {
+			mutes = syncSliderMutes(conn, mutes)
+		}
+		if idle := sliders.SinceActivity(); idle < userActivityHold {
+			// The sliders are being moved; look again once they have settled
+			wait = userActivityHold - idle
+			continue
+		}
+		if conn.State() == StateConnected {
+			syncSliderVolumes(conn)
+		}
 	}
-	defer windows.CloseHandle(handle)
+}
 
-	buf := make([]uint16, windows.MAX_PATH)
-	ret, _, err := procGetModuleBaseNameW.Call(
-		uintptr(handle),
-		0,
-		uintptr(unsafe.Pointer(&buf[0])),
-		uintptr(len(buf)),
-	)
-	if ret == 0 {
-		return "", err
+// syncSliderVolumes moves every motor fader whose targets' volume differs from it
+func syncSliderVolumes(conn *serialConnection) {
+	for sliderNum := range currentConfig().activeSliderTargets() {
+		syncSliderVolume(conn, sliderNum)
 	}
-
-	return syscall.UTF16ToString(buf), nil
 }
 
-// Check, whether this is necessary
-/* func TrackCurrentProcessChanges(port io.ReadWriteCloser, slider int) {
-	for {
-		processName, err := getCurrentProcessName()
-		if err != nil {
-			// log.Println(err)
-			continue
+// syncSliderVolume moves a motor fader to its targets' volume, if that differs
+func syncSliderVolume(conn *serialConnection, sliderNum int) {
+	currentVolume, ok := readSliderVolume(sliderNum)
+	if !ok {
+		return
+	}
+	last, ok := sliders.Position(sliderNum)
+	if !ok {
+		return
+	}
+	// Compare with the volume the slider sets where it is, as several positions can
+	// map to the same volume, and volumes outside its range all map to one end
+	response := currentConfig().sliderResponse(sliderNum)
+	position := response.position(currentVolume)
+	if currentVolume != response.volume(last) && position != last {
+		// Update Arduino slider, and only remember the position once the board confirmed it
+		if err := conn.SetSlider(sliderNum, position); err != nil {
+			log.Printf("Error moving slider %d: %v", sliderNum, err)
+			return
 		}
-		if processName != lastForegroundWindowName {
-			volume := getApplicationVolume(processName)
-			if volume >= 0 && volume != lastSliderValues[slider] {
-				sendCommand(port, fmt.Sprintf("SET:%d:%d", slider, volume))
-				lastSliderValues[slider] = volume
-				if verbose {
-					fmt.Printf("Current Window changed, adjusted slider to %v", volume)
-				}
-			}
-			lastForegroundWindowName = processName
+		sliders.SetPosition(sliderNum, position)
+
+		if verbose {
+			log.Printf("[Sync] Slider %d updated to %d%% for %d%% volume\n", sliderNum, position, currentVolume)
 		}
 	}
-} */
+}
 
-func TrackVolumeChanges(port io.ReadWriteCloser, interval time.Duration) {
-	for {
-		if time.Since(lastUserActivity) < time.Second*2 {
-			time.Sleep(interval)
+// readSliderVolume reads the volume of a slider's targets. ok is false when none of
+// them could be read or a group's members disagree.
+func readSliderVolume(sliderNum int) (volume int, ok bool) {
+	var currentVolume int
+	firstItem := true
+	allTheSame := true
+	for _, target := range getSliderTargets(sliderNum) {
+		myVolume, ok := getTargetVolume(target)
+		if !ok {
 			continue
 		}
-		for sliderNum, targets := range sliderTargetsMapping {
-			var currentVolume int
-			var myVolume int
-			firstItem := true
-			allTheSame := true
-			for _, target := range targets {
-				switch target {
-				case "master":
-					myVolume = getSystemVolume()
-				case "mic":
-					myVolume = getMicrophoneVolume()
-				case "deej.current":
-					processName, err := getCurrentProcessName()
-					if err != nil {
-						continue
-					}
-					myVolume = getApplicationVolume(processName)
-				case "deej.unmapped":
-					// TODO
-					continue
-				default:
-					if strings.HasSuffix(strings.ToLower(target), ".exe") {
-						myVolume = getApplicationVolume(target)
-					} else {
-						continue
-					}
-				}
-				if firstItem || myVolume == currentVolume {
-					currentVolume = myVolume
-				} else {
-					allTheSame = false
-				}
-				firstItem = false
-			}
-			if currentVolume < 0 {
-				continue // app not found or not playing audio
-			}
-			if allTheSame {
-				// Compare with last slider value
-				if currentVolume != lastSliderValues[sliderNum] {
-					// Update Arduino slider
-					sendCommand(port, fmt.Sprintf("SET:%d:%d", sliderNum, currentVolume))
-					lastSliderValues[sliderNum] = currentVolume
-
-					if verbose {
-						log.Printf("[Sync] Slider %d updated to %d%%\n", sliderNum, currentVolume)
-					}
-				}
-			}
+		if firstItem || myVolume == currentVolume {
+			currentVolume = myVolume
+		} else {
+			allTheSame = false
+		}
+		firstItem = false
+	}
+
+	if firstItem || currentVolume < 0 {
+		return 0, false // app not found or not playing audio
+	}
+	return currentVolume, allTheSame
+}
 
+// getTargetVolume reads the volume of one slider target, -1 if it can't be read. ok is
+// false for targets without a volume to read.
+func getTargetVolume(target string) (volume int, ok bool) {
+	switch target {
+	case "master":
+		return getSystemVolume(), true
+	case "mic":
+		return getMicrophoneVolume(), true
+	case "deej.current":
+		processName, err := getCurrentProcessName()
+		if err != nil {
+			return 0, false
 		}
+		return getApplicationVolume(processName), true
+	case "deej.unmapped":
+		return getUnmappedApplicationsVolume(currentConfig().UnmappedSync), true
+	}
+	if isDeviceTarget(target) {
+		return getNamedDeviceVolume(target), true
+	}
+	if isProcessTarget(target) {
+		return getApplicationVolume(target), true
+	}
+	return 0, false
+}
 
-		time.Sleep(interval)
+// setTargetVolume sets the volume of one slider target
+func setTargetVolume(target string, value int) {
+	switch target {
+	case "master":
+		setSystemVolume(value)
+	case "mic":
+		setMicrophoneVolume(value)
+	case "deej.current":
+		processName, err := getCurrentProcessName()
+		if err != nil {
+			log.Println(err)
+			return
+		}
+		setApplicationVolume(processName, value)
+	case "deej.unmapped":
+		setUnmappedApplicationsVolume(value)
+	default:
+		if isDeviceTarget(target) {
+			setNamedDeviceVolume(target, value)
+		} else if isProcessTarget(target) {
+			setApplicationVolume(target, value)
+		}
 	}
 }
 
 // setUnmappedApplicationsVolume sets volume for all sessions not mapped to any slider,
 // excluding the current foreground app
 func setUnmappedApplicationsVolume(volume int) {
-	runtime.LockOSThread()
-	defer runtime.UnlockOSThread()
+	n, err := audio.SetSessionVolume(unmappedSessions(), volume)
+	if err != nil {
+		log.Printf("Error setting unmapped volume: %v", err)
+	} else if verbose {
+		fmt.Printf("[Unmapped Apps: %d] Set to %d%%\n", n, volume)
+	}
+}
 
-	ole.CoInitializeEx(0, ole.COINIT_APARTMENTTHREADED)
-	defer ole.CoUninitialize()
+// How a deej.unmapped slider follows its apps when their volumes differ
+const (
+	unmappedSyncMax    = "max"    // the loudest app
+	unmappedSyncMedian = "median" // the app in the middle
+	unmappedSyncSkip   = "skip"   // not at all, until they agree again
+)
 
-	var mmde *wca.IMMDeviceEnumerator
-	if err := wca.CoCreateInstance(wca.CLSID_MMDeviceEnumerator, 0, wca.CLSCTX_ALL, wca.IID_IMMDeviceEnumerator, &mmde); err != nil {
-		log.Printf("Error creating device enumerator: %v", err)
-		return
-	}
-	if mmde != nil {
-		defer mmde.Release()
-	}
+var unmappedSyncModes = []string{unmappedSyncMax, unmappedSyncMedian, unmappedSyncSkip}
 
-	var mmDevice *wca.IMMDevice
-	if err := mmde.GetDefaultAudioEndpoint(wca.ERender, wca.EConsole, &mmDevice); err != nil {
-		log.Printf("Error getting default audio endpoint: %v", err)
-		return
-	}
-	if mmDevice != nil {
-		defer mmDevice.Release()
+// getUnmappedApplicationsVolume reads the volume of the sessions deej.unmapped controls,
+// settling differences between them as mode says. Returns -1 if there are none, or
+// if they differ and mode is skip.
+func getUnmappedApplicationsVolume(mode string) int {
+	sessions, err := audio.Sessions()
+	if err != nil {
+		log.Printf("Error listing audio sessions: %v", err)
+		return -1
 	}
 
-	var sessionManager *wca.IAudioSessionManager2
-	if err := mmDevice.Activate(wca.IID_IAudioSessionManager2, wca.CLSCTX_ALL, nil, &sessionManager); err != nil {
-		log.Printf("Error activating session manager: %v", err)
-		return
+	match := unmappedSessions()
+	var volumes []int
+	for _, session := range sessions {
+		if match(session) {
+			volumes = append(volumes, session.Volume)
+		}
 	}
-	if sessionManager != nil {
-		defer sessionManager.Release()
+	if len(volumes) == 0 {
+		return -1
 	}
 
-	var sessionEnumerator *wca.IAudioSessionEnumerator
-	if err := sessionManager.GetSessionEnumerator(&sessionEnumerator); err != nil {
-		log.Printf("Error getting session enumerator: %v", err)
-		return
+	sort.Ints(volumes)
+	if volumes[0] == volumes[len(volumes)-1] {
+		return volumes[0]
 	}
-	if sessionEnumerator != nil {
-		defer sessionEnumerator.Release()
-	}
-
-	var sessionCount int
-	if err := sessionEnumerator.GetCount(&sessionCount); err != nil {
-		log.Printf("Error getting session count: %v", err)
-		return
+	switch mode {
+	case unmappedSyncMedian:
+		middle := len(volumes) / 2
+		if len(volumes)%2 == 0 {
+			return (volumes[middle-1] + volumes[middle]) / 2
+		}
+		return volumes[middle]
+	case unmappedSyncSkip:
+		return -1
 	}
+	return volumes[len(volumes)-1]
+}
 
+// unmappedSessions matches the sessions deej.unmapped controls
+func unmappedSessions() SessionMatcher {
 	// Current foreground process to exclude
 	currentApp, err := getCurrentProcessName()
 	if err != nil {
@@ -559,74 +495,33 @@ func setUnmappedApplicationsVolume(volume int) {
 	}
 	currentApp = strings.ToLower(currentApp)
 
-	// Collect all explicitly mapped apps
-	mappedApps := make(map[string]struct{})
-	for _, targets := range sliderTargetsMapping {
+	// Collect all explicitly mapped apps, by name or pattern
+	var mappedApps []SessionMatcher
+	for _, targets := range currentConfig().activeSliderTargets() {
 		for _, t := range targets {
-			t = strings.ToLower(t)
-			if t != "deej.unmapped" && t != "master" && t != "mic" && t != "deej.current" {
-				mappedApps[t] = struct{}{}
+			if isProcessTarget(t) {
+				mappedApps = append(mappedApps, sessionMatcher(t))
 			}
 		}
 	}
 
-	for i := 0; i < sessionCount; i++ {
-		var sessionControl *wca.IAudioSessionControl
-		if err := sessionEnumerator.GetSession(i, &sessionControl); err != nil {
-			continue
-		}
-		if sessionControl == nil {
-			continue
-		}
-
-		sessionControl2Dispatch, err := sessionControl.QueryInterface(wca.IID_IAudioSessionControl2)
-		if err != nil {
-			sessionControl.Release()
-			continue
-		}
-		sessionControl2 := (*wca.IAudioSessionControl2)(unsafe.Pointer(sessionControl2Dispatch))
-
-		var processId uint32
-		if err := sessionControl2.GetProcessId(&processId); err != nil {
-			sessionControl2Dispatch.Release()
-			sessionControl.Release()
-			continue
+	// Skip mapped apps and the current foreground app
+	return func(session AudioSession) bool {
+		if strings.ToLower(session.ProcessName) == currentApp {
+			return false
 		}
-
-		processName := strings.ToLower(getProcessName(processId))
-
-		// Skip mapped apps and the current foreground app
-		if _, exists := mappedApps[processName]; exists || processName == currentApp {
-			sessionControl2Dispatch.Release()
-			sessionControl.Release()
-			continue
-		}
-
-		// Apply volume
-		simpleVolumeDispatch, err := sessionControl2.QueryInterface(wca.IID_ISimpleAudioVolume)
-		if err != nil {
-			sessionControl2Dispatch.Release()
-			sessionControl.Release()
-			continue
-		}
-		simpleVolume := (*wca.ISimpleAudioVolume)(unsafe.Pointer(simpleVolumeDispatch))
-
-		volumeScalar := float32(volume) / 100.0
-		simpleVolume.SetMasterVolume(volumeScalar, nil)
-
-		if verbose {
-			fmt.Printf("[Unmapped App: %s] Set to %d%%\n", processName, volume)
+		for _, mapped := range mappedApps {
+			if mapped(session) {
+				return false
+			}
 		}
-
-		simpleVolumeDispatch.Release()
-		sessionControl2Dispatch.Release()
-		sessionControl.Release()
+		return true
 	}
 }
 
-// setSystemVolume sets the Windows system volume (0-100)
+// setSystemVolume sets the system volume (0-100)
 func setSystemVolume(percentage int) {
-	err := volume.SetVolume(percentage)
+	err := audio.SetDeviceVolume(defaultRenderDevice, percentage)
 	if err != nil {
 		log.Printf("Error setting volume to %d%%: %v", percentage, err)
 	} else if verbose {
@@ -634,92 +529,19 @@ func setSystemVolume(percentage int) {
 	}
 }
 
+// Returns -1 if the default output device can't be read
 func getSystemVolume() int {
-	runtime.LockOSThread()
-	defer runtime.UnlockOSThread()
-
-	ole.CoInitializeEx(0, ole.COINIT_APARTMENTTHREADED)
-	defer ole.CoUninitialize()
-
-	// Get default audio endpoint (speakers)
-	var mmde *wca.IMMDeviceEnumerator
-	if err := wca.CoCreateInstance(wca.CLSID_MMDeviceEnumerator, 0, wca.CLSCTX_ALL, wca.IID_IMMDeviceEnumerator, &mmde); err != nil {
-		log.Printf("Error creating device enumerator: %v", err)
-		return -1
-	}
-	if mmde != nil {
-		defer mmde.Release()
-	}
-
-	var mmDevice *wca.IMMDevice
-	if err := mmde.GetDefaultAudioEndpoint(wca.ERender, wca.EConsole, &mmDevice); err != nil {
-		log.Printf("Error getting default audio endpoint: %v", err)
-		return -1
-	}
-	if mmDevice != nil {
-		defer mmDevice.Release()
-	}
-
-	var endpointVolume *wca.IAudioEndpointVolume
-	if err := mmDevice.Activate(wca.IID_IAudioEndpointVolume, wca.CLSCTX_ALL, nil, &endpointVolume); err != nil {
-		log.Printf("Error activating audio endpoint: %v", err)
-		return -1
-	}
-	if endpointVolume != nil {
-		defer endpointVolume.Release()
-	}
-
-	// Get master volume as a float between 0.0 and 1.0
-	var vol float32
-	err := endpointVolume.GetMasterVolumeLevelScalar(&vol)
+	device, err := audio.Device(defaultRenderDevice)
 	if err != nil {
 		log.Printf("Error getting master volume: %v", err)
 		return -1
 	}
-
-	percent := int(vol * 100)
-	return percent
+	return device.Volume
 }
 
 func setMicrophoneVolume(percentage int) {
-	runtime.LockOSThread()
-	defer runtime.UnlockOSThread()
-
-	ole.CoInitializeEx(0, ole.COINIT_APARTMENTTHREADED)
-	defer ole.CoUninitialize()
-
-	var err error
-	var mmde *wca.IMMDeviceEnumerator
-
-	if err = wca.CoCreateInstance(wca.CLSID_MMDeviceEnumerator, 0, wca.CLSCTX_ALL, wca.IID_IMMDeviceEnumerator, &mmde); err != nil {
-		log.Printf("Error creating device enumerator: %v", err)
-		return
-	}
-	if mmde != nil {
-		defer mmde.Release()
-	}
-
-	var mmDevice *wca.IMMDevice
-	if err = mmde.GetDefaultAudioEndpoint(wca.ECapture, wca.EConsole, &mmDevice); err != nil {
-		log.Printf("Error getting default microphone: %v", err)
-		return
-	}
-	if mmDevice != nil {
-		defer mmDevice.Release()
-	}
-
-	var endpointVolume *wca.IAudioEndpointVolume
-	if err = mmDevice.Activate(wca.IID_IAudioEndpointVolume, wca.CLSCTX_ALL, nil, &endpointVolume); err != nil {
-		log.Printf("Error activating endpoint volume: %v", err)
-		return
-	}
-	if endpointVolume != nil {
-		defer endpointVolume.Release()
-	}
-
-	volumeScalar := float32(percentage) / 100.0
-
-	if err = endpointVolume.SetMasterVolumeLevelScalar(volumeScalar, nil); err != nil {
+	err := audio.SetDeviceVolume(defaultCaptureDevice, percentage)
+	if err != nil {
 		log.Printf("Error setting microphone volume to %d%%: %v", percentage, err)
 	} else if verbose {
 		fmt.Printf("[Microphone] Set to %d%%\n", percentage)
@@ -728,330 +550,102 @@ func setMicrophoneVolume(percentage int) {
 
 // Helper function to get microphone volume as int 0-100
 func getMicrophoneVolume() int {
-	runtime.LockOSThread()
-	defer runtime.UnlockOSThread()
-
-	ole.CoInitializeEx(0, ole.COINIT_APARTMENTTHREADED)
-	defer ole.CoUninitialize()
-
-	var mmde *wca.IMMDeviceEnumerator
-	if err := wca.CoCreateInstance(wca.CLSID_MMDeviceEnumerator, 0, wca.CLSCTX_ALL, wca.IID_IMMDeviceEnumerator, &mmde); err != nil {
-		log.Printf("Error creating device enumerator: %v", err)
-		return -1
-	}
-	if mmde != nil {
-		defer mmde.Release()
-	}
-
-	var mmDevice *wca.IMMDevice
-	if err := mmde.GetDefaultAudioEndpoint(wca.ECapture, wca.EConsole, &mmDevice); err != nil {
-		log.Printf("Error getting default microphone: %v", err)
-		return -1
-	}
-	if mmDevice != nil {
-		defer mmDevice.Release()
-	}
-
-	var endpointVolume *wca.IAudioEndpointVolume
-	if err := mmDevice.Activate(wca.IID_IAudioEndpointVolume, wca.CLSCTX_ALL, nil, &endpointVolume); err != nil {
-		log.Printf("Error activating endpoint volume: %v", err)
-		return -1
-	}
-	if endpointVolume != nil {
-		defer endpointVolume.Release()
-	}
-
-	var vol float32
-	if err := endpointVolume.GetMasterVolumeLevelScalar(&vol); err != nil {
+	device, err := audio.Device(defaultCaptureDevice)
+	if err != nil {
 		log.Printf("Error getting microphone volume: %v", err)
 		return -1
 	}
-
-	return int(vol * 100)
+	return device.Volume
 }
 
-func setApplicationVolume(processName string, percentage int) {
-	runtime.LockOSThread()
-	defer runtime.UnlockOSThread()
-
-	ole.CoInitializeEx(0, ole.COINIT_APARTMENTTHREADED)
-	defer ole.CoUninitialize()
-
-	var err error
-	var mmde *wca.IMMDeviceEnumerator
-
-	if err = wca.CoCreateInstance(wca.CLSID_MMDeviceEnumerator, 0, wca.CLSCTX_ALL, wca.IID_IMMDeviceEnumerator, &mmde); err != nil {
-		log.Printf("Error creating device enumerator: %v", err)
-		return
-	}
-	if mmde != nil {
-		defer mmde.Release()
-	}
-
-	var mmDevice *wca.IMMDevice
-	if err = mmde.GetDefaultAudioEndpoint(wca.ERender, wca.EConsole, &mmDevice); err != nil {
-		log.Printf("Error getting default audio endpoint: %v", err)
-		return
-	}
-	if mmDevice != nil {
-		defer mmDevice.Release()
-	}
-
-	var sessionManager *wca.IAudioSessionManager2
-	if err = mmDevice.Activate(wca.IID_IAudioSessionManager2, wca.CLSCTX_ALL, nil, &sessionManager); err != nil {
-		log.Printf("Error activating session manager: %v", err)
-		return
-	}
-	if sessionManager != nil {
-		defer sessionManager.Release()
-	}
-
-	var sessionEnumerator *wca.IAudioSessionEnumerator
-	if err = sessionManager.GetSessionEnumerator(&sessionEnumerator); err != nil {
-		log.Printf("Error getting session enumerator: %v", err)
+// setNamedDeviceVolume sets the volume of the endpoints a device target names
+func setNamedDeviceVolume(target string, percentage int) {
+	ids, _, err := targetDevices(target)
+	if err != nil {
+		log.Printf("Error setting volume for %s: %v", target, err)
 		return
 	}
-	if sessionEnumerator != nil {
-		defer sessionEnumerator.Release()
+	for _, id := range ids {
+		if err := audio.SetDeviceVolume(id, percentage); err != nil {
+			log.Printf("Error setting volume for %s: %v", target, err)
+		} else if verbose {
+			fmt.Printf("[Device: %s] Set to %d%%\n", id, percentage)
+		}
 	}
+}
 
-	var sessionCount int
-	if err = sessionEnumerator.GetCount(&sessionCount); err != nil {
-		log.Printf("Error getting session count: %v", err)
-		return
+// getNamedDeviceVolume reads the volume of the first endpoint a device target names.
+// Returns -1 if there is none.
+func getNamedDeviceVolume(target string) int {
+	ids, _, err := targetDevices(target)
+	if err != nil {
+		log.Printf("Error getting volume for %s: %v", target, err)
+		return -1
 	}
-
-	processNameLower := strings.ToLower(processName)
-	found := false
-
-	for i := 0; i < sessionCount; i++ {
-		var sessionControl *wca.IAudioSessionControl
-		if err = sessionEnumerator.GetSession(i, &sessionControl); err != nil {
-			continue
-		}
-		if sessionControl == nil {
-			continue
-		}
-
-		sessionControl2Dispatch, err := sessionControl.QueryInterface(wca.IID_IAudioSessionControl2)
-		if err != nil {
-			sessionControl.Release()
-			continue
-		}
-		sessionControl2 := (*wca.IAudioSessionControl2)(unsafe.Pointer(sessionControl2Dispatch))
-
-		var processId uint32
-		if err = sessionControl2.GetProcessId(&processId); err != nil {
-			sessionControl2Dispatch.Release()
-			sessionControl.Release()
-			continue
-		}
-
-		currentProcessName := getProcessName(processId)
-
-		if strings.ToLower(currentProcessName) == processNameLower {
-			simpleVolumeDispatch, err := sessionControl2.QueryInterface(wca.IID_ISimpleAudioVolume)
-			if err != nil {
-				sessionControl2Dispatch.Release()
-				sessionControl.Release()
-				continue
-			}
-			simpleVolume := (*wca.ISimpleAudioVolume)(unsafe.Pointer(simpleVolumeDispatch))
-
-			volumeScalar := float32(percentage) / 100.0
-
-			if err = simpleVolume.SetMasterVolume(volumeScalar, nil); err != nil {
-				log.Printf("Error setting volume for %s: %v", processName, err)
-			} else {
-				if verbose {
-					fmt.Printf("[App: %s] Set to %d%%\n", processName, percentage)
-				}
-				found = true
-			}
-
-			simpleVolumeDispatch.Release()
-			sessionControl2Dispatch.Release()
-			sessionControl.Release()
-			break
+	for _, id := range ids {
+		if device, err := audio.Device(id); err == nil {
+			return device.Volume
 		}
+	}
+	return -1
+}
 
-		sessionControl2Dispatch.Release()
-		sessionControl.Release()
+// setApplicationVolume sets the volume of the sessions matched by an application
+// target, which is a process name or a glob: or regex: pattern
+func setApplicationVolume(processName string, percentage int) {
+	n, err := audio.SetSessionVolume(sessionMatcher(processName), percentage)
+	if err != nil {
+		log.Printf("Error setting volume for %s: %v", processName, err)
+	} else if n > 0 && verbose {
+		fmt.Printf("[App: %s] Set to %d%%\n", processName, percentage)
 	}
 
-	if !found && verbose {
+	if n == 0 && verbose {
 		log.Printf("Application %s not found or not playing audio", processName)
 	}
 }
 
 // Returns -1 if the application is not found or not playing audio
 func getApplicationVolume(processName string) int {
-	runtime.LockOSThread()
-	defer runtime.UnlockOSThread()
-
-	ole.CoInitializeEx(0, ole.COINIT_APARTMENTTHREADED)
-	defer ole.CoUninitialize()
-
-	var err error
-	var mmde *wca.IMMDeviceEnumerator
-
-	if err = wca.CoCreateInstance(wca.CLSID_MMDeviceEnumerator, 0, wca.CLSCTX_ALL, wca.IID_IMMDeviceEnumerator, &mmde); err != nil {
-		log.Printf("Error creating device enumerator: %v", err)
-		return -1
-	}
-	if mmde != nil {
-		defer mmde.Release()
-	}
-
-	var mmDevice *wca.IMMDevice
-	if err = mmde.GetDefaultAudioEndpoint(wca.ERender, wca.EConsole, &mmDevice); err != nil {
-		log.Printf("Error getting default audio endpoint: %v", err)
-		return -1
-	}
-	if mmDevice != nil {
-		defer mmDevice.Release()
-	}
-
-	var sessionManager *wca.IAudioSessionManager2
-	if err = mmDevice.Activate(wca.IID_IAudioSessionManager2, wca.CLSCTX_ALL, nil, &sessionManager); err != nil {
-		log.Printf("Error activating session manager: %v", err)
-		return -1
-	}
-	if sessionManager != nil {
-		defer sessionManager.Release()
-	}
-
-	var sessionEnumerator *wca.IAudioSessionEnumerator
-	if err = sessionManager.GetSessionEnumerator(&sessionEnumerator); err != nil {
-		log.Printf("Error getting session enumerator: %v", err)
-		return -1
-	}
-	if sessionEnumerator != nil {
-		defer sessionEnumerator.Release()
-	}
-
-	var sessionCount int
-	if err = sessionEnumerator.GetCount(&sessionCount); err != nil {
-		log.Printf("Error getting session count: %v", err)
+	sessions, err := audio.Sessions()
+	if err != nil {
+		log.Printf("Error listing audio sessions: %v", err)
 		return -1
 	}
 
-	processNameLower := strings.ToLower(processName)
-
-	for i := 0; i < sessionCount; i++ {
-		var sessionControl *wca.IAudioSessionControl
-		if err = sessionEnumerator.GetSession(i, &sessionControl); err != nil {
-			continue
-		}
-		if sessionControl == nil {
-			continue
-		}
-
-		sessionControl2Dispatch, err := sessionControl.QueryInterface(wca.IID_IAudioSessionControl2)
-		if err != nil {
-			sessionControl.Release()
-			continue
-		}
-		sessionControl2 := (*wca.IAudioSessionControl2)(unsafe.Pointer(sessionControl2Dispatch))
-
-		var processId uint32
-		if err = sessionControl2.GetProcessId(&processId); err != nil {
-			sessionControl2Dispatch.Release()
-			sessionControl.Release()
-			continue
+	match := sessionMatcher(processName)
+	for _, session := range sessions {
+		if match(session) {
+			return session.Volume
 		}
-
-		currentProcessName := getProcessName(processId)
-
-		if strings.ToLower(currentProcessName) == processNameLower {
-			simpleVolumeDispatch, err := sessionControl2.QueryInterface(wca.IID_ISimpleAudioVolume)
-			if err != nil {
-				sessionControl2Dispatch.Release()
-				sessionControl.Release()
-				continue
-			}
-			simpleVolume := (*wca.ISimpleAudioVolume)(unsafe.Pointer(simpleVolumeDispatch))
-
-			var volumeScalar float32
-			if err = simpleVolume.GetMasterVolume(&volumeScalar); err != nil {
-				log.Printf("Error getting volume for %s: %v", processName, err)
-				simpleVolumeDispatch.Release()
-				sessionControl2Dispatch.Release()
-				sessionControl.Release()
-				continue
-			}
-
-			// Convert scalar (0.0-1.0) to percentage (0-100)
-			volumePercentage := int(volumeScalar * 100)
-
-			simpleVolumeDispatch.Release()
-			sessionControl2Dispatch.Release()
-			sessionControl.Release()
-
-			return volumePercentage
-		}
-
-		sessionControl2Dispatch.Release()
-		sessionControl.Release()
 	}
 
-	/*	if verbose {
-		log.Printf("Application %s not found or not playing audio", processName)
-	} */
 	return -1
 }
 
-func getProcessName(pid uint32) string {
-	return getProcessNameWindows(pid)
-}
-
-func getProcessNameWindows(pid uint32) string {
-	kernel32 := syscall.NewLazyDLL("kernel32.dll")
-	openProcess := kernel32.NewProc("OpenProcess")
-	queryFullProcessImageName := kernel32.NewProc("QueryFullProcessImageNameW")
-	closeHandle := kernel32.NewProc("CloseHandle")
-
-	handle, _, _ := openProcess.Call(
-		0x1000, // PROCESS_QUERY_LIMITED_INFORMATION
-		0,
-		uintptr(pid),
-	)
-
-	if handle == 0 {
-		return ""
-	}
-	defer closeHandle.Call(handle)
-
-	var size uint32 = 260
-	buffer := make([]uint16, size)
-
-	ret, _, _ := queryFullProcessImageName.Call(
-		handle,
-		0,
-		uintptr(unsafe.Pointer(&buffer[0])),
-		uintptr(unsafe.Pointer(&size)),
-	)
-
-	if ret == 0 {
-		return ""
-	}
-
-	fullPath := syscall.UTF16ToString(buffer[:size])
-	parts := strings.Split(fullPath, "\\")
-	if len(parts) > 0 {
-		return parts[len(parts)-1]
+func sendKeyPress(combo keyCombo) {
+	if !keyboardReady {
+		if verbose {
+			fmt.Printf("[Key Press] Keyboard unavailable, skipped key: %s\n", combo)
+		}
+		return
 	}
 
-	return ""
-}
+	// kb holds the keys of one press at a time
+	keyboardMu.Lock()
+	defer keyboardMu.Unlock()
 
-func sendKeyPress(keyCode int) {
-	kb.SetKeys(keyCode)
+	kb.SetKeys(combo.Code)
+	kb.HasCTRL(combo.Ctrl)
+	kb.HasSHIFT(combo.Shift)
+	kb.HasALT(combo.Alt)
+	kb.HasALTGR(combo.AltGr)
+	kb.HasSuper(combo.Super)
 	err := kb.Launching()
 	if err != nil {
-		log.Printf("Error sending key press %d: %v", keyCode, err)
+		log.Printf("Error sending key press %s: %v", combo, err)
 	} else if verbose {
-		fmt.Printf("[Key Press] Sent key code: %d\n", keyCode)
+		fmt.Printf("[Key Press] Sent key: %s\n", combo)
 	}
 	time.Sleep(50 * time.Millisecond)
 	kb.Clear()
@@ -1075,7 +669,7 @@ func processMessages(msgChan <-chan ArduinoMessage) {
 	}
 }
 
-func handleUserInput(port io.ReadWriteCloser) {
+func handleUserInput(conn *serialConnection) {
 	reader := bufio.NewReader(os.Stdin)
 
 	for {
@@ -1103,14 +697,43 @@ func handleUserInput(port io.ReadWriteCloser) {
 					fmt.Println("Invalid parameters. Usage: set <slider> <percentage>")
 					continue
 				}
-				sendCommand(port, fmt.Sprintf("SET:%d:%d", slider, percentage))
+				if err := conn.SetSlider(slider, percentage); err != nil {
+					fmt.Printf("Failed to move slider %d: %v\n", slider, err)
+					continue
+				}
 				parseArduinoData(fmt.Sprintf("s%dv%d", slider, percentage))
 			} else {
 				fmt.Println("Usage: set <slider> <percentage>")
 			}
 
 		case "ping":
-			sendCommand(port, "PING")
+			if err := conn.Ping(); err != nil {
+				fmt.Printf("Failed to send PING: %v\n", err)
+			}
+
+		case "status":
+			fmt.Printf("Arduino on %s: %s (%s protocol)\n", conn.PortPath(), conn.State(), conn.Protocol())
+			fmt.Printf("Profile %s, %s layer\n", profileName(currentConfig().profile(profiles.Active())), layerName(currentConfig().layer(layers.Active())))
+
+		case "profile":
+			// profile gaming (switch to a profile), or profile (list them)
+			if len(parts) == 1 {
+				active := profileName(currentConfig().profile(profiles.Active()))
+				for _, name := range currentConfig().profileNames() {
+					marker := " "
+					if name == active {
+						marker = "*"
+					}
+					fmt.Printf(" %s %s\n", marker, name)
+				}
+				continue
+			}
+			if err := switchProfile(strings.Join(parts[1:], " ")); err != nil {
+				fmt.Println(err)
+			}
+
+		case "devices":
+			printDevices()
 
 		case "help":
 			printHelp()
@@ -1126,39 +749,42 @@ func handleUserInput(port io.ReadWriteCloser) {
 	}
 }
 
-func sendCommand(port io.ReadWriteCloser, command string) {
-	for {
-		if !sendingImage {
-			break
-		}
-	}
-
-	message := command + "\n"
-	n, err := port.Write([]byte(message))
+// Image Sender Code
+func handleImageRequest(conn *serialConnection, forceNew bool) {
+	trackInfo, err := getCurrentTrackArtwork()
 	if err != nil {
-		log.Printf("Error sending command: %v", err)
-		return
+		log.Printf("Error reading track info: %v", err)
 	}
-
 	if verbose {
-		log.Printf("[Sent %d bytes] %s\n", n, strings.TrimSpace(command))
+		log.Println("Got Track Data")
+	}
+
+	if trackInfo.Name != lastTrackInfo.Name || forceNew {
+		handleImageSend(conn, trackInfo)
+		lastTrackInfo = trackInfo
+	} else {
+		if verbose {
+			log.Println("Track Data was the Same")
+		}
+		if err := conn.SendNoImage(); err != nil {
+			log.Printf("Error sending NIL: %v", err)
+		}
 	}
 }
 
-// Image Sender Code
-func handleImageSend(port io.ReadWriteCloser, trackInfo TrackInfo) {
-	err := sendImage(port)
+func handleImageSend(conn *serialConnection, trackInfo TrackInfo) {
+	width, height := conn.ImageSize()
+	rgb565Data, err := loadImage(width, height)
 	if err != nil {
-		log.Printf("Error sending image: %v", err)
-	} else {
-		log.Println("Image sent successfully!")
+		log.Printf("Error loading image: %v", err)
 	}
 
 	title, artist := processTrackInfo(trackInfo.Name, trackInfo.Artist)
-	serialMessage := title + "\t" + artist + "\n"
-	_, err = port.Write([]byte(serialMessage))
+	err = conn.SendArtwork(rgb565Data, width, height, title, artist)
 	if err != nil {
 		log.Printf("Error sending image: %v", err)
+	} else if rgb565Data != nil {
+		log.Println("Image sent successfully!")
 	} else if verbose {
 		log.Println("Trackdata sent successfully!")
 	}
@@ -1204,30 +830,31 @@ func RemoveSpecialChars(s string) string {
 	return result
 }
 
-func sendImage(port io.ReadWriteCloser) error {
+// loadImage reads the artwork and converts it to RGB565 of the given size
+func loadImage(width int, height int) ([]byte, error) {
 	// Read the image file
 	if verbose {
 		log.Printf("Reading image from:  %s", imagePath)
 	}
 	imageData, err := os.ReadFile(imagePath)
 	if err != nil {
-		return fmt.Errorf("failed to read image file: %v", err)
+		return nil, fmt.Errorf("failed to read image file: %v", err)
 	}
 
 	// Decode image
 	img, format, err := image.Decode(bytes.NewReader(imageData))
 	if err != nil {
-		return fmt.Errorf("failed to decode image: %v", err)
+		return nil, fmt.Errorf("failed to decode image: %v", err)
 	}
 	if verbose {
 		log.Printf("Decoded %s image:  %dx%d", format, img.Bounds().Dx(), img.Bounds().Dy())
 	}
 
-	// Resize image
+	// Resize image to what the board asked for
 	if verbose {
-		log.Printf("Resizing to %dx%d.. .", TARGET_WIDTH, TARGET_HEIGHT)
+		log.Printf("Resizing to %dx%d.. .", width, height)
 	}
-	resized := resize.Resize(TARGET_WIDTH, TARGET_HEIGHT, img, resize.Lanczos3)
+	resized := resize.Resize(uint(width), uint(height), img, resize.Lanczos3)
 
 	// Convert to RGB565 data
 	rgb565Data := imageToRGB565(resized)
@@ -1235,41 +862,7 @@ func sendImage(port io.ReadWriteCloser) error {
 		log.Printf("RGB565 data size:  %d bytes", len(rgb565Data))
 	}
 
-	// Send header: "IMG\n"
-	header := []byte{'I', 'M', 'G', '\n'}
-	if verbose {
-		log.Println("Sending header...")
-	}
-	_, err = port.Write(header)
-	if err != nil {
-		return fmt.Errorf("failed to write header: %v", err)
-	}
-
-	// Send size as 4 bytes
-	size := uint32(len(rgb565Data))
-	sizeBytes := []byte{byte(size >> 24), byte(size >> 16), byte(size >> 8), byte(size)}
-	if verbose {
-		log.Println("Sending size...")
-	}
-
-	_, err = port.Write(sizeBytes)
-	if err != nil {
-		return fmt.Errorf("failed to write size: %v", err)
-	}
-
-	if verbose {
-		log.Println("Sending Image data")
-	}
-
-	_, err = port.Write(rgb565Data)
-	if err != nil {
-		return fmt.Errorf("Failed to Send the Image Data: %v", err)
-	}
-	if verbose {
-		log.Println("All Data sent")
-	}
-
-	return nil
+	return rgb565Data, nil
 }
 
 func imageToRGB565(img image.Image) []byte {
@@ -1382,16 +975,52 @@ func getCurrentTrackArtwork() (TrackInfo, error) {
 	return trackInfo, nil
 }
 
+// printDevices lists the output and input devices by the names device: targets match
+func printDevices() {
+	devices, err := audio.Devices()
+	if err != nil {
+		fmt.Printf("Failed to list audio devices: %v\n", err)
+		return
+	}
+
+	for _, capture := range []bool{false, true} {
+		if capture {
+			fmt.Println("Input devices:")
+		} else {
+			fmt.Println("Output devices:")
+		}
+		for _, device := range devices {
+			if device.Capture != capture {
+				continue
+			}
+			marker := " "
+			if device.Default {
+				marker = "*"
+			}
+			fmt.Printf(" %s %s (%d%%)\n", marker, device.Name, device.Volume)
+		}
+	}
+	fmt.Println("Bind one with device:<name>, or part of the name; * marks the defaults")
+}
+
 func printHelp() {
 	fmt.Println("\n=== Available Commands ===")
 	fmt.Println("  set <slider> <percentage>  - Set specific slider to percentage")
 	fmt.Println("  ping                       - Ping Arduino")
+	fmt.Println("  status                     - Show connection status")
+	fmt.Println("  profile [name]             - Switch to a profile, or list them")
+	fmt.Println("  devices                    - List audio devices, to use as device: targets")
 	fmt.Println("  help                       - Show this help")
 	fmt.Println("  quit/exit/q                - Exit program")
-	fmt.Println("\nSlider Mapping:")
-	for _, num := range sliderMapping {
-		targets := getSliderTargets(num)
-		fmt.Printf("  Slider %d -> %s\n", num, strings.Join(targets, ", "))
+	fmt.Printf("\nSlider Mapping (profile %s, %s layer):\n", profileName(currentConfig().profile(profiles.Active())), layerName(currentConfig().layer(layers.Active())))
+	targets := currentConfig().activeSliderTargets()
+	numbers := make([]int, 0, len(targets))
+	for num := range targets {
+		numbers = append(numbers, num)
+	}
+	sort.Ints(numbers)
+	for _, num := range numbers {
+		fmt.Printf("  Slider %d -> %s\n", num, strings.Join(targets[num], ", "))
 	}
 	fmt.Println("========================")
 }
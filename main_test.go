@@ -0,0 +1,352 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// useFakeAudio makes a fresh fakeBackend the audio backend for the rest of the test,
+// with a volume writer that hasn't written anything to it yet
+func useFakeAudio(t testing.TB, sessions ...AudioSession) *fakeBackend {
+	t.Helper()
+
+	previous, previousWriter := audio, volumeWriter
+	fake := newFakeBackend()
+	for _, session := range sessions {
+		fake.AddSession(session)
+	}
+	audio = fake
+	volumeWriter = &volumeWriterState{targets: make(map[string]*targetVolume)}
+	t.Cleanup(func() { audio, volumeWriter = previous, previousWriter })
+	return fake
+}
+
+// useConfig makes config.yaml contents the config in effect for the rest of the test
+func useConfig(t testing.TB, contents string) *deejConfig {
+	t.Helper()
+
+	dir, err := ioutil.TempDir("", "deej")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	path := filepath.Join(dir, "config.yaml")
+	if err := ioutil.WriteFile(path, []byte(contents), 0644); err != nil {
+		t.Fatal(err)
+	}
+	v := viper.New()
+	v.SetConfigFile(path)
+	if err := v.ReadInConfig(); err != nil {
+		t.Fatal(err)
+	}
+	config, err := loadConfig(v)
+	if err != nil {
+		t.Fatalf("config doesn't load: %v", err)
+	}
+
+	previous, _ := activeConfig.Load().(*deejConfig)
+	applyConfig(config)
+	t.Cleanup(func() {
+		if previous != nil {
+			applyConfig(previous)
+		}
+	})
+	return config
+}
+
+// waitForVolumeWriter waits until every target's worker has set the last volume asked for
+func waitForVolumeWriter(t testing.TB) {
+	t.Helper()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		volumeWriter.mu.Lock()
+		busy := false
+		for _, state := range volumeWriter.targets {
+			busy = busy || state.running
+		}
+		volumeWriter.mu.Unlock()
+
+		if !busy {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatal("volume writer still busy")
+}
+
+// sessionVolumes returns the volume of every session by process name
+func sessionVolumes(t *testing.T, fake *fakeBackend) map[string]int {
+	t.Helper()
+
+	sessions, err := fake.Sessions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	volumes := make(map[string]int)
+	for _, session := range sessions {
+		volumes[session.ProcessName] = session.Volume
+	}
+	return volumes
+}
+
+var testSessions = []AudioSession{
+	{ProcessName: "discord.exe", PID: 1, Volume: 50},
+	{ProcessName: "spotify.exe", PID: 2, Volume: 50},
+	{ProcessName: "chrome.exe", PID: 3, Volume: 50},
+	{ProcessName: "game.exe", PID: 4, Volume: 50},
+}
+
+func TestSliderMovesSetTargetVolumes(t *testing.T) {
+	tests := []struct {
+		name       string
+		mapping    string
+		foreground string
+		line       string
+		want       map[string]int
+	}{
+		{
+			name:    "single app",
+			mapping: "0: discord.exe",
+			line:    "s0v20",
+			want:    map[string]int{"discord.exe": 20, "spotify.exe": 50, "chrome.exe": 50, "game.exe": 50},
+		},
+		{
+			name:    "group",
+			mapping: "0: [discord.exe, spotify.exe]",
+			line:    "s0v30",
+			want:    map[string]int{"discord.exe": 30, "spotify.exe": 30, "chrome.exe": 50, "game.exe": 50},
+		},
+		{
+			name:    "sliders of one line",
+			mapping: "0: discord.exe\n  1: spotify.exe",
+			line:    "s0v10|s1v90",
+			want:    map[string]int{"discord.exe": 10, "spotify.exe": 90, "chrome.exe": 50, "game.exe": 50},
+		},
+		{
+			name:       "current window",
+			mapping:    "0: deej.current",
+			foreground: "chrome.exe",
+			line:       "s0v70",
+			want:       map[string]int{"discord.exe": 50, "spotify.exe": 50, "chrome.exe": 70, "game.exe": 50},
+		},
+		{
+			name:    "unmapped apps",
+			mapping: "0: deej.unmapped\n  1: discord.exe",
+			line:    "s0v40",
+			want:    map[string]int{"discord.exe": 50, "spotify.exe": 40, "chrome.exe": 40, "game.exe": 40},
+		},
+		{
+			name:       "unmapped apps leave out the current window",
+			mapping:    "0: deej.unmapped\n  1: discord.exe",
+			foreground: "game.exe",
+			line:       "s0v40",
+			want:       map[string]int{"discord.exe": 50, "spotify.exe": 40, "chrome.exe": 40, "game.exe": 50},
+		},
+		{
+			name:    "unmapped apps leave out pattern targets",
+			mapping: "0: deej.unmapped\n  1: glob:*o*.exe",
+			line:    "s0v40",
+			want:    map[string]int{"discord.exe": 50, "spotify.exe": 50, "chrome.exe": 50, "game.exe": 40},
+		},
+		{
+			name:    "unmapped slider",
+			mapping: "0: discord.exe",
+			line:    "s1v0",
+			want:    map[string]int{"discord.exe": 50, "spotify.exe": 50, "chrome.exe": 50, "game.exe": 50},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fake := useFakeAudio(t, testSessions...)
+			fake.SetForeground(test.foreground)
+			useConfig(t, "slider_mapping:\n  "+test.mapping+"\n")
+
+			parseArduinoData(test.line)
+			waitForVolumeWriter(t)
+
+			got := sessionVolumes(t, fake)
+			for processName, want := range test.want {
+				if got[processName] != want {
+					t.Errorf("%s volume = %d, want %d (calls: %v)", processName, got[processName], want, fake.Calls())
+				}
+			}
+		})
+	}
+}
+
+func TestReadSliderVolume(t *testing.T) {
+	tests := []struct {
+		name       string
+		mapping    string
+		foreground string
+		volumes    map[string]int
+		sync       string // unmapped_sync
+		slider     int
+		want       int
+		wantOK     bool
+	}{
+		{
+			name:    "single app",
+			mapping: "0: discord.exe",
+			volumes: map[string]int{"discord.exe": 35},
+			want:    35,
+			wantOK:  true,
+		},
+		{
+			name:    "app not playing",
+			mapping: "0: teams.exe",
+			wantOK:  false,
+		},
+		{
+			name:    "group that agrees",
+			mapping: "0: [discord.exe, spotify.exe]",
+			volumes: map[string]int{"discord.exe": 60, "spotify.exe": 60},
+			want:    60,
+			wantOK:  true,
+		},
+		{
+			name:    "group that disagrees",
+			mapping: "0: [discord.exe, spotify.exe]",
+			volumes: map[string]int{"discord.exe": 60, "spotify.exe": 20},
+			wantOK:  false,
+		},
+		{
+			name:    "group with an app not playing doesn't agree",
+			mapping: "0: [discord.exe, teams.exe]",
+			volumes: map[string]int{"discord.exe": 60},
+			wantOK:  false,
+		},
+		{
+			name:       "current window",
+			mapping:    "0: deej.current",
+			foreground: "spotify.exe",
+			volumes:    map[string]int{"spotify.exe": 15},
+			want:       15,
+			wantOK:     true,
+		},
+		{
+			name:       "current window not playing",
+			mapping:    "0: deej.current",
+			foreground: "notepad.exe",
+			wantOK:     false,
+		},
+		{
+			name:    "unmapped apps follow the loudest",
+			mapping: "0: deej.unmapped\n  1: discord.exe",
+			volumes: map[string]int{"discord.exe": 100, "spotify.exe": 20, "chrome.exe": 30, "game.exe": 80},
+			sync:    unmappedSyncMax,
+			want:    80,
+			wantOK:  true,
+		},
+		{
+			name:    "unmapped apps follow the median",
+			mapping: "0: deej.unmapped\n  1: discord.exe",
+			volumes: map[string]int{"discord.exe": 100, "spotify.exe": 20, "chrome.exe": 30, "game.exe": 80},
+			sync:    unmappedSyncMedian,
+			want:    30,
+			wantOK:  true,
+		},
+		{
+			name:    "unmapped apps that disagree are skipped",
+			mapping: "0: deej.unmapped\n  1: discord.exe",
+			volumes: map[string]int{"discord.exe": 100, "spotify.exe": 20, "chrome.exe": 30, "game.exe": 80},
+			sync:    unmappedSyncSkip,
+			wantOK:  false,
+		},
+		{
+			name:       "unmapped apps without the current window",
+			mapping:    "0: deej.unmapped\n  1: discord.exe",
+			foreground: "game.exe",
+			volumes:    map[string]int{"discord.exe": 100, "spotify.exe": 20, "chrome.exe": 20, "game.exe": 80},
+			sync:       unmappedSyncSkip,
+			want:       20,
+			wantOK:     true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var sessions []AudioSession
+			for _, session := range testSessions {
+				if volume, playing := test.volumes[session.ProcessName]; playing {
+					session.Volume = volume
+					sessions = append(sessions, session)
+				}
+			}
+			fake := useFakeAudio(t, sessions...)
+			fake.SetForeground(test.foreground)
+
+			contents := "slider_mapping:\n  " + test.mapping + "\n"
+			if test.sync != "" {
+				contents += fmt.Sprintf("unmapped_sync: %s\n", test.sync)
+			}
+			useConfig(t, contents)
+
+			got, ok := readSliderVolume(test.slider)
+			if ok != test.wantOK || (ok && got != test.want) {
+				t.Errorf("readSliderVolume(%d) = %d, %t, want %d, %t", test.slider, got, ok, test.want, test.wantOK)
+			}
+		})
+	}
+}
+
+func TestExternalChangesAreReported(t *testing.T) {
+	fake := useFakeAudio(t, testSessions...)
+	useConfig(t, "slider_mapping:\n  0: discord.exe\n")
+
+	// Drain the changes of setting up the sessions
+	select {
+	case <-fake.Changes():
+	default:
+	}
+
+	fake.UpdateSession(1, func(session *AudioSession) { session.Volume = 5 })
+	select {
+	case <-fake.Changes():
+	case <-time.After(time.Second):
+		t.Fatal("no change reported")
+	}
+	if got, ok := readSliderVolume(0); !ok || got != 5 {
+		t.Errorf("readSliderVolume(0) = %d, %t, want 5, true", got, ok)
+	}
+	if calls := fake.Calls(); len(calls) != 0 {
+		t.Errorf("external change recorded calls: %v", calls)
+	}
+}
+
+func TestTitleTargetsAreNoticed(t *testing.T) {
+	tests := []struct {
+		name   string
+		config string
+		want   bool
+	}{
+		{"none", "slider_mapping:\n  0: discord.exe\n  1: glob:*.exe\n", false},
+		{"slider", "slider_mapping:\n  0: [discord.exe, 'title:*YouTube*']\n", true},
+		{"layer", "layers:\n  fn:\n    button: 5\n    slider_mapping:\n      0: 'Title: *Netflix*'\n", true},
+		{"button action", "button_mapping:\n  0: { action: mute_toggle, target: 'title:*Meet*' }\n", true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := useConfig(t, test.config).TitleTargets; got != test.want {
+				t.Errorf("TitleTargets = %t, want %t", got, test.want)
+			}
+		})
+	}
+}
+
+func TestUSBIDsAreNormalized(t *testing.T) {
+	config := useConfig(t, "usb_ids:\n  - \"0x2341\"\n  - \"403:6001\"\n  - '1A86:*'\n")
+	if want := []string{"2341", "0403:6001", "1a86"}; !equalStrings(config.USBIDs, want) {
+		t.Errorf("USBIDs = %v, want %v", config.USBIDs, want)
+	}
+}
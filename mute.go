@@ -0,0 +1,145 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+)
+
+// getTargetMute reports whether everything a target controls is muted. exists is
+// false when none of its devices or sessions are there.
+func getTargetMute(target string) (muted bool, exists bool) {
+	muted = true
+	if ids, ok, _ := targetDevices(target); ok {
+		for _, id := range ids {
+			device, err := audio.Device(id)
+			if err != nil {
+				continue
+			}
+			exists = true
+			muted = muted && device.Muted
+		}
+		return muted && exists, exists
+	}
+
+	match, err := targetSessions(target)
+	if err != nil {
+		return false, false
+	}
+	sessions, err := audio.Sessions()
+	if err != nil {
+		return false, false
+	}
+	for _, session := range sessions {
+		if match(session) {
+			exists = true
+			muted = muted && session.Muted
+		}
+	}
+	return muted && exists, exists
+}
+
+// setTargetMute mutes or unmutes everything a target controls
+func setTargetMute(target string, muted bool) error {
+	if ids, ok, err := targetDevices(target); ok {
+		if err != nil {
+			return err
+		}
+		for _, id := range ids {
+			if err := audio.SetDeviceMute(id, muted); err != nil {
+				return fmt.Errorf("failed to mute %s: %w", target, err)
+			}
+		}
+		return nil
+	}
+
+	match, err := targetSessions(target)
+	if err != nil {
+		return err
+	}
+	if _, err := audio.SetSessionMute(match, muted); err != nil {
+		return fmt.Errorf("failed to mute %s: %w", target, err)
+	}
+	return nil
+}
+
+// readSliderMute reports whether a slider's targets are muted: all of those that are
+// there, and at least one of them
+func readSliderMute(sliderNum int) bool {
+	muted, found := true, false
+	for _, target := range getSliderTargets(sliderNum) {
+		if targetMuted, exists := getTargetMute(target); exists {
+			found = true
+			muted = muted && targetMuted
+		}
+	}
+	return muted && found
+}
+
+// muteStates describes what is muted and which app is in front, which decides what
+// deej.current and deej.unmapped control. ok is false when it can't be read.
+func muteStates() (states string, ok bool) {
+	sessions, err := audio.Sessions()
+	if err != nil {
+		return "", false
+	}
+	devices, err := audio.Devices()
+	if err != nil {
+		return "", false
+	}
+
+	var b strings.Builder
+	processName, _ := getCurrentProcessName()
+	b.WriteString(processName)
+	for _, session := range sessions {
+		if session.Muted {
+			fmt.Fprintf(&b, "|%d:%s", session.PID, session.ProcessName)
+		}
+	}
+	for _, device := range devices {
+		if device.Muted {
+			fmt.Fprintf(&b, "|%s", device.ID)
+		}
+	}
+	return b.String(), true
+}
+
+// syncSliderMutes tells the board about every slider whose targets were muted or
+// unmuted since it last heard. Most changes are volumes, deej's own writes among them,
+// so the sliders are only read when muteStates differs from last; it returns the
+// states to pass next time.
+func syncSliderMutes(conn *serialConnection, last string) string {
+	states, ok := muteStates()
+	if ok && states == last {
+		return last
+	}
+	for sliderNum := range currentConfig().activeSliderTargets() {
+		syncSliderMute(conn, sliderNum, false)
+	}
+	return states
+}
+
+// syncSliderMute tells the board whether a slider's targets are muted, if that
+// changed or always is set
+func syncSliderMute(conn *serialConnection, sliderNum int, always bool) {
+	shown, ok := sliders.Muted(sliderNum)
+	if !ok {
+		return
+	}
+	muted := readSliderMute(sliderNum)
+	if muted == shown && !always {
+		return
+	}
+
+	if err := conn.SendMute(sliderNum, muted); err != nil {
+		if err != errNotConnected {
+			log.Printf("Error sending mute of slider %d: %v", sliderNum, err)
+		}
+		return
+	}
+	sliders.SetMuted(sliderNum, muted)
+
+	if verbose {
+		log.Printf("[Sync] Slider %d muted: %t\n", sliderNum, muted)
+	}
+}
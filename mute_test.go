@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestSliderMutesAreReadOnlyWhenMutesChange(t *testing.T) {
+	fake := useFakeAudio(t, testSessions...)
+	fake.SetForeground("game.exe")
+	useConfig(t, "slider_mapping:\n  0: discord.exe\n  1: [spotify.exe, chrome.exe]\n")
+	sliders.Resize(0)
+	sliders.Resize(2)
+	conn, port := useTestConnection(t, boardInfo{Version: protocolVersion})
+
+	steps := []struct {
+		name        string
+		change      func()
+		wantSent    []string // MUTE frames, as "slider:muted"
+		wantOnlyTwo bool     // only the sessions and devices were read
+	}{
+		{
+			name:        "volume change",
+			change:      func() { fake.UpdateSession(1, func(session *AudioSession) { session.Volume = 10 }) },
+			wantOnlyTwo: true,
+		},
+		{
+			name:     "app muted",
+			change:   func() { fake.UpdateSession(1, func(session *AudioSession) { session.Muted = true }) },
+			wantSent: []string{"0:1"},
+		},
+		{
+			name:        "another volume change",
+			change:      func() { fake.UpdateSession(3, func(session *AudioSession) { session.Volume = 90 }) },
+			wantOnlyTwo: true,
+		},
+		{
+			name:   "half a group muted",
+			change: func() { fake.UpdateSession(2, func(session *AudioSession) { session.Muted = true }) },
+		},
+		{
+			name:     "all of a group muted",
+			change:   func() { fake.UpdateSession(3, func(session *AudioSession) { session.Muted = true }) },
+			wantSent: []string{"1:1"},
+		},
+		{
+			name:     "app unmuted",
+			change:   func() { fake.UpdateSession(1, func(session *AudioSession) { session.Muted = false }) },
+			wantSent: []string{"0:0"},
+		},
+	}
+
+	// The first look reads every slider
+	mutes := syncSliderMutes(conn, "")
+	for _, step := range steps {
+		port.Reset()
+		step.change()
+		reads := fake.Reads()
+
+		mutes = syncSliderMutes(conn, mutes)
+
+		var sent []string
+		for _, f := range sentFrames(port, frameMute) {
+			sent = append(sent, fmt.Sprintf("%d:%d", f.Payload[0], f.Payload[1]))
+		}
+		if !reflect.DeepEqual(sent, step.wantSent) {
+			t.Errorf("%s: sent %v, want %v", step.name, sent, step.wantSent)
+		}
+		if read := fake.Reads() - reads; step.wantOnlyTwo && read != 2 {
+			t.Errorf("%s: %d reads, want the sessions and devices once each", step.name, read)
+		}
+	}
+}
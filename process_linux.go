@@ -0,0 +1,35 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// foregroundProcessName returns the process of the active window, as the foreground
+// source last reported it
+func foregroundProcessName() (string, error) {
+	if processName, ok := activeProcess.Load().(string); ok && processName != "" {
+		return processName, nil
+	}
+	return "", errors.New("the active window is not known, foreground window detection needs an X11 display")
+}
+
+// processNameOfPID returns the binary name of a process, as audio streams report it,
+// or "" if the process is gone
+func processNameOfPID(pid uint32) string {
+	if exe, err := os.Readlink(fmt.Sprintf("/proc/%d/exe", pid)); err == nil {
+		return filepath.Base(exe)
+	}
+	comm, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/comm", pid))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(comm))
+}
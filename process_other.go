@@ -0,0 +1,13 @@
+//go:build !windows && !linux
+// +build !windows,!linux
+
+package main
+
+import (
+	"fmt"
+	"runtime"
+)
+
+func foregroundProcessName() (string, error) {
+	return "", fmt.Errorf("foreground window detection is not supported on %s", runtime.GOOS)
+}
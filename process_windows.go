@@ -0,0 +1,96 @@
+//go:build windows
+// +build windows
+
+package main
+
+import (
+	"sync"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+var (
+	user32 = windows.NewLazySystemDLL("user32.dll")
+	psapi  = windows.NewLazySystemDLL("psapi.dll")
+
+	procGetForegroundWindow      = user32.NewProc("GetForegroundWindow")
+	procGetWindowThreadProcessId = user32.NewProc("GetWindowThreadProcessId")
+	procGetModuleBaseNameW       = psapi.NewProc("GetModuleBaseNameW")
+	procEnumWindows              = user32.NewProc("EnumWindows")
+	procIsWindowVisible          = user32.NewProc("IsWindowVisible")
+	procGetWindowTextW           = user32.NewProc("GetWindowTextW")
+
+	// Callbacks can't be freed, so there is one, filling titlesFound under titlesMu
+	enumWindowsCallback = syscall.NewCallback(collectWindowTitle)
+	titlesMu            sync.Mutex
+	titlesFound         map[uint32]string
+)
+
+func foregroundProcessName() (string, error) {
+	hwnd, _, err := procGetForegroundWindow.Call()
+	if hwnd == 0 {
+		return "", err
+	}
+	return windowProcessName(hwnd)
+}
+
+// windowProcessName returns the executable name of the process that owns a window
+func windowProcessName(hwnd uintptr) (string, error) {
+	var pid uint32
+	procGetWindowThreadProcessId.Call(hwnd, uintptr(unsafe.Pointer(&pid)))
+
+	handle, err := windows.OpenProcess(
+		windows.PROCESS_QUERY_INFORMATION|windows.PROCESS_VM_READ,
+		false,
+		pid,
+	)
+	if err != nil {
+		return "", err
+	}
+	defer windows.CloseHandle(handle)
+
+	buf := make([]uint16, windows.MAX_PATH)
+	ret, _, err := procGetModuleBaseNameW.Call(
+		uintptr(handle),
+		0,
+		uintptr(unsafe.Pointer(&buf[0])),
+		uintptr(len(buf)),
+	)
+	if ret == 0 {
+		return "", err
+	}
+
+	return syscall.UTF16ToString(buf), nil
+}
+
+// windowTitles returns the title of the first visible, titled window of every process
+// that has one
+func windowTitles() map[uint32]string {
+	titlesMu.Lock()
+	defer titlesMu.Unlock()
+
+	titlesFound = make(map[uint32]string)
+	procEnumWindows.Call(enumWindowsCallback, 0)
+	return titlesFound
+}
+
+func collectWindowTitle(hwnd uintptr, _ uintptr) uintptr {
+	if visible, _, _ := procIsWindowVisible.Call(hwnd); visible == 0 {
+		return 1
+	}
+
+	var pid uint32
+	procGetWindowThreadProcessId.Call(hwnd, uintptr(unsafe.Pointer(&pid)))
+	if _, exists := titlesFound[pid]; exists {
+		return 1
+	}
+
+	buf := make([]uint16, 256)
+	n, _, _ := procGetWindowTextW.Call(hwnd, uintptr(unsafe.Pointer(&buf[0])), uintptr(len(buf)))
+	if n > 0 {
+		titlesFound[pid] = syscall.UTF16ToString(buf[:n])
+	}
+	return 1 // keep enumerating
+}
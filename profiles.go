@@ -0,0 +1,175 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+)
+
+// defaultProfile is the name of the top-level slider and button mappings
+const defaultProfile = "default"
+
+// configProfile is a named set of mappings used instead of the top-level ones.
+// Mappings the profile leaves out are taken from the top level.
+type configProfile struct {
+	Name          string
+	SliderTargets map[int][]string       // nil if the profile has no slider_mapping
+	ButtonMapping map[int]*buttonBinding // nil if the profile has no button_mapping
+	AutoSwitch    []string               // foreground processes that switch to the profile
+}
+
+// profileState is which profile is active. Profiles are tracked by name, so the
+// active one survives config reloads that keep it.
+type profileState struct {
+	mu        sync.Mutex
+	active    string // "" for the top-level mappings
+	chosen    string // the profile last switched to by hand, which auto_switch goes back to
+	listeners []func(*configProfile)
+}
+
+var profiles = &profileState{}
+
+// Active returns the name of the active profile, or "" for the top-level mappings
+func (p *profileState) Active() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.active
+}
+
+// OnChange registers a callback for every profile change; it gets nil for the top-level mappings
+func (p *profileState) OnChange(listener func(*configProfile)) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.listeners = append(p.listeners, listener)
+}
+
+// set activates a profile and tells the listeners when that changes anything. Profiles
+// chosen by hand are remembered, so automatic switching can return to them.
+func (p *profileState) set(name string, byHand bool) {
+	p.mu.Lock()
+	if byHand {
+		p.chosen = name
+	}
+	if p.active == name {
+		p.mu.Unlock()
+		return
+	}
+	p.active = name
+	listeners := append([]func(*configProfile){}, p.listeners...)
+	p.mu.Unlock()
+
+	profile := currentConfig().profile(name)
+	log.Printf("[Profile] Switched to %s", profileName(profile))
+	for _, listener := range listeners {
+		listener(profile)
+	}
+}
+
+// forgetMissing goes back to the top-level mappings, without telling the listeners,
+// when a reloaded config no longer has the active or chosen profile
+func (p *profileState) forgetMissing(config *deejConfig) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.chosen != "" && config.profile(p.chosen) == nil {
+		p.chosen = ""
+	}
+	if p.active != "" && config.profile(p.active) == nil {
+		log.Printf("[Profile] Profile %s was removed, back to %s", p.active, defaultProfile)
+		p.active = p.chosen
+	}
+}
+
+// followForeground switches to the profile whose auto_switch lists the foreground
+// process, or back to the profile chosen by hand if none does
+func (p *profileState) followForeground(processName string) {
+	p.mu.Lock()
+	name := p.chosen
+	p.mu.Unlock()
+
+	for _, profile := range currentConfig().Profiles {
+		for _, process := range profile.AutoSwitch {
+			if strings.EqualFold(process, processName) {
+				name = profile.Name
+			}
+		}
+	}
+	p.set(name, false)
+}
+
+// switchProfile makes a named profile the active one, as chosen by hand
+func switchProfile(name string) error {
+	if strings.EqualFold(name, defaultProfile) {
+		profiles.set("", true)
+		return nil
+	}
+
+	profile := currentConfig().profile(name)
+	if profile == nil {
+		return fmt.Errorf("profile %q is not defined%s", name, suggest(name, currentConfig().profileNames()))
+	}
+	profiles.set(profile.Name, true)
+	return nil
+}
+
+// cycleProfiles switches to the profile after the active one in names
+func cycleProfiles(names []string) error {
+	active := profileName(currentConfig().profile(profiles.Active()))
+
+	next := names[0]
+	for i, name := range names {
+		if strings.EqualFold(name, active) {
+			next = names[(i+1)%len(names)]
+			break
+		}
+	}
+	return switchProfile(next)
+}
+
+func profileName(profile *configProfile) string {
+	if profile == nil {
+		return defaultProfile
+	}
+	return profile.Name
+}
+
+// profile returns the named profile, or nil if there is none
+func (c *deejConfig) profile(name string) *configProfile {
+	if name == "" {
+		return nil
+	}
+	for _, profile := range c.Profiles {
+		if strings.EqualFold(profile.Name, name) {
+			return profile
+		}
+	}
+	return nil
+}
+
+// profileNames returns every profile that can be switched to, the top-level mappings first
+func (c *deejConfig) profileNames() []string {
+	names := []string{defaultProfile}
+	for _, profile := range c.Profiles {
+		names = append(names, profile.Name)
+	}
+	return names
+}
+
+// profileSliderTargets returns the slider mapping of the active profile
+func (c *deejConfig) profileSliderTargets() map[int][]string {
+	if profile := c.profile(profiles.Active()); profile != nil && profile.SliderTargets != nil {
+		return profile.SliderTargets
+	}
+	return c.SliderTargets
+}
+
+// profileButtonMapping returns the button mapping of the active profile
+func (c *deejConfig) profileButtonMapping() map[int]*buttonBinding {
+	if profile := c.profile(profiles.Active()); profile != nil && profile.ButtonMapping != nil {
+		return profile.ButtonMapping
+	}
+	return c.ButtonMapping
+}
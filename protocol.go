@@ -0,0 +1,621 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"time"
+)
+
+// Framed protocol, negotiated with a HELLO handshake after the "Arduino ready" banner.
+// Boards that don't answer the handshake keep using the legacy newline text protocol.
+//
+//	SOF (0xA5) | LEN (uint16 LE) | TYPE | SEQ | PAYLOAD (LEN bytes) | CRC16 (uint16 LE)
+//
+// The CRC is CRC-16/CCITT-FALSE over LEN, TYPE, SEQ and PAYLOAD. Text lines from the
+// firmware are plain ASCII, so a 0xA5 byte always begins a frame, even halfway through
+// a line that lost bytes made out of what was left of a frame.
+const (
+	frameSOF        = 0xA5
+	frameHeaderSize = 4 // LEN, TYPE, SEQ
+	frameMaxPayload = 256
+	maxLineLength   = 256 // longer lines are noise rather than anything the board sends
+
+	protocolVersion = 1
+	helloTimeout    = 1500 * time.Millisecond
+
+	// The board only answers a SET once the motor reached its position
+	setAckTimeout  = 3 * time.Second
+	setMaxAttempts = 3
+)
+
+// Frame types. Replies echo the SEQ of the frame they answer.
+const (
+	frameHello        = 0x01 // host -> board: version
+	frameHelloAck     = 0x02 // board -> host: version, sliders, buttons, image width (uint16), image height (uint16), flags
+	frameInput        = 0x10 // board -> host: (kind 's'/'b', index, value) triples; buttons are 1 down, 0 up
+	frameSet          = 0x20 // host -> board: slider, percentage
+	frameAck          = 0x21 // board -> host: status, slider, percentage
+	framePing         = 0x30
+	framePong         = 0x31
+	frameImageRequest = 0x40 // board -> host: 1 if the screen has no artwork yet
+	frameImageBegin   = 0x41 // host -> board: width (uint16), height (uint16), size (uint32)
+	frameImageData    = 0x42 // host -> board: offset (uint32), RGB565 bytes
+	frameTrackInfo    = 0x43 // host -> board: "title\tartist"
+	frameNoImage      = 0x44 // host -> board: artwork unchanged
+	frameLayer        = 0x45 // host -> board: active layer number (0 for none), name
+	frameMute         = 0x46 // host -> board: slider, 1 if its targets are muted
+	frameError        = 0x7F // board -> host: error text
+)
+
+// Flags in frameHelloAck
+const (
+	boardFlagReleases = 0x01 // buttons report going up as well as down
+)
+
+// Status codes in frameAck
+const (
+	ackOK            = 0
+	ackInvalidParams = 1
+)
+
+type protocolMode int
+
+const (
+	protocolLegacy protocolMode = iota
+	protocolFramed
+)
+
+func (m protocolMode) String() string {
+	if m == protocolFramed {
+		return fmt.Sprintf("framed v%d", protocolVersion)
+	}
+	return "legacy text"
+}
+
+// boardInfo is what the board reported in its HELLO_ACK
+type boardInfo struct {
+	Version         int
+	Sliders         int
+	Buttons         int
+	ImageWidth      int
+	ImageHeight     int
+	ReportsReleases bool
+}
+
+// frame is a decoded protocol frame
+type frame struct {
+	Type    byte
+	Seq     byte
+	Payload []byte
+}
+
+var (
+	errBadFrame    = errors.New("bad frame")
+	errSetRejected = errors.New("board rejected SET")
+)
+
+// pendingSet is a SET waiting for the board's answer
+type pendingSet struct {
+	seq    byte
+	slider int
+	value  int
+	result chan error
+}
+
+// crc16 computes CRC-16/CCITT-FALSE
+func crc16(data []byte) uint16 {
+	crc := uint16(0xFFFF)
+	for _, b := range data {
+		crc ^= uint16(b) << 8
+		for i := 0; i < 8; i++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
+
+// encodeFrame builds a complete frame ready to be written to the port
+func encodeFrame(frameType byte, seq byte, payload []byte) []byte {
+	buf := make([]byte, 1+frameHeaderSize+len(payload)+2)
+	buf[0] = frameSOF
+	binary.LittleEndian.PutUint16(buf[1:], uint16(len(payload)))
+	buf[3] = frameType
+	buf[4] = seq
+	copy(buf[5:], payload)
+
+	crc := crc16(buf[1 : 5+len(payload)])
+	binary.LittleEndian.PutUint16(buf[5+len(payload):], crc)
+	return buf
+}
+
+// readFrame reads the rest of a frame after its SOF byte. A bad frame is left unread,
+// so a frame cut short by lost bytes doesn't take the start of the next one with it.
+func readFrame(reader *bufio.Reader) (frame, error) {
+	header, err := reader.Peek(frameHeaderSize)
+	if err != nil {
+		return frame{}, err
+	}
+
+	length := int(binary.LittleEndian.Uint16(header))
+	if length > frameMaxPayload {
+		return frame{}, fmt.Errorf("%w: length %d exceeds %d", errBadFrame, length, frameMaxPayload)
+	}
+
+	data, err := reader.Peek(frameHeaderSize + length + 2)
+	if err != nil {
+		return frame{}, err
+	}
+
+	end := frameHeaderSize + length
+	expected := binary.LittleEndian.Uint16(data[end:])
+	if crc := crc16(data[:end]); crc != expected {
+		return frame{}, fmt.Errorf("%w: checksum %04x, expected %04x", errBadFrame, crc, expected)
+	}
+
+	f := frame{Type: data[2], Seq: data[3], Payload: append([]byte(nil), data[frameHeaderSize:end]...)}
+	reader.Discard(len(data))
+	return f, nil
+}
+
+// serialMessage is one text line or frame read from the board, or the error that ended reading
+type serialMessage struct {
+	text  string
+	frame *frame
+	err   error
+}
+
+// readMessages reads text lines and frames from the port until it fails; the channel
+// is closed after the error. Once the board has answered HELLO, the only text it sends
+// is its banner after a reset, so anything else outside a frame is dropped, like the
+// remains of a frame that lost bytes.
+func readMessages(port io.Reader) <-chan serialMessage {
+	messages := make(chan serialMessage, 16)
+
+	go func() {
+		defer close(messages)
+
+		reader := bufio.NewReader(port)
+		var line []byte
+		framed := false // the board answered HELLO and hasn't been reset since
+		overlong := 0   // bytes of the current line past maxLineLength, which is dropped
+		dropLine := func(reason string) {
+			if verbose {
+				log.Printf("[Protocol] Dropped %d bytes %s", len(line)+overlong, reason)
+			}
+			line = line[:0]
+			overlong = 0
+		}
+
+		for {
+			b, err := reader.ReadByte()
+			if err != nil {
+				messages <- serialMessage{err: err}
+				return
+			}
+
+			switch {
+			case b == frameSOF:
+				if len(line) > 0 || overlong > 0 {
+					dropLine("before a frame")
+				}
+				f, err := readFrame(reader)
+				if errors.Is(err, errBadFrame) {
+					// Drop it and look for the next SOF
+					if verbose {
+						log.Printf("[Protocol] Dropped frame: %v", err)
+					}
+					continue
+				}
+				if err != nil {
+					messages <- serialMessage{err: err}
+					return
+				}
+				if f.Type == frameHelloAck {
+					_, err := parseHelloAck(f.Payload)
+					framed = err == nil
+				}
+				messages <- serialMessage{frame: &f}
+			case b == '\n':
+				text := strings.TrimSpace(string(line))
+				switch {
+				case overlong > 0:
+					dropLine("of an overlong line")
+					continue
+				case framed && !strings.HasSuffix(text, "Arduino ready"):
+					dropLine("outside a frame")
+					continue
+				case framed:
+					// The board was reset and speaks text again; noise from the reset
+					// may come before the banner
+					text = "Arduino ready"
+					framed = false
+				}
+				messages <- serialMessage{text: text}
+				line = line[:0]
+			case len(line) >= maxLineLength:
+				overlong++
+			default:
+				line = append(line, b)
+			}
+		}
+	}()
+
+	return messages
+}
+
+// drainMessages discards whatever is left once nobody reads the port anymore
+func drainMessages(messages <-chan serialMessage) {
+	go func() {
+		for range messages {
+		}
+	}()
+}
+
+// negotiateProtocol sends HELLO and waits for the board's HELLO_ACK. Legacy firmware
+// answers with an ERROR:UNKNOWN_CMD line or not at all, which means text protocol.
+func negotiateProtocol(port io.Writer, messages <-chan serialMessage) (protocolMode, boardInfo, error) {
+	hello := encodeFrame(frameHello, 0, []byte{protocolVersion})
+	// The newline ends the garbage line quickly on firmware that only reads text
+	if _, err := port.Write(append(hello, '\n')); err != nil {
+		return protocolLegacy, boardInfo{}, err
+	}
+
+	deadline := time.After(helloTimeout)
+	for {
+		select {
+		case message := <-messages:
+			if message.err != nil {
+				return protocolLegacy, boardInfo{}, message.err
+			}
+			if message.frame == nil || message.frame.Type != frameHelloAck {
+				continue
+			}
+
+			info, err := parseHelloAck(message.frame.Payload)
+			if err != nil {
+				log.Printf("[Protocol] Invalid HELLO_ACK, falling back to text protocol: %v", err)
+				return protocolLegacy, boardInfo{}, nil
+			}
+			return protocolFramed, info, nil
+		case <-deadline:
+			return protocolLegacy, boardInfo{}, nil
+		}
+	}
+}
+
+func parseHelloAck(payload []byte) (boardInfo, error) {
+	if len(payload) < 7 {
+		return boardInfo{}, fmt.Errorf("payload is %d bytes, expected 7", len(payload))
+	}
+
+	info := boardInfo{
+		Version:     int(payload[0]),
+		Sliders:     int(payload[1]),
+		Buttons:     int(payload[2]),
+		ImageWidth:  int(binary.LittleEndian.Uint16(payload[3:])),
+		ImageHeight: int(binary.LittleEndian.Uint16(payload[5:])),
+	}
+	// The flags byte came later; boards without it only report button presses
+	if len(payload) > 7 {
+		info.ReportsReleases = payload[7]&boardFlagReleases != 0
+	}
+	if info.Version != protocolVersion {
+		return info, fmt.Errorf("board speaks version %d, expected %d", info.Version, protocolVersion)
+	}
+	return info, nil
+}
+
+// frameText turns a frame from the board into the equivalent legacy text line, so
+// both protocols share one message handler. Frames with no text equivalent give "".
+func frameText(f frame) string {
+	switch f.Type {
+	case frameInput:
+		var parts []string
+		for i := 0; i+2 < len(f.Payload); i += 3 {
+			kind := f.Payload[i]
+			if kind != 's' && kind != 'b' {
+				continue
+			}
+			parts = append(parts, fmt.Sprintf("%c%dv%d", kind, f.Payload[i+1], f.Payload[i+2]))
+		}
+		return strings.Join(parts, "|")
+	case frameAck:
+		if len(f.Payload) < 3 || f.Payload[0] != ackOK {
+			return "ERROR:INVALID_PARAMS"
+		}
+		return fmt.Sprintf("OK:SET:%d:%d", f.Payload[1], f.Payload[2])
+	case framePong:
+		return "PONG"
+	case frameImageRequest:
+		if len(f.Payload) > 0 && f.Payload[0] == 1 {
+			return "REQ:NEW"
+		}
+		return "REQ"
+	case frameError:
+		return "ERROR:" + string(f.Payload)
+	}
+	return ""
+}
+
+// handshake negotiates the protocol on a freshly opened port, or after the board was reset
+func (c *serialConnection) handshake(port io.Writer, messages <-chan serialMessage) error {
+	mode, info, err := negotiateProtocol(port, messages)
+	if err != nil {
+		return fmt.Errorf("protocol handshake failed: %w", err)
+	}
+
+	c.mu.Lock()
+	c.protocol = mode
+	c.board = info
+	c.mu.Unlock()
+	buttons.SetReportsReleases(info.ReportsReleases)
+
+	if mode == protocolFramed {
+		log.Printf("[Protocol] Using %s: %d sliders, %d buttons, %dx%d artwork",
+			mode, info.Sliders, info.Buttons, info.ImageWidth, info.ImageHeight)
+		c.warnBoardCounts()
+	} else {
+		log.Printf("[Protocol] No answer to HELLO, using %s protocol", mode)
+	}
+	return nil
+}
+
+// warnBoardCounts logs sliders and buttons that are mapped but missing on the board.
+// Only the framed protocol reports the counts.
+func (c *serialConnection) warnBoardCounts() {
+	c.mu.Lock()
+	mode, info := c.protocol, c.board
+	c.mu.Unlock()
+
+	if mode != protocolFramed {
+		return
+	}
+	for _, problem := range checkBoardCounts(currentConfig(), info) {
+		log.Printf("[Config] %s", formatProblem(configName+"."+configType, problem))
+	}
+}
+
+// Protocol returns the protocol negotiated with the board
+func (c *serialConnection) Protocol() protocolMode {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.protocol
+}
+
+// ImageSize returns the artwork size the board asked for, or the legacy default
+func (c *serialConnection) ImageSize() (width int, height int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.protocol == protocolFramed && c.board.ImageWidth > 0 && c.board.ImageHeight > 0 {
+		return c.board.ImageWidth, c.board.ImageHeight
+	}
+	return TARGET_WIDTH, TARGET_HEIGHT
+}
+
+func (c *serialConnection) nextSeq() byte {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.seq++
+	return c.seq
+}
+
+// sendCommand sends a command in the negotiated protocol; text is its legacy form
+func (c *serialConnection) sendCommand(text string, frameType byte, seq byte, payload []byte) error {
+	data := []byte(text + "\n")
+	if c.Protocol() == protocolFramed {
+		data = encodeFrame(frameType, seq, payload)
+	}
+
+	n, err := c.Write(data)
+	if err != nil {
+		return err
+	}
+
+	if verbose {
+		log.Printf("[Sent %d bytes] %s\n", n, text)
+	}
+	return nil
+}
+
+// SetSlider moves a motor fader to percentage and waits until the board confirms the
+// move. A SET that times out or is answered with an error is sent again.
+func (c *serialConnection) SetSlider(slider int, percentage int) error {
+	var err error
+	for attempt := 1; attempt <= setMaxAttempts; attempt++ {
+		err = c.trySetSlider(slider, percentage)
+		if err == nil || errors.Is(err, errNotConnected) {
+			return err
+		}
+		if verbose {
+			log.Printf("[Protocol] SET:%d:%d attempt %d/%d failed: %v", slider, percentage, attempt, setMaxAttempts, err)
+		}
+	}
+	return fmt.Errorf("SET:%d:%d failed after %d attempts: %w", slider, percentage, setMaxAttempts, err)
+}
+
+func (c *serialConnection) trySetSlider(slider int, percentage int) error {
+	pending := &pendingSet{seq: c.nextSeq(), slider: slider, value: percentage, result: make(chan error, 1)}
+
+	c.mu.Lock()
+	c.pending = append(c.pending, pending)
+	c.mu.Unlock()
+	defer c.removePending(pending)
+
+	text := fmt.Sprintf("SET:%d:%d", slider, percentage)
+	if err := c.sendCommand(text, frameSet, pending.seq, []byte{byte(slider), byte(percentage)}); err != nil {
+		return err
+	}
+
+	select {
+	case err := <-pending.result:
+		return err
+	case <-time.After(setAckTimeout):
+		return fmt.Errorf("no answer within %s", setAckTimeout)
+	}
+}
+
+func (c *serialConnection) removePending(pending *pendingSet) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for i, p := range c.pending {
+		if p == pending {
+			c.pending = append(c.pending[:i], c.pending[i+1:]...)
+			return
+		}
+	}
+}
+
+// resolveSet hands an OK:SET or ERROR answer to the SET it belongs to. Frames carry the
+// SEQ of that SET; text answers go to the oldest SET with the same slider and value, or
+// for errors, which don't say what they refer to, to the oldest SET.
+func (c *serialConnection) resolveSet(message serialMessage, line string) {
+	var slider, value int
+	ok := strings.HasPrefix(line, "OK:SET:")
+	if ok {
+		if _, err := fmt.Sscanf(line, "OK:SET:%d:%d", &slider, &value); err != nil {
+			return
+		}
+	} else if line != "ERROR:INVALID_PARAMS" {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for i, pending := range c.pending {
+		var matches bool
+		switch {
+		case message.frame != nil:
+			matches = pending.seq == message.frame.Seq
+		case ok:
+			matches = pending.slider == slider && pending.value == value
+		default:
+			matches = true
+		}
+		if !matches {
+			continue
+		}
+
+		if ok {
+			pending.result <- nil
+		} else {
+			pending.result <- errSetRejected
+		}
+		c.pending = append(c.pending[:i], c.pending[i+1:]...)
+		return
+	}
+}
+
+// failPending fails every outstanding SET once the port is gone
+func (c *serialConnection) failPending() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, pending := range c.pending {
+		pending.result <- errNotConnected
+	}
+	c.pending = nil
+}
+
+// Ping asks the board for a PONG
+func (c *serialConnection) Ping() error {
+	return c.sendCommand("PING", framePing, c.nextSeq(), nil)
+}
+
+// SendArtwork answers a REQ with the RGB565 artwork and the title and artist shown under
+// it. Without rgb565, only the track info is sent. Control commands can go out between
+// frames, but not in the middle of a legacy transfer, which the board reads as one stream.
+func (c *serialConnection) SendArtwork(rgb565 []byte, width int, height int, title string, artist string) error {
+	text := title + "\t" + artist
+
+	if c.Protocol() != protocolFramed {
+		var chunks [][]byte
+		if rgb565 != nil {
+			size := uint32(len(rgb565))
+			header := []byte{'I', 'M', 'G', '\n', byte(size >> 24), byte(size >> 16), byte(size >> 8), byte(size)}
+			chunks = append(chunks, header, rgb565)
+		}
+		chunks = append(chunks, []byte(text+"\n"))
+		return c.writeBulk(chunks, true)
+	}
+
+	var frames [][]byte
+	if rgb565 != nil {
+		begin := make([]byte, 8)
+		binary.LittleEndian.PutUint16(begin[0:], uint16(width))
+		binary.LittleEndian.PutUint16(begin[2:], uint16(height))
+		binary.LittleEndian.PutUint32(begin[4:], uint32(len(rgb565)))
+		frames = append(frames, encodeFrame(frameImageBegin, c.nextSeq(), begin))
+
+		// The board draws a line at a time and only takes chunks of exactly one line of
+		// the width it asked for in HELLO_ACK
+		chunkSize := width * 2
+		if 4+chunkSize > frameMaxPayload {
+			return fmt.Errorf("failed to send artwork: a line %d pixels wide doesn't fit in a frame", width)
+		}
+
+		for offset := 0; offset < len(rgb565); offset += chunkSize {
+			end := offset + chunkSize
+			if end > len(rgb565) {
+				end = len(rgb565)
+			}
+
+			chunk := make([]byte, 4, 4+end-offset)
+			binary.LittleEndian.PutUint32(chunk, uint32(offset))
+			chunk = append(chunk, rgb565[offset:end]...)
+			frames = append(frames, encodeFrame(frameImageData, c.nextSeq(), chunk))
+		}
+	}
+
+	if len(text) > frameMaxPayload {
+		text = text[:frameMaxPayload]
+	}
+	frames = append(frames, encodeFrame(frameTrackInfo, c.nextSeq(), []byte(text)))
+
+	return c.writeBulk(frames, false)
+}
+
+// SendLayer tells the board which layer is active; number 0 with no name is the
+// base mapping. Boards that don't know the command answer with an error, which is ignored.
+func (c *serialConnection) SendLayer(number int, name string) error {
+	if len(name) > frameMaxPayload-1 {
+		name = name[:frameMaxPayload-1]
+	}
+	text := fmt.Sprintf("LAYER:%d:%s", number, name)
+	return c.sendCommand(text, frameLayer, c.nextSeq(), append([]byte{byte(number)}, name...))
+}
+
+// SendMute tells the board whether a slider's targets are muted, so it can show it.
+// Boards that don't know the command answer with an error, which is ignored.
+func (c *serialConnection) SendMute(slider int, muted bool) error {
+	value := 0
+	if muted {
+		value = 1
+	}
+	text := fmt.Sprintf("MUTE:%d:%d", slider, value)
+	return c.sendCommand(text, frameMute, c.nextSeq(), []byte{byte(slider), byte(value)})
+}
+
+// SendNoImage tells the board its artwork is still current
+func (c *serialConnection) SendNoImage() error {
+	data := []byte("NIL\n")
+	if c.Protocol() == protocolFramed {
+		data = encodeFrame(frameNoImage, c.nextSeq(), nil)
+	}
+
+	_, err := c.Write(data)
+	return err
+}
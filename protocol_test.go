@@ -0,0 +1,174 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/jacobsa/go-serial/serial"
+)
+
+// helloAck is the HELLO_ACK of a board with 5 sliders, 4 buttons and a 100x100 screen
+var helloAck = encodeFrame(frameHelloAck, 0, []byte{protocolVersion, 5, 4, 100, 0, 100, 0, boardFlagReleases})
+
+// stream joins text and frames into what the port would read
+func stream(parts ...interface{}) []byte {
+	var data []byte
+	for _, part := range parts {
+		switch part := part.(type) {
+		case string:
+			data = append(data, part...)
+		case []byte:
+			data = append(data, part...)
+		}
+	}
+	return data
+}
+
+func TestReadMessages(t *testing.T) {
+	input := encodeFrame(frameInput, 1, []byte{'s', 0, 42})
+	ping := encodeFrame(framePong, 2, nil)
+
+	tests := []struct {
+		name string
+		data []byte
+		want []string // text lines, and frames as "frame:<type>"
+	}{
+		{
+			name: "text protocol",
+			data: stream("Arduino ready\n", "s0v10|s1v20\n"),
+			want: []string{"Arduino ready", "s0v10|s1v20"},
+		},
+		{
+			name: "frames after HELLO_ACK",
+			data: stream(helloAck, input, ping),
+			want: []string{"frame:0x02", "frame:0x10", "frame:0x31"},
+		},
+		{
+			name: "SOF after the rest of a frame that lost its start",
+			data: stream(helloAck, input[3:], input),
+			want: []string{"frame:0x02", "frame:0x10"},
+		},
+		{
+			name: "SOF after the start of a frame that lost its end",
+			data: stream(helloAck, input[:4], input, ping),
+			want: []string{"frame:0x02", "frame:0x10", "frame:0x31"},
+		},
+		{
+			name: "text outside a frame once framed",
+			data: stream(helloAck, "noise\n", input),
+			want: []string{"frame:0x02", "frame:0x10"},
+		},
+		{
+			name: "reset banner after noise switches back to text",
+			data: stream(helloAck, "\x00\xffArduino ready\n", "s0v10\n"),
+			want: []string{"frame:0x02", "Arduino ready", "s0v10"},
+		},
+		{
+			name: "HELLO_ACK of another version leaves text on",
+			data: stream(encodeFrame(frameHelloAck, 0, []byte{protocolVersion + 1, 5, 4, 100, 0, 100, 0}), "ERROR:UNKNOWN_CMD\n"),
+			want: []string{"frame:0x02", "ERROR:UNKNOWN_CMD"},
+		},
+		{
+			name: "overlong line",
+			data: stream(strings.Repeat("x", maxLineLength+10), "\n", "s0v10\n"),
+			want: []string{"s0v10"},
+		},
+		{
+			name: "overlong line cut short by a frame",
+			data: stream(helloAck, strings.Repeat("x", maxLineLength+10), input),
+			want: []string{"frame:0x02", "frame:0x10"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var got []string
+			for message := range readMessages(bytes.NewReader(test.data)) {
+				switch {
+				case message.err != nil:
+				case message.frame != nil:
+					got = append(got, fmt.Sprintf("frame:%#02x", message.frame.Type))
+				default:
+					got = append(got, message.text)
+				}
+			}
+
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("messages = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+// bufferPort is a port that keeps what is written to it
+type bufferPort struct {
+	bytes.Buffer
+}
+
+func (p *bufferPort) Close() error { return nil }
+
+// useTestConnection returns a connection to a framed board that writes to a bufferPort
+func useTestConnection(t *testing.T, board boardInfo) (*serialConnection, *bufferPort) {
+	t.Helper()
+
+	port := &bufferPort{}
+	c := newSerialConnection(serial.OpenOptions{}, nil)
+	t.Cleanup(func() { close(c.stop) })
+	c.port = port
+	c.protocol = protocolFramed
+	c.board = board
+	return c, port
+}
+
+// sentFrames returns the frames of one type written to a bufferPort
+func sentFrames(port *bufferPort, frameType byte) []frame {
+	var frames []frame
+	for message := range readMessages(bytes.NewReader(port.Bytes())) {
+		if message.frame != nil && message.frame.Type == frameType {
+			frames = append(frames, *message.frame)
+		}
+	}
+	return frames
+}
+
+func TestSendArtworkChunksLines(t *testing.T) {
+	tests := []struct {
+		width, height int
+		wantErr       bool
+	}{
+		{width: 100, height: 100},
+		{width: 64, height: 48},
+		{width: 160, height: 128, wantErr: true}, // a line is more than a frame holds
+	}
+
+	for _, test := range tests {
+		t.Run(fmt.Sprintf("%dx%d", test.width, test.height), func(t *testing.T) {
+			c, port := useTestConnection(t, boardInfo{Version: protocolVersion, ImageWidth: test.width, ImageHeight: test.height})
+
+			width, height := c.ImageSize()
+			err := c.SendArtwork(make([]byte, width*height*2), width, height, "title", "artist")
+			if test.wantErr {
+				if err == nil {
+					t.Error("artwork sent in chunks the board can't take")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			chunks := sentFrames(port, frameImageData)
+			for _, chunk := range chunks {
+				if len(chunk.Payload) != 4+test.width*2 {
+					t.Errorf("chunk of %d bytes, want %d", len(chunk.Payload), 4+test.width*2)
+				}
+			}
+			if len(chunks) != test.height {
+				t.Errorf("%d chunks, want one per line, %d", len(chunks), test.height)
+			}
+		})
+	}
+}
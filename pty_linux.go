@@ -0,0 +1,66 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// openPseudoTerminal opens a raw pty pair and returns the master side plus the
+// path of the slave device, which can be used as com_port
+func openPseudoTerminal() (*os.File, string, error) {
+	master, err := os.OpenFile("/dev/ptmx", os.O_RDWR|unix.O_NOCTTY, 0)
+	if err != nil {
+		return nil, "", err
+	}
+	fd := int(master.Fd())
+
+	if err := unix.IoctlSetPointerInt(fd, unix.TIOCSPTLCK, 0); err != nil {
+		master.Close()
+		return nil, "", fmt.Errorf("failed to unlock pty: %w", err)
+	}
+
+	ptyNum, err := unix.IoctlGetInt(fd, unix.TIOCGPTN)
+	if err != nil {
+		master.Close()
+		return nil, "", fmt.Errorf("failed to get pty number: %w", err)
+	}
+
+	// Raw mode, so nothing is echoed back or translated before the host sets its own options
+	termios, err := unix.IoctlGetTermios(fd, unix.TCGETS)
+	if err != nil {
+		master.Close()
+		return nil, "", fmt.Errorf("failed to get pty attributes: %w", err)
+	}
+	termios.Iflag &^= unix.IGNBRK | unix.BRKINT | unix.PARMRK | unix.ISTRIP | unix.INLCR | unix.IGNCR | unix.ICRNL | unix.IXON
+	termios.Oflag &^= unix.OPOST
+	termios.Lflag &^= unix.ECHO | unix.ECHONL | unix.ICANON | unix.ISIG | unix.IEXTEN
+	termios.Cflag &^= unix.CSIZE | unix.PARENB
+	termios.Cflag |= unix.CS8
+	termios.Cc[unix.VMIN] = 1
+	termios.Cc[unix.VTIME] = 0
+	if err := unix.IoctlSetTermios(fd, unix.TCSETS, termios); err != nil {
+		master.Close()
+		return nil, "", fmt.Errorf("failed to set pty attributes: %w", err)
+	}
+
+	return master, fmt.Sprintf("/dev/pts/%d", ptyNum), nil
+}
+
+// pseudoTerminalPeerConnected reports whether the slave side is currently open
+func pseudoTerminalPeerConnected(master *os.File) bool {
+	fds := []unix.PollFd{{Fd: int32(master.Fd()), Events: unix.POLLIN}}
+	if _, err := unix.Poll(fds, 0); err != nil {
+		return false
+	}
+	return fds[0].Revents&unix.POLLHUP == 0
+}
+
+// flushPseudoTerminal drops anything written while the host was away
+func flushPseudoTerminal(master *os.File) {
+	unix.IoctlSetInt(int(master.Fd()), unix.TCFLSH, unix.TCIOFLUSH)
+}
@@ -0,0 +1,20 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+)
+
+func openPseudoTerminal() (*os.File, string, error) {
+	return nil, "", fmt.Errorf("the board simulator needs a Linux pseudo-terminal, not available on %s", runtime.GOOS)
+}
+
+func pseudoTerminalPeerConnected(master *os.File) bool {
+	return false
+}
+
+func flushPseudoTerminal(master *os.File) {}
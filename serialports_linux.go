@@ -0,0 +1,72 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const sysClassTTY = "/sys/class/tty"
+
+// listSerialPorts finds hardware serial devices through sysfs. USB devices get the
+// same idVendor/idProduct/manufacturer/product attributes udev matches on.
+func listSerialPorts() ([]serialPortInfo, error) {
+	entries, err := ioutil.ReadDir(sysClassTTY)
+	if err != nil {
+		return nil, err
+	}
+
+	var ports []serialPortInfo
+	for _, entry := range entries {
+		name := entry.Name()
+
+		// Virtual terminals have no backing device
+		devicePath, err := filepath.EvalSymlinks(filepath.Join(sysClassTTY, name, "device"))
+		if err != nil {
+			continue
+		}
+
+		// Built-in 8250 UARTs show up as ttyS0..31 whether or not anything is wired to them
+		if strings.HasPrefix(name, "ttyS") && !isUSBDevice(devicePath) {
+			continue
+		}
+
+		info := serialPortInfo{Path: "/dev/" + name}
+		if usbDevice := findUSBDevice(devicePath); usbDevice != "" {
+			info.VID = parseHexID(readSysfsAttribute(usbDevice, "idVendor"))
+			info.PID = parseHexID(readSysfsAttribute(usbDevice, "idProduct"))
+			info.Description = strings.TrimSpace(readSysfsAttribute(usbDevice, "manufacturer") + " " + readSysfsAttribute(usbDevice, "product"))
+		}
+		ports = append(ports, info)
+	}
+
+	sort.Slice(ports, func(i, j int) bool { return ports[i].Path < ports[j].Path })
+	return ports, nil
+}
+
+// findUSBDevice walks up from a tty's device (usually the USB interface) to the USB device itself
+func findUSBDevice(devicePath string) string {
+	for dir := devicePath; dir != "/" && dir != "."; dir = filepath.Dir(dir) {
+		if _, err := os.Stat(filepath.Join(dir, "idVendor")); err == nil {
+			return dir
+		}
+	}
+	return ""
+}
+
+func isUSBDevice(devicePath string) bool {
+	return findUSBDevice(devicePath) != ""
+}
+
+func readSysfsAttribute(dir string, name string) string {
+	data, err := ioutil.ReadFile(filepath.Join(dir, name))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
@@ -0,0 +1,13 @@
+//go:build !linux && !windows
+// +build !linux,!windows
+
+package main
+
+import (
+	"fmt"
+	"runtime"
+)
+
+func listSerialPorts() ([]serialPortInfo, error) {
+	return nil, fmt.Errorf("serial port discovery is not supported on %s", runtime.GOOS)
+}
@@ -0,0 +1,106 @@
+//go:build windows
+// +build windows
+
+package main
+
+import (
+	"sort"
+	"strings"
+
+	"golang.org/x/sys/windows/registry"
+)
+
+// listSerialPorts reads the COM ports from SERIALCOMM and looks up the USB VID/PID of
+// each one in the USB device tree under Enum\USB
+func listSerialPorts() ([]serialPortInfo, error) {
+	serialComm, err := registry.OpenKey(registry.LOCAL_MACHINE, `HARDWARE\DEVICEMAP\SERIALCOMM`, registry.QUERY_VALUE)
+	if err != nil {
+		if err == registry.ErrNotExist {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer serialComm.Close()
+
+	valueNames, err := serialComm.ReadValueNames(0)
+	if err != nil {
+		return nil, err
+	}
+
+	usbPorts := usbSerialPorts()
+
+	var ports []serialPortInfo
+	for _, valueName := range valueNames {
+		portName, _, err := serialComm.GetStringValue(valueName)
+		if err != nil {
+			continue
+		}
+
+		info, ok := usbPorts[strings.ToUpper(portName)]
+		if !ok {
+			info = serialPortInfo{}
+		}
+		info.Path = portName
+		ports = append(ports, info)
+	}
+
+	sort.Slice(ports, func(i, j int) bool { return ports[i].Path < ports[j].Path })
+	return ports, nil
+}
+
+// usbSerialPorts maps COM port names to the USB devices that created them
+func usbSerialPorts() map[string]serialPortInfo {
+	ports := make(map[string]serialPortInfo)
+
+	usb, err := registry.OpenKey(registry.LOCAL_MACHINE, `SYSTEM\CurrentControlSet\Enum\USB`, registry.ENUMERATE_SUB_KEYS)
+	if err != nil {
+		return ports
+	}
+	defer usb.Close()
+
+	deviceIDs, err := usb.ReadSubKeyNames(0)
+	if err != nil {
+		return ports
+	}
+
+	for _, deviceID := range deviceIDs {
+		// i.e. VID_2341&PID_8036 or VID_2341&PID_8036&MI_00
+		var vid, pid string
+		for _, part := range strings.Split(strings.ToUpper(deviceID), "&") {
+			if strings.HasPrefix(part, "VID_") {
+				vid = parseHexID(part[4:])
+			} else if strings.HasPrefix(part, "PID_") {
+				pid = parseHexID(part[4:])
+			}
+		}
+		if vid == "" {
+			continue
+		}
+
+		device, err := registry.OpenKey(usb, deviceID, registry.ENUMERATE_SUB_KEYS)
+		if err != nil {
+			continue
+		}
+		instances, _ := device.ReadSubKeyNames(0)
+
+		for _, instance := range instances {
+			instanceKey, err := registry.OpenKey(device, instance, registry.QUERY_VALUE|registry.ENUMERATE_SUB_KEYS)
+			if err != nil {
+				continue
+			}
+			description, _, _ := instanceKey.GetStringValue("FriendlyName")
+
+			parameters, err := registry.OpenKey(instanceKey, "Device Parameters", registry.QUERY_VALUE)
+			if err == nil {
+				if portName, _, err := parameters.GetStringValue("PortName"); err == nil {
+					ports[strings.ToUpper(portName)] = serialPortInfo{VID: vid, PID: pid, Description: description}
+				}
+				parameters.Close()
+			}
+			instanceKey.Close()
+		}
+		device.Close()
+	}
+
+	return ports
+}
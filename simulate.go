@@ -0,0 +1,738 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	simDefaultSliders       = 6
+	simDefaultButtons       = 6
+	simDefaultImageInterval = 10 * time.Second
+	simMaxImageSize         = 50000
+	simTapDuration          = 50 * time.Millisecond // how long `button` holds a button down
+)
+
+// simStep is one line of a simulator script: wait Delay, then run the action
+type simStep struct {
+	Delay  time.Duration
+	Action string
+	Args   []string
+	Line   int
+}
+
+// simBoard emulates the firmware in arduino/deej/deej.ino on the host side of a
+// pseudo-terminal, so the main program can be developed without the board
+type simBoard struct {
+	port   io.ReadWriter
+	legacy bool // ignore HELLO like firmware from before the framed protocol
+
+	writeMu sync.Mutex
+	seq     byte
+
+	mu            sync.Mutex
+	sliders       []int
+	muted         []bool // shown on the display for each slider
+	numButtons    int
+	framed        bool
+	imageOnScreen bool
+	awaitingImage bool
+	imageSize     int
+	imageReceived int
+	dropSets      int    // SETs left to ignore, to exercise the host's retries
+	layer         string // shown on the display, "" for the base mapping
+}
+
+// runSimulator implements `deej simulate`
+func runSimulator(args []string) error {
+	flags := flag.NewFlagSet("simulate", flag.ExitOnError)
+	scriptPath := flags.String("script", "", "File of timed slider moves and button presses to play back")
+	numSliders := flags.Int("sliders", simDefaultSliders, "Number of sliders the simulated board reports")
+	numButtons := flags.Int("buttons", simDefaultButtons, "Number of buttons the simulated board reports")
+	imageInterval := flags.Duration("image-interval", simDefaultImageInterval, "How often the board requests artwork (0 disables)")
+	legacy := flags.Bool("legacy", false, "Only speak the legacy text protocol, ignoring the HELLO handshake")
+	flags.Parse(args)
+
+	var script []simStep
+	if *scriptPath != "" {
+		var err error
+		script, err = loadSimScript(*scriptPath)
+		if err != nil {
+			return err
+		}
+	}
+
+	master, path, err := openPseudoTerminal()
+	if err != nil {
+		return fmt.Errorf("failed to open pseudo-terminal: %w", err)
+	}
+	defer master.Close()
+
+	board := &simBoard{
+		port:       master,
+		legacy:     *legacy,
+		sliders:    make([]int, *numSliders),
+		muted:      make([]bool, *numSliders),
+		numButtons: *numButtons,
+	}
+
+	fmt.Printf("Simulated board listening on %s\n", path)
+	fmt.Printf("Set com_port: %s in config.yaml and start deej\n", path)
+	fmt.Println("Type 'help' for simulator commands.")
+
+	board.println("Arduino ready")
+
+	go board.serve(master)
+	if *imageInterval > 0 {
+		go board.requestImages(*imageInterval)
+	}
+	if script != nil {
+		go board.runScript(script)
+	}
+
+	board.handleInput(os.Stdin)
+	return nil
+}
+
+// loadSimScript reads a script file. Each line is "<delay> <action> [args...]", the
+// delay being relative to the previous line, i.e.:
+//
+//	500ms slider 0 75
+//	1s    button 2
+//	1s    press 1
+//	800ms release 1
+//	0s    raw s0v10|b1v1
+func loadSimScript(path string) ([]simStep, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open script: %w", err)
+	}
+	defer file.Close()
+
+	var steps []simStep
+	scanner := bufio.NewScanner(file)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("%s:%d: expected \"<delay> <action> [args...]\"", path, lineNum)
+		}
+
+		delay, err := time.ParseDuration(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: invalid delay %q", path, lineNum, fields[0])
+		}
+
+		step := simStep{Delay: delay, Action: strings.ToLower(fields[1]), Args: fields[2:], Line: lineNum}
+		if err := validateSimAction(step.Action, step.Args); err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", path, lineNum, err)
+		}
+		steps = append(steps, step)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read script: %w", err)
+	}
+
+	return steps, nil
+}
+
+func validateSimAction(action string, args []string) error {
+	switch action {
+	case "slider":
+		if len(args) != 2 {
+			return fmt.Errorf("usage: slider <index> <percentage>")
+		}
+		if _, err := strconv.Atoi(args[0]); err != nil {
+			return fmt.Errorf("invalid slider index %q", args[0])
+		}
+		if _, err := strconv.Atoi(args[1]); err != nil {
+			return fmt.Errorf("invalid slider value %q", args[1])
+		}
+	case "button", "press", "release":
+		if len(args) != 1 {
+			return fmt.Errorf("usage: %s <index>", action)
+		}
+		if _, err := strconv.Atoi(args[0]); err != nil {
+			return fmt.Errorf("invalid button index %q", args[0])
+		}
+	case "raw":
+		if len(args) == 0 {
+			return fmt.Errorf("usage: raw <line>")
+		}
+	case "drop":
+		if len(args) != 1 {
+			return fmt.Errorf("usage: drop <count>")
+		}
+		if _, err := strconv.Atoi(args[0]); err != nil {
+			return fmt.Errorf("invalid count %q", args[0])
+		}
+	default:
+		return fmt.Errorf("unknown action %q", action)
+	}
+	return nil
+}
+
+func (b *simBoard) runScript(steps []simStep) {
+	for _, step := range steps {
+		time.Sleep(step.Delay)
+		if err := b.do(step.Action, step.Args); err != nil {
+			log.Printf("[Simulator] Script line %d: %v", step.Line, err)
+		}
+	}
+	fmt.Println("[Simulator] Script finished")
+}
+
+// do runs a single action. Actions are validated by validateSimAction.
+func (b *simBoard) do(action string, args []string) error {
+	if err := validateSimAction(action, args); err != nil {
+		return err
+	}
+
+	switch action {
+	case "slider":
+		slider, _ := strconv.Atoi(args[0])
+		value, _ := strconv.Atoi(args[1])
+		return b.moveSlider(slider, value)
+	case "button":
+		button, _ := strconv.Atoi(args[0])
+		if err := b.pressButton(button); err != nil {
+			return err
+		}
+		time.Sleep(simTapDuration)
+		return b.releaseButton(button)
+	case "press":
+		button, _ := strconv.Atoi(args[0])
+		return b.pressButton(button)
+	case "release":
+		button, _ := strconv.Atoi(args[0])
+		return b.releaseButton(button)
+	case "raw":
+		b.println(strings.Join(args, " "))
+	case "drop":
+		count, _ := strconv.Atoi(args[0])
+		b.mu.Lock()
+		b.dropSets = count
+		b.mu.Unlock()
+	}
+	return nil
+}
+
+// dropSet reports whether the next SET should go unanswered
+func (b *simBoard) dropSet() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.dropSets == 0 {
+		return false
+	}
+	b.dropSets--
+	fmt.Println("[Simulator] Ignoring SET")
+	return true
+}
+
+func (b *simBoard) moveSlider(slider int, value int) error {
+	b.mu.Lock()
+	if slider < 0 || slider >= len(b.sliders) {
+		b.mu.Unlock()
+		return fmt.Errorf("slider %d out of range (board has %d)", slider, len(b.sliders))
+	}
+	value = clampVolume(value)
+	b.sliders[slider] = value
+	b.imageOnScreen = false
+	b.mu.Unlock()
+
+	b.send(fmt.Sprintf("s%dv%d", slider, value), frameInput, []byte{'s', byte(slider), byte(value)})
+	return nil
+}
+
+func (b *simBoard) pressButton(button int) error {
+	if button < 0 || button >= b.numButtons {
+		return fmt.Errorf("button %d out of range (board has %d)", button, b.numButtons)
+	}
+
+	b.send(fmt.Sprintf("b%dv1", button), frameInput, []byte{'b', byte(button), 1})
+	return nil
+}
+
+// releaseButton reports a falling edge. Legacy firmware only reports presses.
+func (b *simBoard) releaseButton(button int) error {
+	if button < 0 || button >= b.numButtons {
+		return fmt.Errorf("button %d out of range (board has %d)", button, b.numButtons)
+	}
+	if b.legacy {
+		return nil
+	}
+
+	b.send(fmt.Sprintf("b%dv0", button), frameInput, []byte{'b', byte(button), 0})
+	return nil
+}
+
+func (b *simBoard) println(line string) {
+	b.writeMu.Lock()
+	defer b.writeMu.Unlock()
+
+	if _, err := b.port.Write([]byte(line + "\r\n")); err != nil {
+		log.Printf("[Simulator] Error writing to host: %v", err)
+		return
+	}
+	if verbose {
+		fmt.Printf("[Simulator] -> %s\n", line)
+	}
+}
+
+// send reports to the host in whichever protocol was negotiated; line is the text form
+func (b *simBoard) send(line string, frameType byte, payload []byte) {
+	b.mu.Lock()
+	framed := b.framed
+	b.mu.Unlock()
+
+	if !framed {
+		b.println(line)
+		return
+	}
+
+	b.writeMu.Lock()
+	b.seq++
+	seq := b.seq
+	b.writeMu.Unlock()
+	b.writeFrame(frameType, seq, payload, line)
+}
+
+// writeFrame sends a frame; description is what verbose output shows for it
+func (b *simBoard) writeFrame(frameType byte, seq byte, payload []byte, description string) {
+	b.writeMu.Lock()
+	defer b.writeMu.Unlock()
+
+	if _, err := b.port.Write(encodeFrame(frameType, seq, payload)); err != nil {
+		log.Printf("[Simulator] Error writing to host: %v", err)
+		return
+	}
+	if verbose {
+		fmt.Printf("[Simulator] -> [frame 0x%02x #%d] %s\n", frameType, seq, description)
+	}
+}
+
+// serve answers host commands. When the host closes the port, it waits for the
+// port to be opened again and announces itself like a freshly reset board.
+func (b *simBoard) serve(master *os.File) {
+	for {
+		err := b.readCommands(bufio.NewReader(b.port))
+		if verbose {
+			log.Printf("[Simulator] Host disconnected: %v", err)
+		}
+
+		for !pseudoTerminalPeerConnected(master) {
+			time.Sleep(100 * time.Millisecond)
+		}
+		flushPseudoTerminal(master)
+
+		b.mu.Lock()
+		b.framed = false
+		b.imageOnScreen = false
+		b.awaitingImage = false
+		b.mu.Unlock()
+
+		fmt.Println("[Simulator] Host connected")
+		b.println("Arduino ready")
+	}
+}
+
+func (b *simBoard) readCommands(reader *bufio.Reader) error {
+	for {
+		if !b.legacy {
+			next, err := reader.Peek(1)
+			if err != nil {
+				return err
+			}
+			if next[0] == frameSOF {
+				reader.ReadByte()
+				f, err := readFrame(reader)
+				if errors.Is(err, errBadFrame) {
+					log.Printf("[Simulator] Dropped frame: %v", err)
+					continue
+				}
+				if err != nil {
+					return err
+				}
+				b.handleFrame(f)
+				continue
+			}
+		}
+
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return err
+		}
+
+		command := strings.TrimSpace(line)
+
+		// Like handleHeader in the firmware, any reply other than IMG (NIL, a bare track line)
+		// means "no image"; commands are still handled while waiting
+		b.mu.Lock()
+		declined := b.awaitingImage && command != "IMG" && command != "PING" && !strings.HasPrefix(command, "SET:")
+		if declined {
+			b.awaitingImage = false
+		}
+		b.mu.Unlock()
+		if declined {
+			if verbose {
+				fmt.Printf("[Simulator] No artwork sent (%q)\n", command)
+			}
+			continue
+		}
+
+		if command == "" {
+			continue
+		}
+		if verbose {
+			fmt.Printf("[Simulator] <- %s\n", command)
+		}
+
+		if err := b.handleCommand(command, reader); err != nil {
+			return err
+		}
+	}
+}
+
+// handleCommand mirrors processSerialCommand in the firmware
+func (b *simBoard) handleCommand(command string, reader *bufio.Reader) error {
+	cmd := command
+	if i := strings.Index(command, ":"); i >= 0 {
+		cmd = command[:i]
+	}
+
+	switch cmd {
+	case "SET":
+		if b.dropSet() {
+			return nil
+		}
+
+		var slider, percentage int
+		n, err := fmt.Sscanf(command, "SET:%d:%d", &slider, &percentage)
+
+		b.mu.Lock()
+		valid := err == nil && n == 2 && slider >= 0 && slider < len(b.sliders) && percentage >= 0 && percentage <= 100
+		if valid {
+			b.sliders[slider] = percentage
+			b.imageOnScreen = false
+		}
+		b.mu.Unlock()
+
+		if !valid {
+			b.println("ERROR:INVALID_PARAMS")
+			return nil
+		}
+		fmt.Printf("[Simulator] Motor fader %d moved to %d%%\n", slider, percentage)
+		b.println(fmt.Sprintf("OK:SET:%d:%d", slider, percentage))
+
+	case "PING":
+		b.println("PONG")
+
+	case "LAYER":
+		// LAYER:number:name, where the name may contain colons
+		parts := strings.SplitN(command, ":", 3)
+		if len(parts) != 3 {
+			b.println("ERROR:INVALID_PARAMS")
+			return nil
+		}
+		b.showLayer(parts[2])
+
+	case "MUTE":
+		var slider, muted int
+		n, err := fmt.Sscanf(command, "MUTE:%d:%d", &slider, &muted)
+		if err != nil || n != 2 || !b.showMute(slider, muted == 1) {
+			b.println("ERROR:INVALID_PARAMS")
+		}
+
+	case "IMG":
+		return b.receiveImage(reader)
+
+	default:
+		b.println("ERROR:UNKNOWN_CMD:" + cmd)
+	}
+
+	return nil
+}
+
+// showLayer puts the active layer on the display, like drawLayerName in the firmware
+func (b *simBoard) showLayer(name string) {
+	b.mu.Lock()
+	changed := b.layer != name
+	b.layer = name
+	b.mu.Unlock()
+
+	if !changed {
+		return
+	}
+	if name == "" {
+		fmt.Println("[Simulator] Display shows no layer")
+	} else {
+		fmt.Printf("[Simulator] Display shows layer %s\n", name)
+	}
+}
+
+// showMute shows whether a slider's targets are muted, like drawMuteState in the
+// firmware. It returns false for sliders the board doesn't have.
+func (b *simBoard) showMute(slider int, muted bool) bool {
+	b.mu.Lock()
+	if slider < 0 || slider >= len(b.muted) {
+		b.mu.Unlock()
+		return false
+	}
+	changed := b.muted[slider] != muted
+	b.muted[slider] = muted
+	b.mu.Unlock()
+
+	if !changed {
+		return true
+	}
+	if muted {
+		fmt.Printf("[Simulator] Display shows slider %d muted\n", slider)
+	} else {
+		fmt.Printf("[Simulator] Display shows slider %d unmuted\n", slider)
+	}
+	return true
+}
+
+// handleFrame is the framed counterpart of handleCommand
+func (b *simBoard) handleFrame(f frame) {
+	if verbose {
+		fmt.Printf("[Simulator] <- [frame 0x%02x #%d] %d bytes\n", f.Type, f.Seq, len(f.Payload))
+	}
+
+	switch f.Type {
+	case frameHello:
+		b.mu.Lock()
+		b.framed = true
+		numSliders := len(b.sliders)
+		b.mu.Unlock()
+
+		info := []byte{protocolVersion, byte(numSliders), byte(b.numButtons), 0, 0, 0, 0, boardFlagReleases}
+		binary.LittleEndian.PutUint16(info[3:], TARGET_WIDTH)
+		binary.LittleEndian.PutUint16(info[5:], TARGET_HEIGHT)
+		fmt.Println("[Simulator] Switched to framed protocol")
+		b.writeFrame(frameHelloAck, f.Seq, info, "HELLO_ACK")
+
+	case frameSet:
+		if b.dropSet() {
+			return
+		}
+
+		status := byte(ackInvalidParams)
+		var slider, percentage byte
+		if len(f.Payload) == 2 {
+			slider, percentage = f.Payload[0], f.Payload[1]
+
+			b.mu.Lock()
+			if int(slider) < len(b.sliders) && percentage <= 100 {
+				b.sliders[slider] = int(percentage)
+				b.imageOnScreen = false
+				status = ackOK
+			}
+			b.mu.Unlock()
+		}
+
+		if status == ackOK {
+			fmt.Printf("[Simulator] Motor fader %d moved to %d%%\n", slider, percentage)
+		}
+		b.writeFrame(frameAck, f.Seq, []byte{status, slider, percentage}, fmt.Sprintf("ACK %d %d:%d", status, slider, percentage))
+
+	case framePing:
+		b.writeFrame(framePong, f.Seq, nil, "PONG")
+
+	case frameImageBegin:
+		if len(f.Payload) < 8 {
+			b.writeFrame(frameError, f.Seq, []byte("INVALID_PARAMS"), "ERROR:INVALID_PARAMS")
+			return
+		}
+		b.mu.Lock()
+		b.imageSize = int(binary.LittleEndian.Uint32(f.Payload[4:]))
+		b.imageReceived = 0
+		b.mu.Unlock()
+
+	case frameImageData:
+		// Like the firmware, only chunks of exactly one line are drawn
+		if len(f.Payload) != 4+TARGET_WIDTH*2 {
+			log.Printf("[Simulator] Ignored artwork chunk of %d bytes", len(f.Payload)-4)
+			return
+		}
+		b.mu.Lock()
+		b.imageReceived += len(f.Payload) - 4
+		b.mu.Unlock()
+
+	case frameTrackInfo:
+		title, artist := string(f.Payload), ""
+		if parts := strings.SplitN(title, "\t", 2); len(parts) == 2 {
+			title, artist = parts[0], parts[1]
+		}
+
+		b.mu.Lock()
+		size, received := b.imageSize, b.imageReceived
+		b.imageOnScreen = received > 0
+		b.awaitingImage = false
+		b.imageSize, b.imageReceived = 0, 0
+		b.mu.Unlock()
+
+		if received != size {
+			log.Printf("[Simulator] Received %d of %d artwork bytes", received, size)
+		}
+		fmt.Printf("[Simulator] Artwork received (%d bytes): %q by %q\n", received, title, artist)
+
+	case frameLayer:
+		if len(f.Payload) < 1 {
+			b.writeFrame(frameError, f.Seq, []byte("INVALID_PARAMS"), "ERROR:INVALID_PARAMS")
+			return
+		}
+		b.showLayer(string(f.Payload[1:]))
+
+	case frameMute:
+		if len(f.Payload) < 2 || !b.showMute(int(f.Payload[0]), f.Payload[1] == 1) {
+			b.writeFrame(frameError, f.Seq, []byte("INVALID_PARAMS"), "ERROR:INVALID_PARAMS")
+		}
+
+	case frameNoImage:
+		b.mu.Lock()
+		b.awaitingImage = false
+		b.mu.Unlock()
+		if verbose {
+			fmt.Println("[Simulator] No artwork sent (NIL)")
+		}
+
+	default:
+		b.writeFrame(frameError, f.Seq, []byte(fmt.Sprintf("UNKNOWN_FRAME:%02x", f.Type)), "ERROR:UNKNOWN_FRAME")
+	}
+}
+
+// receiveImage consumes the 4 byte size, the RGB565 pixels and the "title\tartist" line
+func (b *simBoard) receiveImage(reader *bufio.Reader) error {
+	sizeBytes := make([]byte, 4)
+	if _, err := io.ReadFull(reader, sizeBytes); err != nil {
+		return err
+	}
+	size := uint32(sizeBytes[0])<<24 | uint32(sizeBytes[1])<<16 | uint32(sizeBytes[2])<<8 | uint32(sizeBytes[3])
+
+	if size == 0 || size >= simMaxImageSize {
+		log.Printf("[Simulator] Invalid image size %d", size)
+		b.mu.Lock()
+		b.awaitingImage = false
+		b.mu.Unlock()
+		return nil
+	}
+
+	if _, err := io.CopyN(io.Discard, reader, int64(size)); err != nil {
+		return err
+	}
+
+	trackData, err := reader.ReadString('\n')
+	if err != nil {
+		return err
+	}
+	title, artist := trackData, ""
+	if parts := strings.SplitN(strings.TrimSpace(trackData), "\t", 2); len(parts) == 2 {
+		title, artist = parts[0], parts[1]
+	}
+
+	b.mu.Lock()
+	b.imageOnScreen = true
+	b.awaitingImage = false
+	b.mu.Unlock()
+
+	expected := TARGET_WIDTH * TARGET_HEIGHT * 2
+	if int(size) != expected {
+		log.Printf("[Simulator] Image is %d bytes, expected %d for %dx%d RGB565", size, expected, TARGET_WIDTH, TARGET_HEIGHT)
+	}
+	fmt.Printf("[Simulator] Artwork received (%d bytes): %q by %q\n", size, strings.TrimSpace(title), strings.TrimSpace(artist))
+	return nil
+}
+
+// requestImages sends REQ/REQ:NEW like the firmware does while idle
+func (b *simBoard) requestImages(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		b.mu.Lock()
+		if b.awaitingImage {
+			b.mu.Unlock()
+			continue
+		}
+		b.awaitingImage = true
+		request, isNew := "REQ:NEW", byte(1)
+		if b.imageOnScreen {
+			request, isNew = "REQ", 0
+		}
+		b.mu.Unlock()
+
+		b.send(request, frameImageRequest, []byte{isNew})
+	}
+}
+
+func (b *simBoard) handleInput(input io.Reader) {
+	reader := bufio.NewReader(input)
+
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		action := strings.ToLower(fields[0])
+		switch action {
+		case "help":
+			fmt.Println("\n=== Simulator Commands ===")
+			fmt.Println("  slider <index> <percentage> - Move a slider")
+			fmt.Println("  button <index>              - Press and release a button")
+			fmt.Println("  press/release <index>       - Push a button down or let it go")
+			fmt.Println("  raw <line>                  - Send a line to the host as-is")
+			fmt.Println("  drop <count>                - Leave the next SET commands unanswered")
+			fmt.Println("  status                      - Show protocol and slider positions")
+			fmt.Println("  quit/exit/q                 - Exit simulator")
+			fmt.Println("==========================")
+		case "status":
+			b.mu.Lock()
+			if b.framed {
+				fmt.Printf("  Protocol: framed v%d\n", protocolVersion)
+			} else {
+				fmt.Println("  Protocol: legacy text")
+			}
+			for i, value := range b.sliders {
+				if b.muted[i] {
+					fmt.Printf("  Slider %d: %d%% (muted)\n", i, value)
+				} else {
+					fmt.Printf("  Slider %d: %d%%\n", i, value)
+				}
+			}
+			if b.layer != "" {
+				fmt.Printf("  Layer: %s\n", b.layer)
+			}
+			b.mu.Unlock()
+		case "quit", "exit", "q":
+			return
+		default:
+			if err := b.do(action, fields[1:]); err != nil {
+				fmt.Println(err)
+			}
+		}
+	}
+}
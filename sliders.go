@@ -0,0 +1,102 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// sliderState is what deej last knew of the board's sliders: where each fader is,
+// whether its targets are muted, and when the user last moved or pressed anything.
+// The serial reader, the volume sync, the foreground follower and config reloads all
+// share it, so it is only read and written through its methods.
+type sliderState struct {
+	mu           sync.Mutex
+	positions    []int
+	mutes        []bool
+	lastActivity time.Time
+}
+
+var sliders = &sliderState{}
+
+// Resize makes room for numSliders, keeping what is already known
+func (s *sliderState) Resize(numSliders int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	positions := make([]int, numSliders)
+	copy(positions, s.positions)
+	s.positions = positions
+
+	mutes := make([]bool, numSliders)
+	copy(mutes, s.mutes)
+	s.mutes = mutes
+}
+
+// Count returns how many sliders are tracked
+func (s *sliderState) Count() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return len(s.positions)
+}
+
+// Position returns where a slider's fader was last seen, and false for sliders that
+// aren't tracked
+func (s *sliderState) Position(sliderNum int) (int, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if sliderNum < 0 || sliderNum >= len(s.positions) {
+		return 0, false
+	}
+	return s.positions[sliderNum], true
+}
+
+// SetPosition remembers where a slider's fader is; sliders that aren't tracked are
+// ignored
+func (s *sliderState) SetPosition(sliderNum int, position int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if sliderNum >= 0 && sliderNum < len(s.positions) {
+		s.positions[sliderNum] = position
+	}
+}
+
+// Muted returns whether the board was last told a slider's targets are muted, and
+// false for sliders that aren't tracked
+func (s *sliderState) Muted(sliderNum int) (muted bool, ok bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if sliderNum < 0 || sliderNum >= len(s.mutes) {
+		return false, false
+	}
+	return s.mutes[sliderNum], true
+}
+
+// SetMuted remembers what the board was told about a slider's mute
+func (s *sliderState) SetMuted(sliderNum int, muted bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if sliderNum >= 0 && sliderNum < len(s.mutes) {
+		s.mutes[sliderNum] = muted
+	}
+}
+
+// MarkActivity notes that the user just moved a slider or pressed a button
+func (s *sliderState) MarkActivity() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.lastActivity = time.Now()
+}
+
+// SinceActivity returns how long ago the user last moved or pressed anything
+func (s *sliderState) SinceActivity() time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return time.Since(s.lastActivity)
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"runtime"
+	"strings"
+	"sync"
+)
+
+// Prefixes of slider targets that match sessions by pattern instead of by exact
+// process name
+const (
+	globTargetPrefix  = "glob:"
+	regexTargetPrefix = "regex:"
+	titleTargetPrefix = "title:"
+	pathTargetPrefix  = "path:"
+)
+
+// sessionPattern is a compiled pattern target and the session detail it looks at
+type sessionPattern struct {
+	expr  *regexp.Regexp
+	field string // one of the target prefixes
+}
+
+// matches tells whether a session is one of the pattern's. title: looks at the window
+// title and at the name the application gave its audio, path: at the executable's
+// full path, with / and \ alike; the others look at the process name.
+func (p *sessionPattern) matches(session AudioSession) bool {
+	switch p.field {
+	case titleTargetPrefix:
+		return (session.WindowTitle != "" && p.expr.MatchString(session.WindowTitle)) ||
+			(session.DisplayName != "" && p.expr.MatchString(session.DisplayName))
+	case pathTargetPrefix:
+		return session.Path != "" && p.expr.MatchString(strings.Replace(session.Path, `\`, "/", -1))
+	}
+	return p.expr.MatchString(session.ProcessName)
+}
+
+// targetPatterns caches compiled patterns, as targets are matched on every slider move
+var targetPatterns sync.Map // target -> *sessionPattern
+
+// targetPattern compiles a pattern target into a case-insensitive pattern. ok is
+// false for targets without a pattern prefix.
+func targetPattern(target string) (pattern *sessionPattern, ok bool, err error) {
+	if cached, exists := targetPatterns.Load(target); exists {
+		return cached.(*sessionPattern), true, nil
+	}
+
+	var field string
+	for _, prefix := range []string{globTargetPrefix, regexTargetPrefix, titleTargetPrefix, pathTargetPrefix} {
+		if strings.HasPrefix(strings.ToLower(target), prefix) {
+			field = prefix
+		}
+	}
+	if field == "" {
+		return nil, false, nil
+	}
+
+	value := strings.TrimSpace(target[len(field):])
+	if value == "" {
+		return nil, true, fmt.Errorf("%q has no pattern after %s", target, field)
+	}
+
+	var expr string
+	switch field {
+	case regexTargetPrefix:
+		expr = value
+	case pathTargetPrefix:
+		expr = "^" + globToRegexp(strings.Replace(value, `\`, "/", -1), true) + "$"
+	default:
+		expr = "^" + globToRegexp(value, false) + "$"
+	}
+
+	if _, err := regexp.Compile(expr); err != nil {
+		return nil, true, fmt.Errorf("%q is not a valid pattern: %w", target, err)
+	}
+	pattern = &sessionPattern{expr: regexp.MustCompile("(?i)" + expr), field: field}
+	targetPatterns.Store(target, pattern)
+	return pattern, true, nil
+}
+
+// globToRegexp turns a glob into a regexp: * matches any run of characters, ? a
+// single one, and [...] a set, negated by a leading !. In paths, * and ? stop at
+// a / and ** crosses them.
+func globToRegexp(glob string, path bool) string {
+	anyRun, anyOne := ".*", "."
+	if path {
+		anyRun, anyOne = "[^/]*", "[^/]"
+	}
+
+	var expr strings.Builder
+	for i := 0; i < len(glob); i++ {
+		switch c := glob[i]; c {
+		case '*':
+			if path && strings.HasPrefix(glob[i:], "**") {
+				expr.WriteString(".*")
+				i++
+			} else {
+				expr.WriteString(anyRun)
+			}
+		case '?':
+			expr.WriteString(anyOne)
+		case '[':
+			end := strings.IndexByte(glob[i+1:], ']')
+			if end < 0 {
+				expr.WriteString(regexp.QuoteMeta(glob[i:]))
+				return expr.String()
+			}
+			set := glob[i+1 : i+1+end]
+			if strings.HasPrefix(set, "!") {
+				set = "^" + set[1:]
+			}
+			expr.WriteString("[" + strings.Replace(set, `\`, `\\`, -1) + "]")
+			i += end + 1
+		default:
+			expr.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	return expr.String()
+}
+
+// sessionMatcher matches the sessions of an application target: a pattern target, or
+// otherwise the process name itself. Patterns that don't compile match nothing;
+// check-config reports them.
+func sessionMatcher(target string) SessionMatcher {
+	pattern, ok, err := targetPattern(target)
+	if !ok {
+		return matchProcessName(target)
+	}
+	if err != nil {
+		return func(AudioSession) bool { return false }
+	}
+	return pattern.matches
+}
+
+// isPatternTarget reports whether a target matches sessions by pattern
+func isPatternTarget(target string) bool {
+	_, ok, _ := targetPattern(target)
+	return ok
+}
+
+// deviceTargetPrefix starts a slider target that controls audio endpoints by name
+// instead of application sessions
+const deviceTargetPrefix = "device:"
+
+// deviceNamePatterns caches compiled device:regex: patterns
+var deviceNamePatterns sync.Map // target -> *regexp.Regexp
+
+// deviceTargetMatcher matches the endpoints a device target controls. device: takes
+// part of an endpoint's name, or its ID, and device:regex: a pattern for the name. On
+// Windows, a target that is neither special nor an .exe is an endpoint's full name.
+// ok is false for targets that aren't devices.
+func deviceTargetMatcher(target string) (match func(AudioDevice) bool, ok bool, err error) {
+	if !strings.HasPrefix(strings.ToLower(target), deviceTargetPrefix) {
+		if runtime.GOOS != "windows" || isSpecialTarget(target) || isProcessTarget(target) {
+			return nil, false, nil
+		}
+		return func(device AudioDevice) bool {
+			return strings.EqualFold(device.Name, target)
+		}, true, nil
+	}
+
+	value := strings.TrimSpace(target[len(deviceTargetPrefix):])
+	if !strings.HasPrefix(strings.ToLower(value), regexTargetPrefix) {
+		if value == "" {
+			return nil, true, fmt.Errorf("%q has no device name after %s", target, deviceTargetPrefix)
+		}
+		return func(device AudioDevice) bool {
+			return deviceMatchesName(device, value)
+		}, true, nil
+	}
+
+	if cached, exists := deviceNamePatterns.Load(target); exists {
+		expr := cached.(*regexp.Regexp)
+		return func(device AudioDevice) bool { return expr.MatchString(device.Name) }, true, nil
+	}
+	value = strings.TrimSpace(value[len(regexTargetPrefix):])
+	if value == "" {
+		return nil, true, fmt.Errorf("%q has no pattern after %s%s", target, deviceTargetPrefix, regexTargetPrefix)
+	}
+	if _, err := regexp.Compile(value); err != nil {
+		return nil, true, fmt.Errorf("%q is not a valid pattern: %w", target, err)
+	}
+	expr := regexp.MustCompile("(?i)" + value)
+	deviceNamePatterns.Store(target, expr)
+	return func(device AudioDevice) bool { return expr.MatchString(device.Name) }, true, nil
+}
+
+// targetDevices returns the IDs of the endpoints a device target controls: master, mic,
+// or the endpoints a named device target matches. ok is false for other targets.
+func targetDevices(target string) (ids []string, ok bool, err error) {
+	switch target {
+	case "master":
+		return []string{defaultRenderDevice}, true, nil
+	case "mic":
+		return []string{defaultCaptureDevice}, true, nil
+	}
+
+	match, ok, err := deviceTargetMatcher(target)
+	if !ok || err != nil {
+		return nil, ok, err
+	}
+	devices, err := audio.Devices()
+	if err != nil {
+		return nil, true, fmt.Errorf("failed to list audio devices: %w", err)
+	}
+	for _, device := range devices {
+		if match(device) {
+			ids = append(ids, device.ID)
+		}
+	}
+	return ids, true, nil
+}
+
+// isDeviceTarget reports whether a slider target controls endpoints by name
+func isDeviceTarget(target string) bool {
+	_, ok, _ := deviceTargetMatcher(target)
+	return ok
+}
+
+// isSpecialTarget reports whether a target is master, mic or a deej. target, including
+// misspelled ones, which are never taken for a device
+func isSpecialTarget(target string) bool {
+	lower := strings.ToLower(target)
+	return lower == "" || strings.HasPrefix(lower, "deej.") || containsString(specialTargets, lower)
+}
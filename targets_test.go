@@ -0,0 +1,64 @@
+package main
+
+import "testing"
+
+func TestSessionMatcher(t *testing.T) {
+	steam := AudioSession{ProcessName: "steam.exe", Path: `C:\Program Files (x86)\Steam\steam.exe`}
+	helper := AudioSession{ProcessName: "steamwebhelper.exe", Path: `C:\Program Files (x86)\Steam\bin\cef\steamwebhelper.exe`}
+	notSteam := AudioSession{ProcessName: "notsteam.exe", Path: "/opt/notsteam/notsteam.exe"}
+	chrome := AudioSession{ProcessName: "Chrome.exe", Path: `C:\Program Files\Google\Chrome\Application\chrome.exe`}
+
+	tests := []struct {
+		target  string
+		session AudioSession
+		want    bool
+	}{
+		// glob: looks at the process name, whatever its case
+		{"glob:chrome*", chrome, true},
+		{"glob:CHROME.EXE", chrome, true},
+		{"GLOB:ch?ome.exe", chrome, true},
+		{"glob:*steam*", notSteam, true},
+		{"glob:steam*", notSteam, false},
+		{"glob:[!n]*.exe", steam, true},
+		{"glob:[!n]*.exe", notSteam, false},
+		{"glob:chrome", chrome, false},
+
+		// regex: is unanchored unless anchored
+		{"regex:steam", notSteam, true},
+		{"regex:^steam", steam, true},
+		{"regex:^steam", helper, true},
+		{"regex:^steam", notSteam, false},
+		{"regex:^steam\\.exe$", helper, false},
+		{"regex:^STEAM\\.exe$", steam, true},
+
+		// path: looks at the executable path, with \ taken for /
+		{`path:C:\Program Files (x86)\Steam\steam.exe`, steam, true},
+		{"path:c:/program files (x86)/steam/*.exe", steam, true},
+		{"path:c:/program files (x86)/steam/*.exe", helper, false},
+		{"path:c:/program files (x86)/steam/**.exe", helper, true},
+		{"path:**/steam/**", notSteam, false},
+		{"path:/opt/*/notsteam.exe", notSteam, true},
+		{"path:steam.exe", steam, false},
+		{"path:**", AudioSession{ProcessName: "steam.exe"}, false},
+	}
+
+	for _, test := range tests {
+		if got := sessionMatcher(test.target)(test.session); got != test.want {
+			t.Errorf("%s matches %s (%s): %t, want %t", test.target, test.session.ProcessName, test.session.Path, got, test.want)
+		}
+	}
+}
+
+func TestInvalidPatternTarget(t *testing.T) {
+	for _, target := range []string{"regex:steam(", "regex:", "glob: ", "path:"} {
+		if _, ok, err := targetPattern(target); !ok || err == nil {
+			t.Errorf("targetPattern(%q) = ok %t, err %v, want an error", target, ok, err)
+		}
+		if checkTarget(target) == "" {
+			t.Errorf("checkTarget(%q) reports nothing", target)
+		}
+		if sessionMatcher(target)(AudioSession{ProcessName: "steam("}) {
+			t.Errorf("%s matches a session", target)
+		}
+	}
+}
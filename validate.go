@@ -0,0 +1,1039 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// knownConfigKeys are the top-level settings deej reads
+var knownConfigKeys = []string{
+	configKeySliderMapping,
+	configKeyButtonMapping,
+	configKeyCOMPort,
+	configKeyBaudRate,
+	configKeyUSBIDs,
+	configKeyLayers,
+	configKeyProfiles,
+	configKeyUnmappedSync,
+	configKeySliderSettings,
+}
+
+// layerKeys are the settings of a layer
+var layerKeys = []string{"button", "mode", configKeySliderMapping, configKeyButtonMapping}
+
+// sliderSettingKeys are the settings of a slider in slider_settings
+var sliderSettingKeys = []string{"curve", "invert", "min", "max", "dead_zone", "smoothing"}
+
+// profileKeys are the settings of a profile
+var profileKeys = []string{configKeySliderMapping, configKeyButtonMapping, "auto_switch"}
+
+// profileSwitcher is implemented by actions that switch to profiles by name, so
+// the names can be checked against the profiles defined
+type profileSwitcher interface {
+	profileNames() []string
+}
+
+// specialTargets are the slider targets that don't name a process
+var specialTargets = []string{"master", "mic", "deej.current", "deej.unmapped"}
+
+// configProblem is something wrong with the config, found by checkConfigFile or
+// checkBoardCounts, and the line of config.yaml it is about
+type configProblem struct {
+	Line    int
+	Message string
+	Warning bool
+}
+
+// configCheck collects the problems found in one config file, together with the
+// lines sliders and buttons are first mapped on, so later checks can point at them
+type configCheck struct {
+	File         string
+	Problems     []configProblem
+	SliderLines  map[int]int
+	ButtonLines  map[int]int
+	Sliders      map[int][]string
+	Buttons      map[int]*buttonBinding
+	Layers       []*configLayer
+	LayerLines   map[string]int
+	Profiles     []*configProfile
+	Responses    map[int]*sliderResponse
+	TitleTargets bool // a title: target is used somewhere, so window titles are needed
+
+	context       string                 // prefixed to problems, for the section being checked
+	layerButtons  map[string]*yaml.Node  // where each layer's button is set
+	mappedButtons map[string]map[int]int // button lines per button_mapping, by context
+	profileUses   []profileUse
+}
+
+// profileUse is a profile named by a button's action
+type profileUse struct {
+	context string
+	line    int
+	name    string
+}
+
+func (c *configCheck) fail(node *yaml.Node, format string, args ...interface{}) {
+	c.Problems = append(c.Problems, configProblem{Line: node.Line, Message: c.context + fmt.Sprintf(format, args...)})
+}
+
+// Err returns the errors found as one error, or nil if there were none
+func (c *configCheck) Err() error {
+	var lines []string
+	for _, problem := range c.Problems {
+		if !problem.Warning {
+			lines = append(lines, formatProblem(c.File, problem))
+		}
+	}
+	if len(lines) == 0 {
+		return nil
+	}
+	return fmt.Errorf("invalid config:\n  %s", strings.Join(lines, "\n  "))
+}
+
+func formatProblem(file string, problem configProblem) string {
+	prefix := filepath.Base(file)
+	if problem.Line > 0 {
+		prefix = fmt.Sprintf("%s:%d", prefix, problem.Line)
+	}
+	if problem.Warning {
+		return fmt.Sprintf("%s: warning: %s", prefix, problem.Message)
+	}
+	return fmt.Sprintf("%s: %s", prefix, problem.Message)
+}
+
+// checkConfigFile checks config.yaml more strictly than viper reads it. The error is
+// only for files that can't be read or parsed; everything else ends up in Problems.
+func checkConfigFile(path string) (*configCheck, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	var root yaml.Node
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", filepath.Base(path), err)
+	}
+
+	check := &configCheck{
+		File:        path,
+		SliderLines: make(map[int]int),
+		ButtonLines: make(map[int]int),
+		Sliders:     make(map[int][]string),
+		Responses:   make(map[int]*sliderResponse),
+		Buttons:     make(map[int]*buttonBinding),
+		LayerLines:  make(map[string]int),
+
+		layerButtons:  make(map[string]*yaml.Node),
+		mappedButtons: make(map[string]map[int]int),
+	}
+
+	// An empty file leaves everything at its default
+	if len(root.Content) == 0 {
+		return check, nil
+	}
+	document := root.Content[0]
+	if document.Kind != yaml.MappingNode {
+		check.fail(document, "expected settings like %s: ..., not a %s", configKeyCOMPort, nodeKind(document))
+		return check, nil
+	}
+
+	seen := make(map[string]int)
+	for i := 0; i+1 < len(document.Content); i += 2 {
+		key, value := document.Content[i], document.Content[i+1]
+		name := strings.ToLower(key.Value)
+
+		if line, exists := seen[name]; exists {
+			check.fail(key, "%s is already set on line %d", key.Value, line)
+			continue
+		}
+		seen[name] = key.Line
+
+		switch name {
+		case configKeySliderMapping:
+			check.Sliders = check.sliderMapping(value)
+		case configKeyButtonMapping:
+			check.Buttons = check.buttonMapping(value)
+		case configKeyCOMPort:
+			if value.Kind != yaml.ScalarNode || value.ShortTag() == "!!null" || strings.TrimSpace(value.Value) == "" {
+				check.fail(value, "%s must be a port name like COM9, /dev/ttyACM0 or %s", configKeyCOMPort, autoCOMPort)
+			}
+		case configKeyBaudRate:
+			if baud, err := strconv.Atoi(value.Value); value.Kind != yaml.ScalarNode || err != nil || baud <= 0 {
+				check.fail(value, "%s must be a positive number, not %s", configKeyBaudRate, describeNode(value))
+			}
+		case configKeyUSBIDs:
+			check.usbIDs(value)
+		case configKeyLayers:
+			check.layers(value)
+		case configKeyProfiles:
+			check.profiles(value)
+		case configKeySliderSettings:
+			check.sliderSettings(value)
+		case configKeyUnmappedSync:
+			if mode := strings.ToLower(strings.TrimSpace(value.Value)); value.Kind != yaml.ScalarNode || !containsString(unmappedSyncModes, mode) {
+				check.fail(value, "%s must be one of %s, not %s%s", configKeyUnmappedSync, strings.Join(unmappedSyncModes, ", "), describeNode(value), suggest(mode, unmappedSyncModes))
+			}
+		default:
+			check.fail(key, "unknown setting %q%s", key.Value, suggest(name, knownConfigKeys))
+		}
+	}
+
+	check.layerButtonConflicts()
+	check.profileUsesDefined()
+	check.TitleTargets = hasTitleTarget(document)
+
+	sort.SliceStable(check.Problems, func(i, j int) bool {
+		return check.Problems[i].Line < check.Problems[j].Line
+	})
+	return check, nil
+}
+
+// hasTitleTarget tells whether a title: target appears anywhere under node, in slider
+// mappings, layers, profiles or button actions alike
+func hasTitleTarget(node *yaml.Node) bool {
+	if node.Kind == yaml.ScalarNode {
+		return strings.HasPrefix(strings.ToLower(strings.TrimSpace(node.Value)), titleTargetPrefix)
+	}
+	for _, child := range node.Content {
+		if hasTitleTarget(child) {
+			return true
+		}
+	}
+	return false
+}
+
+// sliderMapping checks a slider_mapping and returns the targets of each slider
+func (c *configCheck) sliderMapping(node *yaml.Node) map[int][]string {
+	sliders := make(map[int][]string)
+	if node.ShortTag() == "!!null" {
+		return sliders
+	}
+	if node.Kind != yaml.MappingNode {
+		c.fail(node, "%s must map slider numbers to targets, not a %s", configKeySliderMapping, nodeKind(node))
+		return sliders
+	}
+
+	type use struct {
+		slider int
+		line   int
+	}
+	targets := make(map[string]use)
+	lines := make(map[int]int)
+
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, value := node.Content[i], node.Content[i+1]
+
+		sliderNum, ok := indexNode(key)
+		if !ok {
+			c.fail(key, "%s: %s is not a slider number", configKeySliderMapping, describeNode(key))
+			continue
+		}
+		if line, exists := lines[sliderNum]; exists {
+			c.fail(key, "slider %d is already mapped on line %d", sliderNum, line)
+			continue
+		}
+		lines[sliderNum] = key.Line
+		if _, exists := c.SliderLines[sliderNum]; !exists {
+			c.SliderLines[sliderNum] = key.Line
+		}
+
+		var names []*yaml.Node
+		switch value.Kind {
+		case yaml.ScalarNode:
+			if value.ShortTag() != "!!null" {
+				names = []*yaml.Node{value}
+			}
+		case yaml.SequenceNode:
+			names = value.Content
+		default:
+			c.fail(value, "slider %d: expected a target or a list of targets, not a %s", sliderNum, nodeKind(value))
+			continue
+		}
+
+		for _, target := range names {
+			if target.Kind != yaml.ScalarNode || target.ShortTag() != "!!str" {
+				c.fail(target, "slider %d: %s is not a target name", sliderNum, describeNode(target))
+				continue
+			}
+			name := strings.TrimSpace(target.Value)
+			if name == "" {
+				continue
+			}
+			lower := strings.ToLower(name)
+
+			if problem := checkTarget(name); problem != "" {
+				c.fail(target, "slider %d: %s", sliderNum, problem)
+			}
+
+			if previous, exists := targets[lower]; exists {
+				if previous.slider == sliderNum {
+					c.fail(target, "slider %d: %q is listed twice", sliderNum, name)
+				} else {
+					c.fail(target, "slider %d: %q is already mapped to slider %d on line %d", sliderNum, name, previous.slider, previous.line)
+				}
+				continue
+			}
+			targets[lower] = use{slider: sliderNum, line: target.Line}
+			sliders[sliderNum] = append(sliders[sliderNum], name)
+		}
+	}
+
+	return sliders
+}
+
+// checkTarget catches targets that can't match anything: patterns that don't compile
+// and misspelled special targets
+func checkTarget(name string) string {
+	if strings.HasPrefix(strings.ToLower(name), deviceTargetPrefix) {
+		if _, _, err := deviceTargetMatcher(name); err != nil {
+			return err.Error()
+		}
+		return ""
+	}
+	if _, ok, err := targetPattern(name); ok {
+		if err != nil {
+			return err.Error()
+		}
+		return ""
+	}
+	return checkSpecialTarget(name)
+}
+
+// checkSpecialTarget catches misspelled special targets, which would otherwise be
+// taken for a process that never shows up
+func checkSpecialTarget(name string) string {
+	lower := strings.ToLower(name)
+	for _, special := range specialTargets {
+		if lower == special {
+			if name != special {
+				return fmt.Sprintf("special target %q must be written %q", name, special)
+			}
+			return ""
+		}
+	}
+
+	if strings.HasPrefix(lower, "deej.") {
+		return fmt.Sprintf("unknown special target %q%s", name, suggest(lower, specialTargets))
+	}
+	for _, special := range specialTargets {
+		if strings.HasPrefix(special, "deej.") && editDistance(lower, special) <= 2 {
+			return fmt.Sprintf("unknown special target %q (did you mean %q?)", name, special)
+		}
+	}
+	return ""
+}
+
+// buttonMapping checks a button_mapping and returns what each button does
+func (c *configCheck) buttonMapping(node *yaml.Node) map[int]*buttonBinding {
+	bindings := make(map[int]*buttonBinding)
+	lines := make(map[int]int)
+	c.mappedButtons[c.context] = lines
+
+	if node.ShortTag() == "!!null" {
+		return bindings
+	}
+	if node.Kind != yaml.MappingNode {
+		c.fail(node, "%s must map button numbers to keys or actions, not a %s", configKeyButtonMapping, nodeKind(node))
+		return bindings
+	}
+
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, value := node.Content[i], node.Content[i+1]
+
+		buttonNum, ok := indexNode(key)
+		if !ok {
+			c.fail(key, "%s: %s is not a button number", configKeyButtonMapping, describeNode(key))
+			continue
+		}
+		if line, exists := lines[buttonNum]; exists {
+			c.fail(key, "button %d is already mapped on line %d", buttonNum, line)
+			continue
+		}
+		lines[buttonNum] = key.Line
+		if _, exists := c.ButtonLines[buttonNum]; !exists {
+			c.ButtonLines[buttonNum] = key.Line
+		}
+
+		binding, err := buttonBindingFromNode(value)
+		if err != nil {
+			c.fail(value, "button %d: %v", buttonNum, err)
+			continue
+		}
+		bindings[buttonNum] = binding
+
+		for _, gesture := range []buttonGesture{gesturePress, gestureLong, gestureDouble, gestureHold} {
+			if switcher, ok := binding.action(gesture).(profileSwitcher); ok {
+				for _, name := range switcher.profileNames() {
+					c.profileUses = append(c.profileUses, profileUse{context: c.context, line: value.Line, name: name})
+				}
+			}
+		}
+	}
+
+	return bindings
+}
+
+// sliderSettings checks slider_settings and builds each slider's response
+func (c *configCheck) sliderSettings(node *yaml.Node) {
+	if node.ShortTag() == "!!null" {
+		return
+	}
+	if node.Kind != yaml.MappingNode {
+		c.fail(node, "%s must map slider numbers to their settings, not a %s", configKeySliderSettings, nodeKind(node))
+		return
+	}
+
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, value := node.Content[i], node.Content[i+1]
+
+		sliderNum, ok := indexNode(key)
+		if !ok {
+			c.fail(key, "%s: %s is not a slider number", configKeySliderSettings, describeNode(key))
+			continue
+		}
+		if _, exists := c.Responses[sliderNum]; exists {
+			c.fail(key, "slider %d already has settings", sliderNum)
+			continue
+		}
+		if value.Kind != yaml.MappingNode {
+			c.fail(value, "slider %d: expected %s, not a %s", sliderNum, strings.Join(sliderSettingKeys, ", "), nodeKind(value))
+			continue
+		}
+
+		response := &sliderResponse{Curve: curveLinear, Max: 100}
+		c.context = fmt.Sprintf("slider %d: ", sliderNum)
+		for j := 0; j+1 < len(value.Content); j += 2 {
+			setting, settingValue := value.Content[j], value.Content[j+1]
+
+			switch setting.Value {
+			case "curve":
+				c.curve(settingValue, response)
+			case "invert":
+				if err := settingValue.Decode(&response.Invert); err != nil || settingValue.Kind != yaml.ScalarNode {
+					c.fail(settingValue, "invert must be true or false, not %s", describeNode(settingValue))
+				}
+			case "min":
+				if limit, ok := c.percent(settingValue, "min", 100); ok {
+					response.Min = limit
+				}
+			case "max":
+				if limit, ok := c.percent(settingValue, "max", 100); ok {
+					response.Max = limit
+				}
+			case "dead_zone":
+				if limit, ok := c.percent(settingValue, "dead_zone", 49); ok {
+					response.DeadZone = limit
+				}
+			case "smoothing":
+				if smoothing, ok := c.percent(settingValue, "smoothing", maxVolumeSmoothing); ok {
+					response.Smoothing = smoothing
+				}
+			default:
+				c.fail(setting, "unknown setting %q%s", setting.Value, suggest(setting.Value, sliderSettingKeys))
+			}
+		}
+		if response.Min >= response.Max {
+			c.fail(key, "min (%d) must be below max (%d)", response.Min, response.Max)
+		}
+
+		c.context = ""
+		c.Responses[sliderNum] = response
+	}
+}
+
+// curve checks a curve: linear, logarithmic, or a list of [position, volume] points
+func (c *configCheck) curve(node *yaml.Node, response *sliderResponse) {
+	if node.Kind == yaml.ScalarNode {
+		curve := strings.ToLower(strings.TrimSpace(node.Value))
+		if !containsString(sliderCurves, curve) {
+			c.fail(node, "curve must be %s, or a list of [position, volume] points, not %s%s", strings.Join(sliderCurves, " or "), describeNode(node), suggest(curve, sliderCurves))
+			return
+		}
+		response.Curve = curve
+		return
+	}
+	if node.Kind != yaml.SequenceNode || len(node.Content) < 2 {
+		c.fail(node, "curve must be %s, or a list of at least two [position, volume] points", strings.Join(sliderCurves, " or "))
+		return
+	}
+
+	var points []curvePoint
+	for _, item := range node.Content {
+		var pair []float64
+		if err := item.Decode(&pair); err != nil || item.Kind != yaml.SequenceNode || len(pair) != 2 {
+			c.fail(item, "curve point must be [position, volume], not %s", describeNode(item))
+			return
+		}
+		point := curvePoint{Position: pair[0], Volume: pair[1]}
+		if point.Position < 0 || point.Position > 100 || point.Volume < 0 || point.Volume > 100 {
+			c.fail(item, "curve point positions and volumes must be between 0 and 100")
+			return
+		}
+		if n := len(points); n > 0 && (point.Position <= points[n-1].Position || point.Volume < points[n-1].Volume) {
+			c.fail(item, "curve points must go up in position, and not down in volume, so motor faders can find their volume")
+			return
+		}
+		points = append(points, point)
+	}
+	response.Points = points
+}
+
+// percent checks a whole percentage up to max
+func (c *configCheck) percent(node *yaml.Node, name string, max int) (int, bool) {
+	value, err := strconv.Atoi(node.Value)
+	if node.Kind != yaml.ScalarNode || err != nil || value < 0 || value > max {
+		c.fail(node, "%s must be a number from 0 to %d, not %s", name, max, describeNode(node))
+		return 0, false
+	}
+	return value, true
+}
+
+func (c *configCheck) layers(node *yaml.Node) {
+	if node.ShortTag() == "!!null" {
+		return
+	}
+	if node.Kind != yaml.MappingNode {
+		c.fail(node, "%s must map layer names to their settings, not a %s", configKeyLayers, nodeKind(node))
+		return
+	}
+
+	seen := make(map[string]int)
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, value := node.Content[i], node.Content[i+1]
+
+		name := strings.TrimSpace(key.Value)
+		if key.Kind != yaml.ScalarNode || name == "" {
+			c.fail(key, "%s: %s is not a layer name", configKeyLayers, describeNode(key))
+			continue
+		}
+		if line, exists := seen[name]; exists {
+			c.fail(key, "layer %s is already defined on line %d", name, line)
+			continue
+		}
+		seen[name] = key.Line
+
+		if value.Kind != yaml.MappingNode {
+			c.fail(value, "layer %s: expected button, mode, %s and %s, not a %s", name, configKeySliderMapping, configKeyButtonMapping, nodeKind(value))
+			continue
+		}
+
+		layer := &configLayer{
+			Name:          name,
+			Number:        len(c.Layers) + 1,
+			Button:        -1,
+			SliderTargets: make(map[int][]string),
+			ButtonMapping: make(map[int]*buttonBinding),
+		}
+		c.context = fmt.Sprintf("layer %s: ", name)
+
+		for j := 0; j+1 < len(value.Content); j += 2 {
+			setting, settingValue := value.Content[j], value.Content[j+1]
+
+			switch setting.Value {
+			case "button":
+				buttonNum, ok := indexNode(settingValue)
+				if !ok {
+					c.fail(settingValue, "%s is not a button number", describeNode(settingValue))
+					continue
+				}
+				layer.Button = buttonNum
+				c.layerButtons[name] = settingValue
+				c.LayerLines[name] = settingValue.Line
+			case "mode":
+				switch settingValue.Value {
+				case "hold":
+				case "toggle":
+					layer.Toggle = true
+				default:
+					c.fail(settingValue, "mode must be hold or toggle, not %s", describeNode(settingValue))
+				}
+			case configKeySliderMapping:
+				layer.SliderTargets = c.sliderMapping(settingValue)
+			case configKeyButtonMapping:
+				layer.ButtonMapping = c.buttonMapping(settingValue)
+			default:
+				c.fail(setting, "unknown setting %q%s", setting.Value, suggest(setting.Value, layerKeys))
+			}
+		}
+
+		c.context = ""
+		if layer.Button < 0 {
+			c.fail(key, "layer %s has no button to switch it", name)
+		}
+		c.Layers = append(c.Layers, layer)
+	}
+}
+
+// layerButtonConflicts catches layer buttons that are used twice, or also mapped to
+// an action; a button that switches a layer does nothing else
+func (c *configCheck) layerButtonConflicts() {
+	for i, layer := range c.Layers {
+		node := c.layerButtons[layer.Name]
+		if node == nil {
+			continue
+		}
+
+		for _, other := range c.Layers[:i] {
+			if other.Button == layer.Button {
+				c.fail(node, "layer %s: button %d already switches layer %s", layer.Name, layer.Button, other.Name)
+			}
+		}
+
+		for context, lines := range c.mappedButtons {
+			line, exists := lines[layer.Button]
+			if !exists {
+				continue
+			}
+			c.Problems = append(c.Problems, configProblem{
+				Line:    line,
+				Message: fmt.Sprintf("%sbutton %d switches layer %s, so it can't be mapped as well", context, layer.Button, layer.Name),
+			})
+		}
+	}
+}
+
+func (c *configCheck) profiles(node *yaml.Node) {
+	if node.ShortTag() == "!!null" {
+		return
+	}
+	if node.Kind != yaml.MappingNode {
+		c.fail(node, "%s must map profile names to their mappings, not a %s", configKeyProfiles, nodeKind(node))
+		return
+	}
+
+	seen := make(map[string]int)
+	autoSwitch := make(map[string]string)
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, value := node.Content[i], node.Content[i+1]
+
+		name := strings.TrimSpace(key.Value)
+		lower := strings.ToLower(name)
+		if key.Kind != yaml.ScalarNode || name == "" {
+			c.fail(key, "%s: %s is not a profile name", configKeyProfiles, describeNode(key))
+			continue
+		}
+		if lower == defaultProfile {
+			c.fail(key, "%s: %q is the name of the top-level mappings, pick another one", configKeyProfiles, name)
+			continue
+		}
+		if line, exists := seen[lower]; exists {
+			c.fail(key, "profile %s is already defined on line %d", name, line)
+			continue
+		}
+		seen[lower] = key.Line
+
+		if value.Kind != yaml.MappingNode {
+			c.fail(value, "profile %s: expected %s, %s and auto_switch, not a %s", name, configKeySliderMapping, configKeyButtonMapping, nodeKind(value))
+			continue
+		}
+
+		profile := &configProfile{Name: name}
+		c.context = fmt.Sprintf("profile %s: ", name)
+
+		for j := 0; j+1 < len(value.Content); j += 2 {
+			setting, settingValue := value.Content[j], value.Content[j+1]
+
+			switch setting.Value {
+			case configKeySliderMapping:
+				profile.SliderTargets = c.sliderMapping(settingValue)
+			case configKeyButtonMapping:
+				profile.ButtonMapping = c.buttonMapping(settingValue)
+			case "auto_switch":
+				processes := []*yaml.Node{settingValue}
+				if settingValue.Kind == yaml.SequenceNode {
+					processes = settingValue.Content
+				}
+				for _, process := range processes {
+					if process.Kind != yaml.ScalarNode || strings.TrimSpace(process.Value) == "" {
+						c.fail(process, "auto_switch: %s is not a process name", describeNode(process))
+						continue
+					}
+					processName := strings.TrimSpace(process.Value)
+					if other, exists := autoSwitch[strings.ToLower(processName)]; exists {
+						c.fail(process, "auto_switch: %s already switches to profile %s", processName, other)
+						continue
+					}
+					autoSwitch[strings.ToLower(processName)] = name
+					profile.AutoSwitch = append(profile.AutoSwitch, processName)
+				}
+			default:
+				c.fail(setting, "unknown setting %q%s", setting.Value, suggest(setting.Value, profileKeys))
+			}
+		}
+
+		c.context = ""
+		c.Profiles = append(c.Profiles, profile)
+	}
+}
+
+// profileUsesDefined catches actions switching to profiles that don't exist
+func (c *configCheck) profileUsesDefined() {
+	names := []string{defaultProfile}
+	for _, profile := range c.Profiles {
+		names = append(names, strings.ToLower(profile.Name))
+	}
+
+	for _, use := range c.profileUses {
+		if !containsString(names, strings.ToLower(use.name)) {
+			c.Problems = append(c.Problems, configProblem{
+				Line:    use.line,
+				Message: fmt.Sprintf("%sprofile %q is not defined%s", use.context, use.name, suggest(strings.ToLower(use.name), names)),
+			})
+		}
+	}
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// gestureKeys are the settings that make a button_mapping entry bind several gestures
+var gestureKeys = []string{"press", "long", "double", "hold", "long_after", "double_within", "hold_after", "repeat_every"}
+
+// buttonBindingFromNode builds a button_mapping entry: a single action for a press, or
+// a mapping of gestures to actions, with optional timing
+func buttonBindingFromNode(node *yaml.Node) (*buttonBinding, error) {
+	binding := &buttonBinding{
+		Timing: gestureTiming{
+			LongAfter:    defaultLongAfter,
+			DoubleWithin: defaultDoubleWithin,
+			HoldAfter:    defaultHoldAfter,
+			RepeatEvery:  defaultRepeatEvery,
+		},
+	}
+
+	if !isGestureMapping(node) {
+		action, err := buttonActionFromNode(node)
+		if err != nil {
+			return nil, err
+		}
+		binding.Press = action
+		return binding, nil
+	}
+
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, value := node.Content[i], node.Content[i+1]
+
+		var err error
+		switch key.Value {
+		case "press":
+			binding.Press, err = buttonActionFromNode(value)
+		case "long":
+			binding.Long, err = buttonActionFromNode(value)
+		case "double":
+			binding.Double, err = buttonActionFromNode(value)
+		case "hold":
+			binding.Hold, err = buttonActionFromNode(value)
+		case "long_after":
+			binding.Timing.LongAfter, err = durationFromNode(value)
+		case "double_within":
+			binding.Timing.DoubleWithin, err = durationFromNode(value)
+		case "hold_after":
+			binding.Timing.HoldAfter, err = durationFromNode(value)
+		case "repeat_every":
+			binding.Timing.RepeatEvery, err = durationFromNode(value)
+		default:
+			err = fmt.Errorf("unknown setting %q%s", key.Value, suggest(key.Value, gestureKeys))
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", key.Value, err)
+		}
+	}
+
+	if binding.Long != nil && binding.Hold != nil {
+		return nil, fmt.Errorf("long and hold can't both be set, both happen while the button is held")
+	}
+	return binding, nil
+}
+
+// isGestureMapping tells a mapping of gestures apart from a single action's settings
+func isGestureMapping(node *yaml.Node) bool {
+	if node.Kind != yaml.MappingNode {
+		return false
+	}
+	for i := 0; i < len(node.Content); i += 2 {
+		if node.Content[i].Value == "action" {
+			return false
+		}
+	}
+	return true
+}
+
+// durationFromNode reads a duration like 500ms; plain numbers are milliseconds
+func durationFromNode(node *yaml.Node) (time.Duration, error) {
+	if node.Kind != yaml.ScalarNode {
+		return 0, fmt.Errorf("expected a duration like 500ms, not a %s", nodeKind(node))
+	}
+
+	var d time.Duration
+	if ms, err := strconv.Atoi(node.Value); err == nil {
+		d = time.Duration(ms) * time.Millisecond
+	} else if d, err = time.ParseDuration(node.Value); err != nil {
+		return 0, fmt.Errorf("%q is not a duration like 500ms", node.Value)
+	}
+	if d <= 0 {
+		return 0, fmt.Errorf("%s must be longer than 0", node.Value)
+	}
+	return d, nil
+}
+
+// buttonActionFromNode builds a button_mapping entry's action. Numbers are raw key codes,
+// while quoted digits are the digit keys.
+func buttonActionFromNode(node *yaml.Node) (buttonAction, error) {
+	switch node.Kind {
+	case yaml.ScalarNode:
+		switch node.ShortTag() {
+		case "!!int":
+			code, err := strconv.Atoi(node.Value)
+			if err != nil {
+				return nil, fmt.Errorf("%s is not a key code", node.Value)
+			}
+			return newButtonAction(code)
+		case "!!str":
+			return newButtonAction(node.Value)
+		}
+		return nil, fmt.Errorf("%s is not a key", describeNode(node))
+	case yaml.MappingNode:
+		var values map[string]interface{}
+		if err := node.Decode(&values); err != nil {
+			return nil, err
+		}
+		return newButtonAction(values)
+	}
+	return nil, fmt.Errorf("expected a key like F13 or a mapping with an action, not a %s", nodeKind(node))
+}
+
+func (c *configCheck) usbIDs(node *yaml.Node) {
+	if node.ShortTag() == "!!null" {
+		return
+	}
+	if node.Kind != yaml.SequenceNode {
+		c.fail(node, "%s must be a list of USB IDs, not a %s", configKeyUSBIDs, nodeKind(node))
+		return
+	}
+
+	for _, id := range node.Content {
+		if id.Kind != yaml.ScalarNode {
+			c.fail(id, "%s: expected \"VID:PID\" or \"VID\", not a %s", configKeyUSBIDs, nodeKind(id))
+			continue
+		}
+		// Unquoted, YAML reads 0x2341 as the number 9025, and 2341 as decimal
+		if id.ShortTag() != "!!str" {
+			c.fail(id, "%s: %s must be quoted, like \"%s\"", configKeyUSBIDs, id.Value, id.Value)
+			continue
+		}
+		// What passes here is what the port matching compares against
+		if _, ok := normalizeUSBID(id.Value); !ok {
+			c.fail(id, "%s: %q is not a \"VID:PID\" or \"VID\" in hex", configKeyUSBIDs, id.Value)
+		}
+	}
+}
+
+// checkBoardCounts compares the mapped sliders and buttons with what the board has
+func checkBoardCounts(config *deejConfig, info boardInfo) []configProblem {
+	var problems []configProblem
+
+	for _, sliderNum := range sortedKeys(config.sliderLines) {
+		if sliderNum >= info.Sliders {
+			problems = append(problems, configProblem{
+				Line:    config.sliderLines[sliderNum],
+				Message: fmt.Sprintf("slider %d is mapped, but the board only has %d sliders", sliderNum, info.Sliders),
+				Warning: true,
+			})
+		}
+	}
+
+	for _, buttonNum := range sortedKeys(config.buttonLines) {
+		if buttonNum >= info.Buttons {
+			problems = append(problems, configProblem{
+				Line:    config.buttonLines[buttonNum],
+				Message: fmt.Sprintf("button %d is mapped, but the board only has %d buttons", buttonNum, info.Buttons),
+				Warning: true,
+			})
+		}
+	}
+
+	for _, layer := range config.Layers {
+		if layer.Button >= info.Buttons {
+			problems = append(problems, configProblem{
+				Line:    config.layerLines[layer.Name],
+				Message: fmt.Sprintf("layer %s is switched by button %d, but the board only has %d buttons", layer.Name, layer.Button, info.Buttons),
+				Warning: true,
+			})
+		}
+	}
+
+	return problems
+}
+
+func sortedKeys(m map[int]int) []int {
+	keys := make([]int, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Ints(keys)
+	return keys
+}
+
+// runCheckConfig implements `deej check-config`
+func runCheckConfig(args []string) error {
+	flags := flag.NewFlagSet("check-config", flag.ExitOnError)
+	board := flags.Bool("board", false, "Also connect to the board and compare its slider and button count")
+	flags.Parse(args)
+
+	v, err := initializeConfig()
+	if err != nil {
+		return err
+	}
+	check, err := checkConfigFile(v.ConfigFileUsed())
+	if err != nil {
+		return err
+	}
+
+	for _, problem := range check.Problems {
+		fmt.Println(formatProblem(check.File, problem))
+	}
+	if len(check.Problems) > 0 {
+		return fmt.Errorf("%d problems in %s", len(check.Problems), check.File)
+	}
+
+	if *board {
+		config, err := loadConfig(v)
+		if err != nil {
+			return err
+		}
+		info, err := probeBoardInfo(config)
+		if err != nil {
+			return err
+		}
+
+		problems := checkBoardCounts(config, info)
+		for _, problem := range problems {
+			fmt.Println(formatProblem(check.File, problem))
+		}
+		if len(problems) > 0 {
+			return fmt.Errorf("%s doesn't match the board", check.File)
+		}
+	}
+
+	fmt.Printf("%s is valid\n", check.File)
+	return nil
+}
+
+// probeBoardInfo connects to the configured board just long enough to ask for its counts
+func probeBoardInfo(config *deejConfig) (boardInfo, error) {
+	options := serialOptions(config.COMPort, config.BaudRate)
+
+	var port io.ReadWriteCloser
+	var messages <-chan serialMessage
+	var err error
+	if strings.EqualFold(config.COMPort, autoCOMPort) {
+		_, port, messages, err = discoverPort(options, config.USBIDs, true)
+	} else {
+		port, messages, _, err = probePort(options, bannerTimeout)
+	}
+	if err != nil {
+		return boardInfo{}, err
+	}
+	defer func() {
+		port.Close()
+		drainMessages(messages)
+	}()
+
+	mode, info, err := negotiateProtocol(port, messages)
+	if err != nil {
+		return boardInfo{}, fmt.Errorf("protocol handshake failed: %w", err)
+	}
+	if mode != protocolFramed {
+		return boardInfo{}, fmt.Errorf("the board uses the %s protocol, which doesn't report its slider and button count", mode)
+	}
+	return info, nil
+}
+
+// indexNode reads a slider or button number
+func indexNode(node *yaml.Node) (int, bool) {
+	if node.Kind != yaml.ScalarNode {
+		return 0, false
+	}
+	index, err := strconv.Atoi(node.Value)
+	return index, err == nil && index >= 0
+}
+
+func nodeKind(node *yaml.Node) string {
+	switch node.Kind {
+	case yaml.MappingNode:
+		return "mapping"
+	case yaml.SequenceNode:
+		return "list"
+	case yaml.AliasNode:
+		return "alias"
+	}
+	return "value"
+}
+
+func describeNode(node *yaml.Node) string {
+	if node.Kind == yaml.ScalarNode {
+		return strconv.Quote(node.Value)
+	}
+	return "a " + nodeKind(node)
+}
+
+// suggest returns a hint naming the closest candidate, if one is close enough
+func suggest(name string, candidates []string) string {
+	best, bestDistance := "", 3
+	for _, candidate := range candidates {
+		if d := editDistance(name, candidate); d < bestDistance {
+			best, bestDistance = candidate, d
+		}
+	}
+	if best == "" {
+		return ""
+	}
+	return fmt.Sprintf(" (did you mean %q?)", best)
+}
+
+// editDistance is the Levenshtein distance between a and b
+func editDistance(a string, b string) int {
+	previous := make([]int, len(b)+1)
+	current := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = min3(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous, current = current, previous
+	}
+	return previous[len(b)]
+}
+
+func min3(a int, b int, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// checkContents runs the config check on config.yaml contents and returns its
+// problems as deej prints them
+func checkContents(t *testing.T, contents string) []string {
+	t.Helper()
+
+	dir, err := ioutil.TempDir("", "deej")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "config.yaml")
+	if err := ioutil.WriteFile(path, []byte(contents), 0644); err != nil {
+		t.Fatal(err)
+	}
+	check, err := checkConfigFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var problems []string
+	for _, problem := range check.Problems {
+		problems = append(problems, formatProblem(path, problem))
+	}
+	return problems
+}
+
+func TestCheckConfigFile(t *testing.T) {
+	tests := []struct {
+		name     string
+		contents string
+		want     []string
+	}{
+		{
+			name:     "valid",
+			contents: "slider_mapping:\n  0: master\n  1: [discord.exe, deej.current]\nbutton_mapping:\n  0: CTRL+SHIFT+M\nusb_ids:\n  - \"2341\"\n  - '0x1a86:7523'\n",
+		},
+		{
+			name:     "unknown key",
+			contents: "slider_mapping:\n  0: master\nslider_mapings:\n  1: mic\n",
+			want:     []string{`config.yaml:3: unknown setting "slider_mapings" (did you mean "slider_mapping"?)`},
+		},
+		{
+			name:     "slider and button numbers that aren't numbers",
+			contents: "slider_mapping:\n  zero: master\n  -1: mic\nbutton_mapping:\n  one: F13\n",
+			want: []string{
+				`config.yaml:2: slider_mapping: "zero" is not a slider number`,
+				`config.yaml:3: slider_mapping: "-1" is not a slider number`,
+				`config.yaml:5: button_mapping: "one" is not a button number`,
+			},
+		},
+		{
+			name:     "target on two sliders",
+			contents: "slider_mapping:\n  0: discord.exe\n  1: [spotify.exe, Discord.exe]\n",
+			want:     []string{`config.yaml:3: slider 1: "Discord.exe" is already mapped to slider 0 on line 2`},
+		},
+		{
+			name:     "misspelled special targets",
+			contents: "slider_mapping:\n  0: deej.curent\n  1: Deej.Current\n",
+			want: []string{
+				`config.yaml:2: slider 0: unknown special target "deej.curent" (did you mean "deej.current"?)`,
+				`config.yaml:3: slider 1: special target "Deej.Current" must be written "deej.current"`,
+			},
+		},
+		{
+			name:     "bad key names",
+			contents: "button_mapping:\n  0: F99\n  1: CTRL+SHFT+M\n  2: CTRL+SHIFT+M\n",
+			want: []string{
+				`config.yaml:2: button 0: unknown key "F99" (did you mean "F19"?)`,
+				`config.yaml:3: button 1: "SHFT" is not a modifier (use CTRL, SHIFT, ALT, ALTGR or SUPER)`,
+			},
+		},
+		{
+			name:     "USB IDs",
+			contents: "usb_ids:\n  - 0x2341\n  - \"0x2341\"\n  - \"403:6001\"\n  - \"arduino\"\n  - \"2341:uno\"\n  - 2341\n  - \"2341:*\"\n",
+			want: []string{
+				`config.yaml:2: usb_ids: 0x2341 must be quoted, like "0x2341"`,
+				`config.yaml:5: usb_ids: "arduino" is not a "VID:PID" or "VID" in hex`,
+				`config.yaml:6: usb_ids: "2341:uno" is not a "VID:PID" or "VID" in hex`,
+				`config.yaml:7: usb_ids: 2341 must be quoted, like "2341"`,
+			},
+		},
+		{
+			name:     "problems come in line order",
+			contents: "baud_rate: fast\nslider_mapping:\n  0: deej.curent\ncom_prt: COM4\n",
+			want: []string{
+				`config.yaml:1: baud_rate must be a positive number, not "fast"`,
+				`config.yaml:3: slider 0: unknown special target "deej.curent" (did you mean "deej.current"?)`,
+				`config.yaml:4: unknown setting "com_prt" (did you mean "com_port"?)`,
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := checkContents(t, test.contents); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("problems:\n  %q\nwant:\n  %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestCheckBoardCounts(t *testing.T) {
+	config := useConfig(t, "slider_mapping:\n  0: master\n  3: mic\nbutton_mapping:\n  1: F13\n  4: F14\nlayers:\n  fn:\n    button: 5\n")
+
+	var got []string
+	for _, problem := range checkBoardCounts(config, boardInfo{Sliders: 2, Buttons: 3}) {
+		got = append(got, formatProblem("config.yaml", problem))
+	}
+	want := []string{
+		"config.yaml:3: warning: slider 3 is mapped, but the board only has 2 sliders",
+		"config.yaml:6: warning: button 4 is mapped, but the board only has 3 buttons",
+		"config.yaml:9: warning: layer fn is switched by button 5, but the board only has 3 buttons",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("warnings:\n  %q\nwant:\n  %q", got, want)
+	}
+
+	if problems := checkBoardCounts(config, boardInfo{Sliders: 4, Buttons: 6}); len(problems) != 0 {
+		t.Errorf("warnings for a board that has everything: %v", problems)
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"log"
+	"math"
+	"sync"
+	"time"
+)
+
+// A smoothed target moves toward a slider's volume in steps this far apart
+const volumeSmoothingInterval = 20 * time.Millisecond
+
+// volumeWriterState sets slider volumes with one worker per target, or per app for
+// deej.current. A slider sends far more values while it moves than the audio backend
+// can set, so each worker only keeps the latest one, and the last volume it sets is
+// the last one asked for. A target is forgotten once its worker is done, so apps
+// that were in front once don't pile up, and the next move is smoothed from the
+// volume the target has by then, which deej may not have set.
+type volumeWriterState struct {
+	mu      sync.Mutex
+	targets map[string]*targetVolume
+}
+
+// targetVolume is what a target's worker is asked to set and has set
+type targetVolume struct {
+	wanted    int
+	written   int     // -1 while unknown
+	smoothing float64 // share of the remaining way left after each step, 0 for none
+	pending   bool    // wanted was asked for since the worker last looked
+	running   bool
+}
+
+var volumeWriter = &volumeWriterState{targets: make(map[string]*targetVolume)}
+
+// Set asks for a target's volume, with smoothing from 0 (none) to 1, and returns
+// without waiting for it to be set
+func (w *volumeWriterState) Set(target string, value int, smoothing float64) {
+	// deej.current is followed per app, so smoothing never starts from the volume
+	// set on the app that was in front before
+	if target == "deej.current" {
+		processName, err := getCurrentProcessName()
+		if err != nil {
+			log.Println(err)
+			return
+		}
+		target = processName
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	state, exists := w.targets[target]
+	if !exists {
+		state = &targetVolume{written: -1}
+		w.targets[target] = state
+	}
+	state.wanted = value
+	state.smoothing = smoothing
+	state.pending = true
+
+	if !state.running {
+		state.running = true
+		go w.run(target, state)
+	}
+}
+
+// run sets a target's volume until it is the one last asked for. Without smoothing
+// that is a single write per burst of values; with it, a step every interval.
+func (w *volumeWriterState) run(target string, state *targetVolume) {
+	w.mu.Lock()
+	smoothing := state.smoothing
+	w.mu.Unlock()
+	if smoothing > 0 {
+		if volume, ok := getTargetVolume(target); ok && volume >= 0 {
+			w.mu.Lock()
+			state.written = volume
+			w.mu.Unlock()
+		}
+	}
+
+	for {
+		w.mu.Lock()
+		if !state.pending && state.written == state.wanted {
+			state.running = false
+			delete(w.targets, target)
+			w.mu.Unlock()
+			return
+		}
+		state.pending = false
+		next := state.nextStep()
+		smoothing = state.smoothing
+		w.mu.Unlock()
+
+		setTargetVolume(target, next)
+
+		w.mu.Lock()
+		state.written = next
+		w.mu.Unlock()
+
+		if smoothing > 0 {
+			time.Sleep(volumeSmoothingInterval)
+		}
+	}
+}
+
+// nextStep is the volume to set next: the wanted one, or with smoothing part of the
+// way there, but always at least one step closer
+func (t *targetVolume) nextStep() int {
+	if t.smoothing <= 0 || t.written < 0 {
+		return t.wanted
+	}
+
+	step := int(math.Round(float64(t.wanted-t.written) * (1 - t.smoothing)))
+	switch {
+	case step == 0 && t.wanted > t.written:
+		step = 1
+	case step == 0 && t.wanted < t.written:
+		step = -1
+	}
+	return t.written + step
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+// sweep moves a slider mapped to master from 0 to 100, a value every interval
+func sweep(smoothing float64, interval time.Duration) {
+	for value := 0; value <= 100; value++ {
+		volumeWriter.Set("master", value, smoothing)
+		time.Sleep(interval)
+	}
+}
+
+func TestVolumeWriterSweep(t *testing.T) {
+	tests := []struct {
+		name      string
+		smoothing float64
+		want      []int
+	}{
+		{"unsmoothed", 0, []int{0, 100}},
+		{"smoothed", 0.5, []int{0, 50, 75, 88, 94, 97, 99, 100}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fake := useFakeAudio(t)
+			fake.UpdateDevice(defaultRenderDevice, func(device *AudioDevice) { device.Volume = 0 })
+			gate := make(chan struct{})
+			fake.SetGate(gate)
+
+			// The whole sweep arrives while the backend is busy with the first value,
+			// so the rest of it comes down to the last one
+			volumeWriter.Set("master", 0, test.smoothing)
+			<-gate
+			for value := 1; value <= 100; value++ {
+				volumeWriter.Set("master", value, test.smoothing)
+			}
+			fake.SetGate(nil)
+			gate <- struct{}{}
+			waitForVolumeWriter(t)
+
+			var got []int
+			for _, call := range fake.Calls() {
+				got = append(got, call.Volume)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("volumes set = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestVolumeWriterFollowsCurrentWindowPerApp(t *testing.T) {
+	fake := useFakeAudio(t,
+		AudioSession{ProcessName: "chrome.exe", PID: 1, Volume: 50},
+		AudioSession{ProcessName: "game.exe", PID: 2, Volume: 50},
+	)
+
+	// Each app is smoothed from its own volume, not from the one set on the app in
+	// front before it
+	for _, app := range []struct {
+		processName string
+		volume      int
+	}{{"chrome.exe", 20}, {"game.exe", 80}} {
+		fake.ResetCalls()
+		fake.SetForeground(app.processName)
+		volumeWriter.Set("deej.current", app.volume, 0.5)
+		waitForVolumeWriter(t)
+
+		calls := fake.Calls()
+		if len(calls) < 2 {
+			t.Fatalf("%s: calls = %v, want several steps from 50 to %d", app.processName, calls, app.volume)
+		}
+		previous := 50
+		for _, call := range calls {
+			if call.Target != app.processName {
+				t.Errorf("%s: call %v sets another app", app.processName, call)
+			}
+			if abs(call.Volume-app.volume) >= abs(previous-app.volume) {
+				t.Errorf("%s: step %d doesn't get closer to %d than %d (calls: %v)", app.processName, call.Volume, app.volume, previous, calls)
+			}
+			previous = call.Volume
+		}
+		if previous != app.volume {
+			t.Errorf("%s: last step %d, want %d", app.processName, previous, app.volume)
+		}
+	}
+
+	volumeWriter.mu.Lock()
+	defer volumeWriter.mu.Unlock()
+	if len(volumeWriter.targets) != 0 {
+		t.Errorf("%d targets still kept once done", len(volumeWriter.targets))
+	}
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+// BenchmarkVolumeWriterSweep reports how many backend calls a sweep of 101 slider
+// values a millisecond apart makes, against a backend that takes 5ms per call
+func BenchmarkVolumeWriterSweep(b *testing.B) {
+	fake := useFakeAudio(b)
+	fake.SetLatency(5 * time.Millisecond)
+
+	for i := 0; i < b.N; i++ {
+		sweep(0, time.Millisecond)
+		waitForVolumeWriter(b)
+	}
+	b.ReportMetric(float64(len(fake.Calls()))/float64(b.N), "calls/sweep")
+}
@@ -0,0 +1,379 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Just enough of the X11 protocol to follow the active window: the window manager
+// names it in the root window's _NET_ACTIVE_WINDOW, and the window names its
+// process in _NET_WM_PID. Everything is sent little-endian.
+
+const (
+	x11OpChangeWindowAttributes = 2
+	x11OpInternAtom             = 16
+	x11OpGetProperty            = 20
+
+	x11CWEventMask          = 0x800
+	x11PropertyChangeMask   = 0x400000
+	x11PropertyNotify       = 28
+	x11AuthorizationCookie  = "MIT-MAGIC-COOKIE-1"
+	x11FamilyLocal          = 256
+	x11FamilyWild           = 65535
+	x11UnixSocketDir        = "/tmp/.X11-unix"
+	x11TCPPortBase          = 6000
+	x11RequestHeaderLength  = 4
+	x11PacketLength         = 32
+	x11ReplyFixedLength     = 32
+	x11GetPropertyMaxLength = 1 // every property deej reads is a single 32-bit value
+)
+
+// x11Error is an error the X server answered a request with, such as BadWindow for a
+// window that has gone away in the meantime
+type x11Error struct {
+	code byte
+}
+
+func (e *x11Error) Error() string {
+	return fmt.Sprintf("X11 error %d", e.code)
+}
+
+type x11Conn struct {
+	conn   net.Conn
+	reader *bufio.Reader
+	root   uint32
+	seq    uint16 // sequence number of the last request sent
+
+	watchedAtom uint32 // property of the root window whose changes are waited for
+	changed     bool   // it changed while a reply was awaited
+}
+
+// dialX11 connects to the X server a DISPLAY value like ":0" or "host:0.0" names
+func dialX11(display string) (*x11Conn, error) {
+	if display == "" {
+		return nil, errors.New("DISPLAY is not set")
+	}
+	colon := strings.LastIndex(display, ":")
+	if colon < 0 {
+		return nil, fmt.Errorf("DISPLAY %q has no display number", display)
+	}
+	host, number := display[:colon], display[colon+1:]
+	if dot := strings.Index(number, "."); dot >= 0 {
+		number = number[:dot]
+	}
+	displayNum, err := strconv.Atoi(number)
+	if err != nil {
+		return nil, fmt.Errorf("DISPLAY %q has no display number", display)
+	}
+
+	var conn net.Conn
+	if host == "" || host == "unix" {
+		conn, err = net.Dial("unix", filepath.Join(x11UnixSocketDir, "X"+number))
+	} else {
+		conn, err = net.Dial("tcp", net.JoinHostPort(host, strconv.Itoa(x11TCPPortBase+displayNum)))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to X display %s: %w", display, err)
+	}
+
+	x := &x11Conn{conn: conn, reader: bufio.NewReader(conn)}
+	if err := x.setup(number); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to connect to X display %s: %w", display, err)
+	}
+	return x, nil
+}
+
+// setup introduces the client, with the display's cookie if there is one, and finds
+// the root window of the first screen
+func (x *x11Conn) setup(displayNum string) error {
+	authName, authData := x11Cookie(displayNum)
+
+	request := []byte{'l', 0}
+	request = appendUint16(request, 11) // protocol major version
+	request = appendUint16(request, 0)
+	request = appendUint16(request, uint16(len(authName)))
+	request = appendUint16(request, uint16(len(authData)))
+	request = append(request, 0, 0)
+	request = appendPadded(request, []byte(authName))
+	request = appendPadded(request, authData)
+	if _, err := x.conn.Write(request); err != nil {
+		return err
+	}
+
+	header := make([]byte, 8)
+	if _, err := io.ReadFull(x.reader, header); err != nil {
+		return err
+	}
+	body := make([]byte, 4*int(binary.LittleEndian.Uint16(header[6:])))
+	if _, err := io.ReadFull(x.reader, body); err != nil {
+		return err
+	}
+	if header[0] != 1 {
+		reason := body
+		if header[0] == 0 && int(header[1]) <= len(body) {
+			reason = body[:header[1]]
+		}
+		return fmt.Errorf("refused by the X server: %s", strings.TrimRight(string(reason), "\x00\n"))
+	}
+
+	// Fixed fields, the vendor string and the pixmap formats come before the first screen
+	if len(body) < 32 {
+		return errors.New("short reply from the X server")
+	}
+	vendorLength := int(binary.LittleEndian.Uint16(body[16:]))
+	formats := int(body[21])
+	screen := 32 + pad4(vendorLength) + 8*formats
+	if len(body) < screen+4 {
+		return errors.New("the X server has no screens")
+	}
+	x.root = binary.LittleEndian.Uint32(body[screen:])
+	return nil
+}
+
+// x11Cookie returns the MIT-MAGIC-COOKIE-1 for a display from the Xauthority file,
+// or nothing if there is none, for servers that don't ask for one
+func x11Cookie(displayNum string) (name string, data []byte) {
+	path := os.Getenv("XAUTHORITY")
+	if path == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", nil
+		}
+		path = filepath.Join(home, ".Xauthority")
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return "", nil
+	}
+	defer file.Close()
+	hostname, _ := os.Hostname()
+
+	// Entries are a family and four counted strings, all big-endian
+	reader := bufio.NewReader(file)
+	readField := func() ([]byte, error) {
+		var length uint16
+		if err := binary.Read(reader, binary.BigEndian, &length); err != nil {
+			return nil, err
+		}
+		field := make([]byte, length)
+		_, err := io.ReadFull(reader, field)
+		return field, err
+	}
+	for {
+		var family uint16
+		if err := binary.Read(reader, binary.BigEndian, &family); err != nil {
+			return "", nil
+		}
+		var fields [4][]byte // address, display number, auth name, auth data
+		for i := range fields {
+			if fields[i], err = readField(); err != nil {
+				return "", nil
+			}
+		}
+		address, number, authName := string(fields[0]), string(fields[1]), string(fields[2])
+		if authName != x11AuthorizationCookie || (number != "" && number != displayNum) {
+			continue
+		}
+		if family == x11FamilyWild || (family == x11FamilyLocal && address == hostname) {
+			return authName, fields[3]
+		}
+	}
+}
+
+// request sends a request and, if it has one, waits for its reply. Events that arrive
+// in the meantime are noted.
+func (x *x11Conn) request(request []byte, hasReply bool) ([]byte, error) {
+	binary.LittleEndian.PutUint16(request[2:], uint16(len(request)/4))
+	if _, err := x.conn.Write(request); err != nil {
+		return nil, err
+	}
+	x.seq++
+	if !hasReply {
+		return nil, nil
+	}
+
+	for {
+		packet, err := x.readPacket()
+		if err != nil {
+			return nil, err
+		}
+		seq := binary.LittleEndian.Uint16(packet[2:])
+		switch packet[0] & 0x7f {
+		case 0:
+			if seq == x.seq {
+				return nil, &x11Error{code: packet[1]}
+			}
+		case 1:
+			if seq == x.seq {
+				return packet, nil
+			}
+		default:
+			x.noteEvent(packet)
+		}
+	}
+}
+
+// readPacket reads one reply, error or event
+func (x *x11Conn) readPacket() ([]byte, error) {
+	packet := make([]byte, x11PacketLength)
+	if _, err := io.ReadFull(x.reader, packet); err != nil {
+		return nil, err
+	}
+	if packet[0] == 1 {
+		extra := make([]byte, 4*int(binary.LittleEndian.Uint32(packet[4:])))
+		if _, err := io.ReadFull(x.reader, extra); err != nil {
+			return nil, err
+		}
+		packet = append(packet, extra...)
+	}
+	return packet, nil
+}
+
+func (x *x11Conn) noteEvent(packet []byte) {
+	if packet[0]&0x7f == x11PropertyNotify &&
+		binary.LittleEndian.Uint32(packet[4:]) == x.root &&
+		binary.LittleEndian.Uint32(packet[8:]) == x.watchedAtom {
+		x.changed = true
+	}
+}
+
+func (x *x11Conn) internAtom(name string) (uint32, error) {
+	request := []byte{x11OpInternAtom, 0, 0, 0}
+	request = appendUint16(request, uint16(len(name)))
+	request = append(request, 0, 0)
+	request = appendPadded(request, []byte(name))
+
+	reply, err := x.request(request, true)
+	if err != nil {
+		return 0, fmt.Errorf("failed to look up X atom %s: %w", name, err)
+	}
+	return binary.LittleEndian.Uint32(reply[8:]), nil
+}
+
+// watchRootProperty asks for PropertyNotify events of the root window, and makes
+// waitRootProperty wait for those of one property
+func (x *x11Conn) watchRootProperty(atom uint32) error {
+	request := []byte{x11OpChangeWindowAttributes, 0, 0, 0}
+	request = appendUint32(request, x.root)
+	request = appendUint32(request, x11CWEventMask)
+	request = appendUint32(request, x11PropertyChangeMask)
+
+	x.watchedAtom = atom
+	_, err := x.request(request, false)
+	return err
+}
+
+// waitRootProperty blocks until the watched property of the root window changes
+func (x *x11Conn) waitRootProperty() error {
+	for !x.changed {
+		packet, err := x.readPacket()
+		if err != nil {
+			return err
+		}
+		if packet[0] == 0 {
+			return &x11Error{code: packet[1]}
+		}
+		x.noteEvent(packet)
+	}
+	x.changed = false
+	return nil
+}
+
+// cardinalProperty reads a property holding a single 32-bit value, like a window or a
+// PID. It is 0 if the window doesn't have the property.
+func (x *x11Conn) cardinalProperty(window, atom uint32) (uint32, error) {
+	request := []byte{x11OpGetProperty, 0, 0, 0}
+	request = appendUint32(request, window)
+	request = appendUint32(request, atom)
+	request = appendUint32(request, 0) // AnyPropertyType
+	request = appendUint32(request, 0) // offset
+	request = appendUint32(request, x11GetPropertyMaxLength)
+
+	reply, err := x.request(request, true)
+	if err != nil {
+		return 0, err
+	}
+	format, valueLength := reply[1], binary.LittleEndian.Uint32(reply[16:])
+	if format != 32 || valueLength < 1 || len(reply) < x11ReplyFixedLength+4 {
+		return 0, nil
+	}
+	return binary.LittleEndian.Uint32(reply[x11ReplyFixedLength:]), nil
+}
+
+func (x *x11Conn) Close() error {
+	return x.conn.Close()
+}
+
+// watchX11ActiveWindow calls onChange with the process of every window the window
+// manager makes the active one, until the connection to the X server fails
+func watchX11ActiveWindow(onChange func(processName string)) error {
+	x, err := dialX11(os.Getenv("DISPLAY"))
+	if err != nil {
+		return err
+	}
+	defer x.Close()
+
+	activeWindow, err := x.internAtom("_NET_ACTIVE_WINDOW")
+	if err != nil {
+		return err
+	}
+	wmPID, err := x.internAtom("_NET_WM_PID")
+	if err != nil {
+		return err
+	}
+	if err := x.watchRootProperty(activeWindow); err != nil {
+		return fmt.Errorf("failed to watch the active window: %w", err)
+	}
+
+	for {
+		window, err := x.cardinalProperty(x.root, activeWindow)
+		if err != nil {
+			return fmt.Errorf("failed to read the active window: %w", err)
+		}
+
+		// The window may be gone already, which the server answers with an error
+		if window != 0 {
+			if pid, err := x.cardinalProperty(window, wmPID); err == nil && pid != 0 {
+				if processName := processNameOfPID(pid); processName != "" {
+					onChange(processName)
+				}
+			} else if _, gone := err.(*x11Error); err != nil && !gone {
+				return fmt.Errorf("failed to read the active window's process: %w", err)
+			}
+		}
+
+		if err := x.waitRootProperty(); err != nil {
+			return fmt.Errorf("failed to watch the active window: %w", err)
+		}
+	}
+}
+
+func appendUint16(b []byte, v uint16) []byte {
+	return append(b, byte(v), byte(v>>8))
+}
+
+func appendUint32(b []byte, v uint32) []byte {
+	return append(b, byte(v), byte(v>>8), byte(v>>16), byte(v>>24))
+}
+
+// appendPadded appends data and pads it to a multiple of four bytes
+func appendPadded(b []byte, data []byte) []byte {
+	b = append(b, data...)
+	return append(b, make([]byte, pad4(len(data))-len(data))...)
+}
+
+func pad4(n int) int {
+	return (n + 3) &^ 3
+}
@@ -0,0 +1,95 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+// Predefined atoms and the request a window manager sets properties with
+const (
+	x11OpChangeProperty = 18
+	x11AtomCardinal     = 6
+	x11AtomWindow       = 33
+)
+
+// setCardinalProperty replaces a window's property with a single 32-bit value
+func (x *x11Conn) setCardinalProperty(window, atom, propertyType, value uint32) error {
+	request := []byte{x11OpChangeProperty, 0, 0, 0} // mode Replace
+	request = appendUint32(request, window)
+	request = appendUint32(request, atom)
+	request = appendUint32(request, propertyType)
+	request = append(request, 32, 0, 0, 0) // format
+	request = appendUint32(request, 1)
+	request = appendUint32(request, value)
+
+	_, err := x.request(request, false)
+	return err
+}
+
+func TestDialX11BadDisplay(t *testing.T) {
+	for _, display := range []string{"", "localhost", ":x", "host:"} {
+		if x, err := dialX11(display); err == nil {
+			x.Close()
+			t.Errorf("dialX11(%q) connected", display)
+		}
+	}
+}
+
+// TestX11ActiveWindow plays the window manager on an X server without one, like Xvfb:
+// it names the root window the active one and gives it this process' PID
+func TestX11ActiveWindow(t *testing.T) {
+	display := os.Getenv("DISPLAY")
+	if display == "" {
+		t.Skip("DISPLAY is not set; run under Xvfb")
+	}
+
+	wm, err := dialX11(display)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer wm.Close()
+	activeWindow, err := wm.internAtom("_NET_ACTIVE_WINDOW")
+	if err != nil {
+		t.Fatal(err)
+	}
+	wmPID, err := wm.internAtom("_NET_WM_PID")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := wm.setCardinalProperty(wm.root, activeWindow, x11AtomWindow, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := wm.setCardinalProperty(wm.root, wmPID, x11AtomCardinal, uint32(os.Getpid())); err != nil {
+		t.Fatal(err)
+	}
+
+	changes := make(chan string, 8)
+	go watchX11ActiveWindow(func(processName string) { changes <- processName })
+
+	want := processNameOfPID(uint32(os.Getpid()))
+	expectChange := func() {
+		t.Helper()
+		select {
+		case got := <-changes:
+			if got != want {
+				t.Errorf("active process %q, want %q", got, want)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatal("active window change not noticed")
+		}
+	}
+
+	// Activated, deactivated and activated again: two changes, none for no window
+	for _, window := range []uint32{wm.root, 0, wm.root} {
+		if err := wm.setCardinalProperty(wm.root, activeWindow, x11AtomWindow, window); err != nil {
+			t.Fatal(err)
+		}
+		if window != 0 {
+			expectChange()
+		}
+	}
+}